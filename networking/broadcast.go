@@ -0,0 +1,220 @@
+package networking
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/blindbit-lib/utils"
+)
+
+var ErrTxRejected = errors.New("transaction was rejected")
+
+// Broadcaster pushes a signed transaction to the network.
+// The returned txid has the byte order used for display (same as wallet.OwnedUTXO.Txid).
+type Broadcaster interface {
+	Broadcast(rawTx []byte) ([32]byte, error)
+}
+
+// ClientEsplora broadcasts with the Esplora API (POST /tx), e.g. https://mempool.space/api
+type ClientEsplora struct {
+	BaseURL string
+}
+
+func (c *ClientEsplora) Broadcast(rawTx []byte) ([32]byte, error) {
+	url := fmt.Sprintf("%s/tx", c.BaseURL)
+
+	// HTTP POST request, the body is the hex encoded transaction
+	resp, err := http.Post(url, "text/plain", strings.NewReader(hex.EncodeToString(rawTx)))
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return [32]byte{}, err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	// Read response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return [32]byte{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("%w: status %d: %s", ErrTxRejected, resp.StatusCode, body)
+		logging.L.Err(err).Msg("")
+		return [32]byte{}, err
+	}
+
+	// the response is the txid
+	return parseTxid(strings.TrimSpace(string(body)))
+}
+
+// ClientBitcoinCore broadcasts with the sendrawtransaction JSON-RPC of Bitcoin Core
+type ClientBitcoinCore struct {
+	// URL of the rpc server, e.g. http://127.0.0.1:8332
+	URL      string
+	User     string
+	Password string
+}
+
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      string `json:"id"`
+	Method  string `json:"method"`
+	Params  []any  `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+func (c *ClientBitcoinCore) Broadcast(rawTx []byte) ([32]byte, error) {
+	var txid string
+	err := c.call("sendrawtransaction", []any{hex.EncodeToString(rawTx)}, &txid)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return parseTxid(txid)
+}
+
+func (c *ClientBitcoinCore) call(method string, params []any, result any) error {
+	payload, err := json.Marshal(rpcRequest{
+		JSONRPC: "1.0",
+		ID:      "blindbit",
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.URL, bytes.NewReader(payload))
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.User != "" || c.Password != "" {
+		req.SetBasicAuth(c.User, c.Password)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+
+	// Bitcoin Core answers rpc errors with a status 500 and the error in the body
+	var data rpcResponse
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		err = fmt.Errorf("rpc %s failed with status %d: %s", method, resp.StatusCode, body)
+		logging.L.Err(err).Msg("")
+		return err
+	}
+	if data.Error != nil {
+		err = fmt.Errorf("%w: rpc error %d: %s", ErrTxRejected, data.Error.Code, data.Error.Message)
+		logging.L.Err(err).Msg("")
+		return err
+	}
+
+	return json.Unmarshal(data.Result, result)
+}
+
+// MemoryBroadcaster keeps the transactions in memory instead of broadcasting them, e.g. for tests.
+// If Err is set every broadcast fails with it. The zero value is ready to use.
+type MemoryBroadcaster struct {
+	Err error
+
+	mu           sync.Mutex
+	transactions map[[32]byte][]byte
+	order        [][32]byte
+}
+
+func NewMemoryBroadcaster() *MemoryBroadcaster {
+	return &MemoryBroadcaster{
+		transactions: make(map[[32]byte][]byte),
+	}
+}
+
+func (m *MemoryBroadcaster) Broadcast(rawTx []byte) ([32]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return [32]byte{}, m.Err
+	}
+
+	var tx wire.MsgTx
+	err := tx.Deserialize(bytes.NewReader(rawTx))
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("%w: %w", ErrTxRejected, err)
+	}
+
+	if m.transactions == nil {
+		m.transactions = make(map[[32]byte][]byte)
+	}
+
+	txHash := tx.TxHash()
+	txid := utils.ConvertToFixedLength32(utils.ReverseBytesCopy(txHash[:]))
+	if _, ok := m.transactions[txid]; !ok {
+		m.order = append(m.order, txid)
+	}
+	m.transactions[txid] = append([]byte(nil), rawTx...)
+	return txid, nil
+}
+
+// Transaction returns the broadcast transaction with txid
+func (m *MemoryBroadcaster) Transaction(txid [32]byte) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rawTx, ok := m.transactions[txid]
+	return rawTx, ok
+}
+
+// Transactions returns all broadcast transactions in the order they were broadcast
+func (m *MemoryBroadcaster) Transactions() [][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([][]byte, len(m.order))
+	for i, txid := range m.order {
+		out[i] = m.transactions[txid]
+	}
+	return out
+}
+
+func parseTxid(txidHex string) ([32]byte, error) {
+	txid, err := hex.DecodeString(txidHex)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	if len(txid) != 32 {
+		return [32]byte{}, fmt.Errorf("invalid txid length %d", len(txid))
+	}
+	return utils.ConvertToFixedLength32(txid), nil
+}
@@ -0,0 +1,145 @@
+package networking
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/setavenger/blindbit-lib/utils"
+)
+
+// testRawTx returns a serialised transaction and its txid in display order
+func testRawTx(t *testing.T, lockTime uint32) ([]byte, [32]byte) {
+	t.Helper()
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(10_000, []byte{0x51, 0x20, 33: 0}))
+	tx.LockTime = lockTime
+
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	txHash := tx.TxHash()
+	return buf.Bytes(), utils.ConvertToFixedLength32(utils.ReverseBytesCopy(txHash[:]))
+}
+
+func TestMemoryBroadcasterZeroValue(t *testing.T) {
+	var m MemoryBroadcaster
+	rawTx, want := testRawTx(t, 0)
+
+	if _, ok := m.Transaction(want); ok {
+		t.Fatal("found a transaction before broadcasting")
+	}
+
+	txid, err := m.Broadcast(rawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txid != want {
+		t.Fatalf("txid %x, want %x", txid, want)
+	}
+	got, ok := m.Transaction(txid)
+	if !ok || !bytes.Equal(got, rawTx) {
+		t.Fatalf("transaction %x", got)
+	}
+}
+
+func TestMemoryBroadcaster(t *testing.T) {
+	m := NewMemoryBroadcaster()
+	first, _ := testRawTx(t, 1)
+	second, _ := testRawTx(t, 2)
+
+	for _, rawTx := range [][]byte{first, second, first} {
+		if _, err := m.Broadcast(rawTx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := m.Transactions(); len(got) != 2 || !bytes.Equal(got[0], first) || !bytes.Equal(got[1], second) {
+		t.Fatalf("%d transactions", len(got))
+	}
+
+	if _, err := m.Broadcast([]byte{0x02}); !errors.Is(err, ErrTxRejected) {
+		t.Fatalf("invalid transaction: %v", err)
+	}
+
+	m.Err = errors.New("offline")
+	if _, err := m.Broadcast(first); !errors.Is(err, m.Err) {
+		t.Fatalf("error %v", err)
+	}
+}
+
+func TestClientEsploraBroadcast(t *testing.T) {
+	rawTx, want := testRawTx(t, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.URL.Path != "/tx" || string(body) != hex.EncodeToString(rawTx) {
+			http.Error(w, "sendrawtransaction RPC error: bad-txns", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(hex.EncodeToString(want[:])))
+	}))
+	defer server.Close()
+
+	client := &ClientEsplora{BaseURL: server.URL}
+	txid, err := client.Broadcast(rawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txid != want {
+		t.Fatalf("txid %x, want %x", txid, want)
+	}
+
+	if _, err = client.Broadcast(rawTx[1:]); !errors.Is(err, ErrTxRejected) {
+		t.Fatalf("error %v, want ErrTxRejected", err)
+	}
+}
+
+func TestClientBitcoinCoreBroadcast(t *testing.T) {
+	rawTx, want := testRawTx(t, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req rpcRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "pass" {
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		if req.Method != "sendrawtransaction" || len(req.Params) != 1 || req.Params[0] != hex.EncodeToString(rawTx) {
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"result": nil,
+				"error":  rpcError{Code: -26, Message: "bad-txns"},
+			})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"result": hex.EncodeToString(want[:]), "error": nil})
+	}))
+	defer server.Close()
+
+	client := &ClientBitcoinCore{URL: server.URL, User: "user", Password: "pass"}
+	txid, err := client.Broadcast(rawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txid != want {
+		t.Fatalf("txid %x, want %x", txid, want)
+	}
+
+	if _, err = client.Broadcast(rawTx[1:]); !errors.Is(err, ErrTxRejected) {
+		t.Fatalf("error %v, want ErrTxRejected", err)
+	}
+
+	client.Password = "wrong"
+	if _, err = client.Broadcast(rawTx); err == nil || errors.Is(err, ErrTxRejected) {
+		t.Fatalf("unauthorized: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package networking
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/blindbit-lib/types"
+)
+
+// FeeTarget is the urgency of a transaction
+type FeeTarget int8
+
+const (
+	// FeeTargetFast aims for the next block
+	FeeTargetFast FeeTarget = iota + 1
+	// FeeTargetNormal aims for confirmation within about half an hour
+	FeeTargetNormal
+	// FeeTargetEconomy aims for eventual confirmation at a low fee rate
+	FeeTargetEconomy
+)
+
+func (t FeeTarget) String() string {
+	switch t {
+	case FeeTargetFast:
+		return "fast"
+	case FeeTargetNormal:
+		return "normal"
+	case FeeTargetEconomy:
+		return "economy"
+	default:
+		return fmt.Sprintf("unknown(%d)", t)
+	}
+}
+
+var ErrUnknownFeeTarget = errors.New("unknown fee target")
+
+// FeeEstimator returns the fee rate to use for a FeeTarget
+type FeeEstimator interface {
+	EstimateFeeRate(target FeeTarget) (types.FeeRate, error)
+}
+
+// ClientMempoolFees estimates fees with a mempool.space style API (GET /v1/fees/recommended).
+// BaseURL includes the api prefix, e.g. https://mempool.space/api
+type ClientMempoolFees struct {
+	BaseURL string
+}
+
+// RecommendedFeesRaw is the response of /v1/fees/recommended, all rates in sat/vB
+type RecommendedFeesRaw struct {
+	FastestFee  float64 `json:"fastestFee"`
+	HalfHourFee float64 `json:"halfHourFee"`
+	HourFee     float64 `json:"hourFee"`
+	EconomyFee  float64 `json:"economyFee"`
+	MinimumFee  float64 `json:"minimumFee"`
+}
+
+func (c *ClientMempoolFees) EstimateFeeRate(target FeeTarget) (types.FeeRate, error) {
+	fees, err := c.GetRecommendedFees()
+	if err != nil {
+		return types.FeeRate{}, err
+	}
+
+	var satPerVByte float64
+	switch target {
+	case FeeTargetFast:
+		satPerVByte = fees.FastestFee
+	case FeeTargetNormal:
+		satPerVByte = fees.HalfHourFee
+	case FeeTargetEconomy:
+		satPerVByte = fees.EconomyFee
+	default:
+		return types.FeeRate{}, fmt.Errorf("%w: %s", ErrUnknownFeeTarget, target)
+	}
+
+	// never go below what nodes relay
+	satPerVByte = max(satPerVByte, fees.MinimumFee)
+
+	feeRate := types.FeeRateFromSatPerVByte(satPerVByte)
+	if feeRate.IsZero() {
+		return types.FeeRate{}, fmt.Errorf("invalid fee rate %f sat/vB for target %s", satPerVByte, target)
+	}
+	return feeRate, nil
+}
+
+func (c *ClientMempoolFees) GetRecommendedFees() (*RecommendedFeesRaw, error) {
+	url := fmt.Sprintf("%s/v1/fees/recommended", c.BaseURL)
+
+	// HTTP GET request
+	resp, err := http.Get(url)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return nil, err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	// Read response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("fee estimation failed with status %d: %s", resp.StatusCode, body)
+		logging.L.Err(err).Msg("")
+		return nil, err
+	}
+
+	var data RecommendedFeesRaw
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+// StaticFeeEstimator always returns the same fee rates, e.g. for tests or regtest
+type StaticFeeEstimator struct {
+	Fast    types.FeeRate
+	Normal  types.FeeRate
+	Economy types.FeeRate
+}
+
+// NewStaticFeeEstimator uses feeRate for all targets
+func NewStaticFeeEstimator(feeRate types.FeeRate) *StaticFeeEstimator {
+	return &StaticFeeEstimator{
+		Fast:    feeRate,
+		Normal:  feeRate,
+		Economy: feeRate,
+	}
+}
+
+func (s *StaticFeeEstimator) EstimateFeeRate(target FeeTarget) (types.FeeRate, error) {
+	switch target {
+	case FeeTargetFast:
+		return s.Fast, nil
+	case FeeTargetNormal:
+		return s.Normal, nil
+	case FeeTargetEconomy:
+		return s.Economy, nil
+	default:
+		return types.FeeRate{}, fmt.Errorf("%w: %s", ErrUnknownFeeTarget, target)
+	}
+}
@@ -0,0 +1,84 @@
+package networking
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/setavenger/blindbit-lib/types"
+)
+
+func newTestMempoolFees(t *testing.T, fees RecommendedFeesRaw, status int) *ClientMempoolFees {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/fees/recommended", func(w http.ResponseWriter, _ *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		writeTestJSON(w, fees)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return &ClientMempoolFees{BaseURL: server.URL}
+}
+
+func TestClientMempoolFees(t *testing.T) {
+	client := newTestMempoolFees(t, RecommendedFeesRaw{
+		FastestFee:  12.5,
+		HalfHourFee: 8,
+		HourFee:     5,
+		EconomyFee:  0.5,
+		MinimumFee:  1,
+	}, http.StatusOK)
+
+	for target, want := range map[FeeTarget]types.FeeRate{
+		FeeTargetFast:   types.FeeRateFromSatPerKvB(12_500),
+		FeeTargetNormal: types.FeeRateFromSatPerVByte(8),
+		// the minimum fee is used instead of the economy fee below it
+		FeeTargetEconomy: types.FeeRateFromSatPerVByte(1),
+	} {
+		feeRate, err := client.EstimateFeeRate(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if feeRate != want {
+			t.Errorf("%s: %s, want %s", target, feeRate, want)
+		}
+	}
+
+	if _, err := client.EstimateFeeRate(FeeTarget(0)); !errors.Is(err, ErrUnknownFeeTarget) {
+		t.Errorf("unknown target: %v", err)
+	}
+}
+
+func TestClientMempoolFeesErrors(t *testing.T) {
+	if _, err := newTestMempoolFees(t, RecommendedFeesRaw{}, http.StatusServiceUnavailable).EstimateFeeRate(FeeTargetFast); err == nil {
+		t.Error("estimated with a failed request")
+	}
+	if _, err := newTestMempoolFees(t, RecommendedFeesRaw{}, http.StatusOK).EstimateFeeRate(FeeTargetFast); err == nil {
+		t.Error("estimated a fee rate of zero")
+	}
+}
+
+func TestStaticFeeEstimator(t *testing.T) {
+	estimator := NewStaticFeeEstimator(types.FeeRateFromSatPerVByte(3))
+	estimator.Fast = types.FeeRateFromSatPerVByte(10)
+
+	for target, want := range map[FeeTarget]types.FeeRate{
+		FeeTargetFast:    types.FeeRateFromSatPerVByte(10),
+		FeeTargetNormal:  types.FeeRateFromSatPerVByte(3),
+		FeeTargetEconomy: types.FeeRateFromSatPerVByte(3),
+	} {
+		if feeRate, err := estimator.EstimateFeeRate(target); err != nil || feeRate != want {
+			t.Errorf("%s: %s %v", target, feeRate, err)
+		}
+	}
+	if _, err := estimator.EstimateFeeRate(FeeTarget(4)); !errors.Is(err, ErrUnknownFeeTarget) {
+		t.Errorf("unknown target: %v", err)
+	}
+	if name := FeeTarget(4).String(); name != "unknown(4)" {
+		t.Errorf("name %s", name)
+	}
+}
@@ -0,0 +1,303 @@
+package networking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/blindbit-lib/proto/pb"
+	"github.com/setavenger/blindbit-lib/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// ErrUnsupportedScript is returned by ConvertPbUTXO for scripts which are not 34 bytes long.
+// Those can not be taproot outputs and never belong to a silent payment wallet.
+var ErrUnsupportedScript = errors.New("unsupported script pub key length")
+
+var _ BlindBitConnector = (*ClientBlindBitGRPC)(nil)
+
+// ClientBlindBitGRPC implements BlindBitConnector on top of the gRPC OracleService.
+// The oracle serves txids and block hashes in the byte order its REST API encodes as hex (display order),
+// so they are used unchanged and match what ClientBlindBit returns.
+type ClientBlindBitGRPC struct {
+	Client  pb.OracleServiceClient
+	Timeout time.Duration // timeout per request, no timeout if zero
+
+	conn *grpc.ClientConn
+}
+
+// NewClientBlindBitGRPC connects to the oracle at target (host:port).
+// If no dial options are given the connection is established without transport security.
+func NewClientBlindBitGRPC(target string, opts ...grpc.DialOption) (*ClientBlindBitGRPC, error) {
+	if len(opts) == 0 {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		logging.L.Err(err).Str("target", target).Msg("failed to create grpc client")
+		return nil, err
+	}
+
+	return &ClientBlindBitGRPC{
+		Client: pb.NewOracleServiceClient(conn),
+		conn:   conn,
+	}, nil
+}
+
+// Close closes the underlying connection if it was created by NewClientBlindBitGRPC
+func (c *ClientBlindBitGRPC) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+func (c *ClientBlindBitGRPC) requestContext() (context.Context, context.CancelFunc) {
+	if c.Timeout > 0 {
+		return context.WithTimeout(context.Background(), c.Timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+func (c *ClientBlindBitGRPC) GetChainTip() (uint64, error) {
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	resp, err := c.Client.GetBestBlockHeight(ctx, &emptypb.Empty{})
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return 0, err
+	}
+
+	return resp.GetBlockHeight(), nil
+}
+
+func (c *ClientBlindBitGRPC) GetTweaks(blockHeight, dustLimit uint64) ([][]byte, error) {
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	var resp *pb.TweakArray
+	var err error
+	if dustLimit > 0 {
+		resp, err = c.Client.GetTweakIndexArray(ctx, &pb.GetTweakIndexRequest{
+			BlockHeight: uint32(blockHeight),
+			DustLimit:   dustLimit,
+		})
+	} else {
+		resp, err = c.Client.GetTweakArray(ctx, &pb.BlockHeightRequest{
+			BlockHeight: blockHeight,
+		})
+	}
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return nil, err
+	}
+
+	for _, tweak := range resp.GetTweaks() {
+		if len(tweak) != 33 {
+			return nil, fmt.Errorf("invalid tweak length: %d", len(tweak))
+		}
+	}
+
+	return resp.GetTweaks(), nil
+}
+
+func (c *ClientBlindBitGRPC) GetFilter(
+	blockHeight uint64, filterType FilterType,
+) (*Filter, error) {
+	var pbFilterType pb.FilterType
+	switch filterType {
+	case SpentOutpointsFilterType:
+		pbFilterType = pb.FilterType_FILTER_TYPE_SPENT
+	case NewUTXOFilterType:
+		pbFilterType = pb.FilterType_FILTER_TYPE_NEW_UTXOS
+	default:
+		return nil, fmt.Errorf("unknown filter type: %s", filterType)
+	}
+
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	resp, err := c.Client.GetFilter(ctx, &pb.GetFilterRequest{
+		BlockHeight: uint32(blockHeight),
+		FilterType:  pbFilterType,
+	})
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return nil, err
+	}
+
+	filter, err := ConvertPbFilter(resp.GetFilterData(), blockHeight)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+func (c *ClientBlindBitGRPC) GetUTXOs(blockHeight uint64) ([]*UTXOServed, error) {
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	resp, err := c.Client.GetUTXOArray(ctx, &pb.BlockHeightRequest{
+		BlockHeight: blockHeight,
+	})
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return nil, err
+	}
+
+	utxos, err := ConvertPbUTXOs(resp.GetUtxos())
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return nil, err
+	}
+
+	return utxos, nil
+}
+
+func (c *ClientBlindBitGRPC) GetSpentOutpointsIndex(
+	blockHeight uint64,
+) (SpentOutpointsIndex, error) {
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	resp, err := c.Client.GetSpentOutpointsIndex(ctx, &pb.BlockHeightRequest{
+		BlockHeight: blockHeight,
+	})
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return SpentOutpointsIndex{}, err
+	}
+
+	output, err := ConvertPbSpentOutpointsIndex(resp)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return SpentOutpointsIndex{}, err
+	}
+
+	return output, nil
+}
+
+// ConvertPbUTXOs converts gRPC utxos into the networking representation.
+// UTXOs with ErrUnsupportedScript are skipped. The result is not nil, even if no utxo is left.
+func ConvertPbUTXOs(data []*pb.UTXO) ([]*UTXOServed, error) {
+	utxos := make([]*UTXOServed, 0, len(data))
+	for _, pbUTXO := range data {
+		utxo, err := ConvertPbUTXO(pbUTXO)
+		if errors.Is(err, ErrUnsupportedScript) {
+			logging.L.Debug().Hex("txid", pbUTXO.GetTxid()).Uint32("vout", pbUTXO.GetVout()).Msg("skipped non-taproot utxo")
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		utxos = append(utxos, utxo)
+	}
+	return utxos, nil
+}
+
+// ConvertPbUTXO converts a gRPC utxo into the networking representation.
+// The script is variable length in the proto, fails with ErrUnsupportedScript if it is not 34 bytes.
+func ConvertPbUTXO(data *pb.UTXO) (*UTXOServed, error) {
+	if len(data.GetTxid()) != 32 {
+		return nil, fmt.Errorf("invalid txid length: %d", len(data.GetTxid()))
+	}
+	if len(data.GetBlockHash()) != 32 {
+		return nil, fmt.Errorf("invalid block hash length: %d", len(data.GetBlockHash()))
+	}
+	if len(data.GetScriptPubKey()) != 34 {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedScript, len(data.GetScriptPubKey()))
+	}
+
+	return &UTXOServed{
+		Txid:         utils.ConvertToFixedLength32(data.GetTxid()),
+		Vout:         data.GetVout(),
+		Amount:       data.GetValue(),
+		ScriptPubKey: [34]byte(data.GetScriptPubKey()),
+		BlockHeight:  data.GetBlockHeight(),
+		BlockHash:    utils.ConvertToFixedLength32(data.GetBlockHash()),
+		Timestamp:    data.GetTimestamp(),
+		Spent:        data.GetSpent(),
+	}, nil
+}
+
+// ConvertPbFilter converts gRPC filter data into the networking representation
+func ConvertPbFilter(data *pb.FilterData, blockHeight uint64) (*Filter, error) {
+	if data == nil {
+		return nil, fmt.Errorf("no filter data")
+	}
+	if len(data.GetBlockhash()) != 32 {
+		return nil, fmt.Errorf("invalid block hash length: %d", len(data.GetBlockhash()))
+	}
+
+	return &Filter{
+		FilterType:  uint8(data.GetFilterType()),
+		BlockHeight: blockHeight,
+		BlockHash:   utils.ConvertToFixedLength32(data.GetBlockhash()),
+		Data:        data.GetData(),
+	}, nil
+}
+
+// ConvertPbSpentOutpointsIndex converts a gRPC spent outpoints index into the networking representation.
+// The proto serves 32 byte hashes, the index only keeps the first 8 bytes like the REST API serves them.
+// Shorter hashes are accepted as long as they have at least 8 bytes.
+func ConvertPbSpentOutpointsIndex(resp *pb.SpentOutpointsIndexResponse) (SpentOutpointsIndex, error) {
+	blockHash := resp.GetBlockIdentifier().GetBlockHash()
+	if len(blockHash) != 32 {
+		return SpentOutpointsIndex{}, fmt.Errorf("invalid block hash length: %d", len(blockHash))
+	}
+
+	output := SpentOutpointsIndex{
+		BlockHash: utils.ConvertToFixedLength32(blockHash),
+		Data:      make([][8]byte, len(resp.GetData())),
+	}
+	for i, hash := range resp.GetData() {
+		if len(hash) < 8 || len(hash) > 32 {
+			return SpentOutpointsIndex{}, fmt.Errorf("invalid spent outpoint hash length: %d", len(hash))
+		}
+		output.Data[i] = [8]byte(hash[:8])
+	}
+
+	return output, nil
+}
+
+// BlockBatchStreamer opens streams which serve the block batches of a height range in order
+type BlockBatchStreamer interface {
+	StreamBlockBatchSlim(ctx context.Context, start, end uint64) (pb.OracleService_StreamBlockBatchSlimClient, error)
+	StreamBlockBatchFull(ctx context.Context, start, end uint64) (pb.OracleService_StreamBlockBatchFullClient, error)
+}
+
+var _ BlockBatchStreamer = (*ClientBlindBitGRPC)(nil)
+
+// StreamBlockBatchSlim opens a stream of slim block batches from start to end.
+// Timeout is not applied as streams are long living, cancel ctx to close the stream.
+func (c *ClientBlindBitGRPC) StreamBlockBatchSlim(
+	ctx context.Context, start, end uint64,
+) (
+	pb.OracleService_StreamBlockBatchSlimClient, error,
+) {
+	return c.Client.StreamBlockBatchSlim(ctx, &pb.RangedBlockHeightRequest{
+		Start: start,
+		End:   end,
+	})
+}
+
+// StreamBlockBatchFull opens a stream of full block batches from start to end.
+// Timeout is not applied as streams are long living, cancel ctx to close the stream.
+func (c *ClientBlindBitGRPC) StreamBlockBatchFull(
+	ctx context.Context, start, end uint64,
+) (
+	pb.OracleService_StreamBlockBatchFullClient, error,
+) {
+	return c.Client.StreamBlockBatchFull(ctx, &pb.RangedBlockHeightRequest{
+		Start: start,
+		End:   end,
+	})
+}
@@ -0,0 +1,334 @@
+package networking
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/blindbit-lib/proto/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestMain(m *testing.M) {
+	logging.SetLogLevel(zerolog.Disabled)
+	os.Exit(m.Run())
+}
+
+const testHeight = 840000
+
+// testOracleData is a block as the oracle stores it.
+// Hashes are in display order, the order the REST API hex encodes them.
+type testOracleData struct {
+	blockHash []byte
+	utxos     []*pb.UTXO
+	filter    []byte
+	spent     [][]byte
+}
+
+func newTestOracleData(t *testing.T) *testOracleData {
+	t.Helper()
+	return &testOracleData{
+		// display order, leading zeros of the block hash first
+		blockHash: mustDecodeHex(t, "0000000000000000000320283a032748cef8227873ff4872689bf23f1cda83a5"),
+		utxos: []*pb.UTXO{
+			{
+				Txid:         mustDecodeHex(t, "a1075db55d416d3ca199f55b6084e2115b9345e16c5cf302fc80e9d5fbf5d48d"),
+				Vout:         1,
+				Value:        25_000,
+				ScriptPubKey: mustDecodeHex(t, "5120"+"5a8e36b1c3c8c8e2d0c6b5b2a1c5e0d1f3a4b6c7d8e9fa0b1c2d3e4f5a6b7c8d"),
+				BlockHeight:  testHeight,
+				BlockHash:    mustDecodeHex(t, "0000000000000000000320283a032748cef8227873ff4872689bf23f1cda83a5"),
+				Timestamp:    1713571767,
+			},
+			{
+				Txid:         mustDecodeHex(t, "b2075db55d416d3ca199f55b6084e2115b9345e16c5cf302fc80e9d5fbf5d48d"),
+				Value:        546,
+				ScriptPubKey: mustDecodeHex(t, "5120"+"6b8e36b1c3c8c8e2d0c6b5b2a1c5e0d1f3a4b6c7d8e9fa0b1c2d3e4f5a6b7c8d"),
+				BlockHeight:  testHeight,
+				BlockHash:    mustDecodeHex(t, "0000000000000000000320283a032748cef8227873ff4872689bf23f1cda83a5"),
+				Timestamp:    1713571767,
+				Spent:        true,
+			},
+		},
+		filter: mustDecodeHex(t, "02a0c5b93d80"),
+		// full 32 byte hashes like the proto documents them
+		spent: [][]byte{
+			mustDecodeHex(t, "0102030405060708a1a2a3a4a5a6a7a8b1b2b3b4b5b6b7b8c1c2c3c4c5c6c7c8"),
+			mustDecodeHex(t, "1112131415161718a1a2a3a4a5a6a7a8b1b2b3b4b5b6b7b8c1c2c3c4c5c6c7c8"),
+		},
+	}
+}
+
+// testGRPCOracle serves testOracleData over the gRPC OracleService
+type testGRPCOracle struct {
+	pb.UnimplementedOracleServiceServer
+	data *testOracleData
+}
+
+func (o *testGRPCOracle) GetBestBlockHeight(context.Context, *emptypb.Empty) (*pb.BlockHeightResponse, error) {
+	return &pb.BlockHeightResponse{BlockHeight: testHeight}, nil
+}
+
+func (o *testGRPCOracle) blockIdentifier() *pb.BlockIdentifier {
+	return &pb.BlockIdentifier{BlockHash: o.data.blockHash, BlockHeight: testHeight}
+}
+
+func (o *testGRPCOracle) GetUTXOArray(context.Context, *pb.BlockHeightRequest) (*pb.UTXOArrayResponse, error) {
+	return &pb.UTXOArrayResponse{BlockIdentifier: o.blockIdentifier(), Utxos: o.data.utxos}, nil
+}
+
+func (o *testGRPCOracle) GetFilter(_ context.Context, req *pb.GetFilterRequest) (*pb.FilterResponse, error) {
+	return &pb.FilterResponse{
+		BlockIdentifier: o.blockIdentifier(),
+		FilterData: &pb.FilterData{
+			Blockhash:  o.data.blockHash,
+			FilterType: req.GetFilterType(),
+			Data:       o.data.filter,
+		},
+	}, nil
+}
+
+func (o *testGRPCOracle) GetSpentOutpointsIndex(context.Context, *pb.BlockHeightRequest) (*pb.SpentOutpointsIndexResponse, error) {
+	return &pb.SpentOutpointsIndexResponse{BlockIdentifier: o.blockIdentifier(), Data: o.data.spent}, nil
+}
+
+// newTestGRPCClient serves data over an in-memory gRPC connection
+func newTestGRPCClient(t *testing.T, data *testOracleData) *ClientBlindBitGRPC {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	pb.RegisterOracleServiceServer(server, &testGRPCOracle{data: data})
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	client, err := NewClientBlindBitGRPC(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return client
+}
+
+// newTestHTTPClient serves data like the REST API of the oracle, only taproot utxos are served
+func newTestHTTPClient(t *testing.T, data *testOracleData) *ClientBlindBit {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/block-height", func(w http.ResponseWriter, _ *http.Request) {
+		writeTestJSON(w, ChainTipRaw{BlockHeight: testHeight})
+	})
+	mux.HandleFunc(fmt.Sprintf("/utxos/%d", testHeight), func(w http.ResponseWriter, _ *http.Request) {
+		var utxos []UTXORaw
+		for _, utxo := range data.utxos {
+			if len(utxo.GetScriptPubKey()) != 34 {
+				continue
+			}
+			utxos = append(utxos, UTXORaw{
+				Txid:         hex.EncodeToString(utxo.GetTxid()),
+				Vout:         utxo.GetVout(),
+				Amount:       utxo.GetValue(),
+				ScriptPubKey: hex.EncodeToString(utxo.GetScriptPubKey()),
+				BlockHeight:  utxo.GetBlockHeight(),
+				BlockHash:    hex.EncodeToString(utxo.GetBlockHash()),
+				Timestamp:    utxo.GetTimestamp(),
+				Spent:        utxo.GetSpent(),
+			})
+		}
+		writeTestJSON(w, utxos)
+	})
+	mux.HandleFunc(fmt.Sprintf("/filter/%s/%d", NewUTXOFilterType, testHeight), func(w http.ResponseWriter, _ *http.Request) {
+		writeTestJSON(w, FilterRaw{
+			FilterType:  uint8(pb.FilterType_FILTER_TYPE_NEW_UTXOS),
+			BlockHeight: testHeight,
+			BlockHash:   hex.EncodeToString(data.blockHash),
+			Data:        hex.EncodeToString(data.filter),
+		})
+	})
+	mux.HandleFunc(fmt.Sprintf("/spent-index/%d", testHeight), func(w http.ResponseWriter, _ *http.Request) {
+		// the REST API serves the short hashes
+		spent := make([]string, len(data.spent))
+		for i, hash := range data.spent {
+			spent[i] = hex.EncodeToString(hash[:8])
+		}
+		writeTestJSON(w, SpentIndexRaw{BlockHash: hex.EncodeToString(data.blockHash), Data: spent})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return &ClientBlindBit{BaseURL: server.URL}
+}
+
+func writeTestJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// The gRPC client has to return hashes in the same byte order as the HTTP client for the same oracle data
+func TestGRPCClientMatchesHTTPClient(t *testing.T) {
+	data := newTestOracleData(t)
+	grpcClient := newTestGRPCClient(t, data)
+	httpClient := newTestHTTPClient(t, data)
+
+	grpcTip, err := grpcClient.GetChainTip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpTip, err := httpClient.GetChainTip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grpcTip != httpTip {
+		t.Fatalf("chain tip grpc %d http %d", grpcTip, httpTip)
+	}
+
+	grpcUTXOs, err := grpcClient.GetUTXOs(testHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpUTXOs, err := httpClient.GetUTXOs(testHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(grpcUTXOs, httpUTXOs) {
+		t.Fatalf("utxos differ\ngrpc %+v\nhttp %+v", grpcUTXOs, httpUTXOs)
+	}
+	if hex.EncodeToString(grpcUTXOs[0].Txid[:]) != hex.EncodeToString(data.utxos[0].GetTxid()) {
+		t.Fatalf("txid %x not in display order", grpcUTXOs[0].Txid)
+	}
+
+	grpcFilter, err := grpcClient.GetFilter(testHeight, NewUTXOFilterType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpFilter, err := httpClient.GetFilter(testHeight, NewUTXOFilterType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(grpcFilter, httpFilter) {
+		t.Fatalf("filters differ\ngrpc %+v\nhttp %+v", grpcFilter, httpFilter)
+	}
+
+	grpcIndex, err := grpcClient.GetSpentOutpointsIndex(testHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpIndex, err := httpClient.GetSpentOutpointsIndex(testHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(grpcIndex, httpIndex) {
+		t.Fatalf("spent indexes differ\ngrpc %+v\nhttp %+v", grpcIndex, httpIndex)
+	}
+}
+
+func TestGRPCClientSkipsNonTaprootUTXOs(t *testing.T) {
+	data := newTestOracleData(t)
+	// p2wpkh output in between the taproot outputs
+	p2wpkh := &pb.UTXO{
+		Txid:         mustDecodeHex(t, "c3075db55d416d3ca199f55b6084e2115b9345e16c5cf302fc80e9d5fbf5d48d"),
+		Value:        10_000,
+		ScriptPubKey: mustDecodeHex(t, "0014751e76e8199196d454941c45d1b3a323f1433bd6"),
+		BlockHeight:  testHeight,
+		BlockHash:    data.blockHash,
+	}
+	data.utxos = []*pb.UTXO{data.utxos[0], p2wpkh, data.utxos[1]}
+
+	utxos, err := newTestGRPCClient(t, data).GetUTXOs(testHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(utxos) != 2 || utxos[0].Vout != 1 || !utxos[1].Spent {
+		t.Fatalf("utxos %+v", utxos)
+	}
+
+	_, err = ConvertPbUTXO(p2wpkh)
+	if !errors.Is(err, ErrUnsupportedScript) {
+		t.Fatalf("error %v, want ErrUnsupportedScript", err)
+	}
+
+	// only non-taproot outputs, the result is still not nil
+	utxos, err = ConvertPbUTXOs([]*pb.UTXO{p2wpkh})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if utxos == nil || len(utxos) != 0 {
+		t.Fatalf("utxos %v", utxos)
+	}
+}
+
+func TestConvertPbUTXOInvalidLengths(t *testing.T) {
+	data := newTestOracleData(t)
+	valid := data.utxos[0]
+
+	shortTxid := proto.Clone(valid).(*pb.UTXO)
+	shortTxid.Txid = valid.Txid[:31]
+	shortHash := proto.Clone(valid).(*pb.UTXO)
+	shortHash.BlockHash = valid.BlockHash[:31]
+
+	for name, utxo := range map[string]*pb.UTXO{"txid": shortTxid, "block hash": shortHash} {
+		_, err := ConvertPbUTXOs([]*pb.UTXO{valid, utxo})
+		if err == nil || errors.Is(err, ErrUnsupportedScript) {
+			t.Errorf("%s: error %v", name, err)
+		}
+	}
+}
+
+func TestConvertPbSpentOutpointsIndex(t *testing.T) {
+	data := newTestOracleData(t)
+	identifier := &pb.BlockIdentifier{BlockHash: data.blockHash, BlockHeight: testHeight}
+
+	for _, length := range []int{8, 32} {
+		index, err := ConvertPbSpentOutpointsIndex(&pb.SpentOutpointsIndexResponse{
+			BlockIdentifier: identifier,
+			Data:            [][]byte{data.spent[0][:length]},
+		})
+		if err != nil {
+			t.Fatalf("length %d: %v", length, err)
+		}
+		if len(index.Data) != 1 || index.Data[0] != [8]byte(data.spent[0][:8]) {
+			t.Fatalf("length %d: index %x", length, index.Data)
+		}
+	}
+
+	for _, hash := range [][]byte{data.spent[0][:7], append(data.spent[0], 0)} {
+		_, err := ConvertPbSpentOutpointsIndex(&pb.SpentOutpointsIndexResponse{
+			BlockIdentifier: identifier,
+			Data:            [][]byte{hash},
+		})
+		if err == nil {
+			t.Fatalf("accepted a hash of length %d", len(hash))
+		}
+	}
+}
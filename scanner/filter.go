@@ -0,0 +1,97 @@
+package scanner
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil/gcs"
+	"github.com/btcsuite/btcd/btcutil/gcs/builder"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/setavenger/blindbit-lib/networking"
+	"github.com/setavenger/blindbit-lib/utils"
+	"github.com/setavenger/go-bip352"
+)
+
+// MatchFilter checks whether any of the values is (probably) contained in the filter.
+// The filters served by the oracle are BIP158 style GCS filters (default P and M)
+// keyed with the block hash of the block they were built for.
+// A false positive is possible, a false negative is not.
+func MatchFilter(filter *networking.Filter, values [][]byte) (bool, error) {
+	if filter == nil {
+		return false, fmt.Errorf("no filter provided")
+	}
+	if len(values) == 0 {
+		return false, nil
+	}
+
+	gcsFilter, err := gcs.FromNBytes(builder.DefaultP, builder.DefaultM, filter.Data)
+	if err != nil {
+		return false, err
+	}
+
+	// the oracle serves the block hash in the human-readable (reversed) byte order
+	blockHash, err := chainhash.NewHash(utils.ReverseBytesCopy(filter.BlockHash[:]))
+	if err != nil {
+		return false, err
+	}
+
+	key := builder.DeriveKey(blockHash)
+	return gcsFilter.HashMatchAny(key, values)
+}
+
+// candidateOutputs computes the x-only outputs (k = 0) the wallet would receive for every tweak.
+// Labelled outputs for all scan labels are included as well.
+// The negated label case does not need an extra entry as it results in the same x-only key.
+func (s *Scanner) candidateOutputs(tweaks [][]byte) ([][]byte, error) {
+	labels := s.scanLabels()
+	spendPubKey := s.Wallet.PubKeySpend.ToArray()
+	scanKey := s.Wallet.SecretKeyScan.ToArray()
+
+	candidates := make([][]byte, 0, len(tweaks)*(len(labels)+1))
+	for _, tweak := range tweaks {
+		if len(tweak) != 33 {
+			return nil, fmt.Errorf("invalid tweak length: %d", len(tweak))
+		}
+		// the tweak is modified in place when computing the shared secret
+		publicComponent := utils.ConvertToFixedLength33(tweak)
+		sharedSecret, err := bip352.CreateSharedSecret(&publicComponent, &scanKey, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		tk, err := bip352.ComputeTK(sharedSecret, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		outputPubKey, err := bip352.AddPublicKeys(&spendPubKey, bip352.PubKeyFromSecKey(&tk))
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, outputPubKey[1:])
+
+		for _, label := range labels {
+			labelledOutput, err := bip352.AddPublicKeys(&outputPubKey, &label.PubKey)
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, labelledOutput[1:])
+		}
+	}
+
+	return candidates, nil
+}
+
+// blockMightContainOutputs checks the new-utxos filter of a block against the candidate outputs.
+// If false the utxos of the block do not have to be downloaded.
+func (s *Scanner) blockMightContainOutputs(
+	filter *networking.Filter,
+	tweaks [][]byte,
+) (
+	bool, error,
+) {
+	candidates, err := s.candidateOutputs(tweaks)
+	if err != nil {
+		return false, err
+	}
+	return MatchFilter(filter, candidates)
+}
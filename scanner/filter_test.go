@@ -0,0 +1,100 @@
+package scanner
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMatchFilter(t *testing.T) {
+	blockHash := testBlockHash(1, 0)
+	values := [][]byte{bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)}
+	filter := buildTestFilter(blockHash, values)
+
+	isMatch, err := MatchFilter(filter, [][]byte{bytes.Repeat([]byte{3}, 32), values[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isMatch {
+		t.Fatal("contained value did not match")
+	}
+
+	isMatch, err = MatchFilter(filter, [][]byte{bytes.Repeat([]byte{3}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isMatch {
+		t.Fatal("value not in the filter matched")
+	}
+
+	// the filter is keyed with the block hash, the key is taken from the internal byte order
+	filter.BlockHash[31] ^= 0xff
+	isMatch, err = MatchFilter(filter, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isMatch {
+		t.Fatal("matched with the key of another block")
+	}
+
+	if isMatch, err = MatchFilter(filter, nil); err != nil || isMatch {
+		t.Fatalf("no values: %t %v", isMatch, err)
+	}
+	if _, err = MatchFilter(nil, values); err == nil {
+		t.Fatal("matched without a filter")
+	}
+}
+
+func TestCandidateOutputs(t *testing.T) {
+	oracle := newTestOracle(5)
+	w := newTestWallet(t, 1)
+	s := NewScanner(oracle, w, 0)
+	labels := s.scanLabels()
+	oracle.pay(t, 2, 1, 10_000, w.Address())
+	oracle.pay(t, 3, 2, 10_000, labels[len(labels)-1].Address)
+
+	for _, height := range []uint64{2, 3} {
+		block := oracle.block(height)
+		candidates, err := s.candidateOutputs(block.tweaks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := len(labels) + 1; len(candidates) != want {
+			t.Fatalf("%d candidates, want %d", len(candidates), want)
+		}
+		found := false
+		for _, candidate := range candidates {
+			found = found || bytes.Equal(candidate, block.utxos[0].ScriptPubKey[2:])
+		}
+		if !found {
+			t.Fatalf("output of block %d is not a candidate", height)
+		}
+	}
+
+	if _, err := s.candidateOutputs([][]byte{make([]byte, 32)}); err == nil {
+		t.Fatal("accepted a tweak of 32 bytes")
+	}
+}
+
+// The utxos of a block are only downloaded if the new utxos filter matches
+func TestScanSkipsUTXOsOnFilterMiss(t *testing.T) {
+	oracle := newTestOracle(10)
+	w, other := newTestWallet(t, 1), newTestWallet(t, 2)
+	oracle.pay(t, 3, 1, 10_000, other.Address())
+	oracle.pay(t, 5, 2, 10_000, other.Address())
+	served := oracle.pay(t, 7, 3, 10_000, w.Address())
+
+	s := NewScanner(oracle, w, 0)
+	if err := s.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	if findUTXO(w, served[0]) == nil {
+		t.Fatal("utxo not found")
+	}
+	if n := oracle.count("utxos"); n != 1 {
+		t.Fatalf("%d utxo requests, want 1", n)
+	}
+	// blocks without tweaks do not need the filter either
+	if n := oracle.count("new-utxos"); n != 3 {
+		t.Fatalf("%d new utxos filter requests, want 3", n)
+	}
+}
@@ -0,0 +1,256 @@
+package scanner
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/gcs/builder"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/rs/zerolog"
+	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/blindbit-lib/networking"
+	"github.com/setavenger/blindbit-lib/types"
+	"github.com/setavenger/blindbit-lib/utils"
+	"github.com/setavenger/blindbit-lib/wallet"
+	"github.com/setavenger/go-bip352"
+)
+
+func TestMain(m *testing.M) {
+	logging.SetLogLevel(zerolog.Disabled)
+	os.Exit(m.Run())
+}
+
+// testBlock is a block as the oracle serves it
+type testBlock struct {
+	hash   [32]byte
+	tweaks [][]byte
+	utxos  []*networking.UTXOServed
+	spent  []*wallet.OwnedUTXO // outpoints spent in the block
+}
+
+// testOracle is an in-memory BlindBitConnector serving blocks 0 up to the highest one added.
+// Filters and the spent outpoints index are computed from the blocks like the oracle does.
+type testOracle struct {
+	mu       sync.Mutex
+	blocks   map[uint64]*testBlock
+	tip      uint64
+	requests map[string]int // request counts per method, filters per type
+}
+
+func newTestOracle(tip uint64) *testOracle {
+	o := &testOracle{
+		blocks:   make(map[uint64]*testBlock),
+		requests: make(map[string]int),
+	}
+	o.setTip(tip, 0)
+	return o
+}
+
+// setTip makes tip the chain tip, missing blocks are empty with hashes depending on fork
+func (o *testOracle) setTip(tip uint64, fork byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for height := uint64(0); height <= tip; height++ {
+		if _, ok := o.blocks[height]; !ok {
+			o.blocks[height] = &testBlock{hash: testBlockHash(height, fork)}
+		}
+	}
+	o.tip = tip
+}
+
+// replace replaces the blocks from height on with empty blocks of another fork
+func (o *testOracle) replace(height uint64, fork byte) {
+	o.mu.Lock()
+	for h := height; h <= o.tip; h++ {
+		o.blocks[h] = &testBlock{hash: testBlockHash(h, fork)}
+	}
+	o.mu.Unlock()
+}
+
+func (o *testOracle) block(height uint64) *testBlock {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.blocks[height]
+}
+
+func (o *testOracle) count(request string) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.requests[request]
+}
+
+func (o *testOracle) get(request string, height uint64) (*testBlock, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.requests[request]++
+	block, ok := o.blocks[height]
+	if !ok || height > o.tip {
+		return nil, fmt.Errorf("block %d not found", height)
+	}
+	return block, nil
+}
+
+func (o *testOracle) GetChainTip() (uint64, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.tip, nil
+}
+
+func (o *testOracle) GetFilter(height uint64, filterType networking.FilterType) (*networking.Filter, error) {
+	block, err := o.get(string(filterType), height)
+	if err != nil {
+		return nil, err
+	}
+
+	var values [][]byte
+	switch filterType {
+	case networking.NewUTXOFilterType:
+		for _, utxo := range block.utxos {
+			values = append(values, utxo.ScriptPubKey[2:])
+		}
+	case networking.SpentOutpointsFilterType:
+		for _, hash := range spentHashes(block) {
+			values = append(values, hash[:])
+		}
+	}
+	return buildTestFilter(block.hash, values), nil
+}
+
+func (o *testOracle) GetSpentOutpointsIndex(height uint64) (networking.SpentOutpointsIndex, error) {
+	block, err := o.get("spent-index", height)
+	if err != nil {
+		return networking.SpentOutpointsIndex{}, err
+	}
+	return networking.SpentOutpointsIndex{BlockHash: block.hash, Data: spentHashes(block)}, nil
+}
+
+func (o *testOracle) GetTweaks(height uint64, _ uint64) ([][]byte, error) {
+	block, err := o.get("tweaks", height)
+	if err != nil {
+		return nil, err
+	}
+	return block.tweaks, nil
+}
+
+func (o *testOracle) GetUTXOs(height uint64) ([]*networking.UTXOServed, error) {
+	block, err := o.get("utxos", height)
+	if err != nil {
+		return nil, err
+	}
+	return block.utxos, nil
+}
+
+// pay adds a transaction paying amount to every address to the block at height.
+// The input key is derived from seed. Returns the served utxos.
+func (o *testOracle) pay(t *testing.T, height uint64, seed byte, amount uint64, addresses ...string) []*networking.UTXOServed {
+	t.Helper()
+	block := o.block(height)
+
+	inputSecret := [32]byte{seed, 0xaa}
+	inputPubKey := bip352.PubKeyFromSecKey(&inputSecret)
+	vin := &bip352.Vin{
+		Txid:         [32]byte{seed},
+		Vout:         0,
+		SecretKey:    &inputSecret,
+		Taproot:      true,
+		ScriptPubKey: append([]byte{txscript.OP_1, txscript.OP_DATA_32}, inputPubKey[1:]...),
+	}
+
+	recipients := make([]*bip352.Recipient, len(addresses))
+	for i, address := range addresses {
+		recipients[i] = &bip352.Recipient{SilentPaymentAddress: address, Amount: amount}
+	}
+	err := bip352.SenderCreateOutputs(recipients, []*bip352.Vin{vin}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tweak = input_hash * A, taproot keys are even
+	publicKey := [33]byte{0x02}
+	copy(publicKey[1:], inputPubKey[1:])
+	inputHash, err := bip352.ComputeInputHash([]*bip352.Vin{vin}, &publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tweak := publicKey
+	_, err = bip352.CreateSharedSecret(&tweak, inputHash, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	block.tweaks = append(block.tweaks, tweak[:])
+	utxos := make([]*networking.UTXOServed, len(recipients))
+	for i, recipient := range recipients {
+		utxos[i] = &networking.UTXOServed{
+			Txid:        [32]byte{seed, 0xbb, byte(height)},
+			Vout:        uint32(i),
+			Amount:      recipient.Amount,
+			BlockHeight: height,
+			BlockHash:   block.hash,
+			Timestamp:   1700000000 + height,
+		}
+		utxos[i].ScriptPubKey[0] = txscript.OP_1
+		utxos[i].ScriptPubKey[1] = txscript.OP_DATA_32
+		copy(utxos[i].ScriptPubKey[2:], recipient.Output[:])
+		block.utxos = append(block.utxos, utxos[i])
+	}
+	return utxos
+}
+
+// spend adds a transaction spending the outpoint of utxo to the block at height
+func (o *testOracle) spend(height uint64, utxo *networking.UTXOServed) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	block := o.blocks[height]
+	block.spent = append(block.spent, &wallet.OwnedUTXO{Txid: utxo.Txid, Vout: utxo.Vout})
+}
+
+func spentHashes(block *testBlock) [][8]byte {
+	hashes := make([][8]byte, len(block.spent))
+	for i, utxo := range block.spent {
+		hash, err := SpentOutpointHash(utxo, block.hash)
+		if err != nil {
+			panic(err)
+		}
+		hashes[i] = hash
+	}
+	return hashes
+}
+
+func testBlockHash(height uint64, fork byte) [32]byte {
+	return [32]byte{byte(height), byte(height >> 8), fork, 0xbb}
+}
+
+func buildTestFilter(blockHash [32]byte, values [][]byte) *networking.Filter {
+	keyHash, err := chainhash.NewHash(utils.ReverseBytesCopy(blockHash[:]))
+	if err != nil {
+		panic(err)
+	}
+	filter, err := builder.WithKeyHash(keyHash).AddEntries(values).Build()
+	if err != nil {
+		panic(err)
+	}
+	data, err := filter.NBytes()
+	if err != nil {
+		panic(err)
+	}
+	return &networking.Filter{BlockHash: blockHash, Data: data}
+}
+
+func newTestWallet(t *testing.T, seed byte) *wallet.Wallet {
+	t.Helper()
+	w, err := wallet.NewWallet([32]byte{seed, 1}, [32]byte{seed, 2}, types.NetworkSignet, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func findUTXO(w *wallet.Wallet, served *networking.UTXOServed) *wallet.OwnedUTXO {
+	return w.FindUTXO(served.Txid, served.Vout)
+}
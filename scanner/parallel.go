@@ -0,0 +1,156 @@
+package scanner
+
+import (
+	"context"
+	"sync"
+
+	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/blindbit-lib/networking"
+)
+
+// windowFactor limits how far the fetching can run ahead of the commits (workers * windowFactor blocks)
+const windowFactor = 4
+
+type parallelResult struct {
+	height uint64
+	result *blockResult
+	err    error
+}
+
+// ScanParallel scans all blocks from start to end (both inclusive).
+// Blocks are fetched and evaluated by a pool of workers
+// but committed to the wallet strictly in height order,
+// so Wallet.LastScanHeight is always a safe point to resume from.
+func (s *Scanner) ScanParallel(ctx context.Context, start, end uint64, workers int) error {
+	if s.Wallet == nil {
+		return ErrNoWallet
+	}
+	if s.Client == nil {
+		return ErrNoClient
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	reorged, err := s.HandleReorg()
+	if err != nil {
+		return err
+	}
+	if reorged && s.NextHeight() < start {
+		start = s.NextHeight()
+	}
+	if start > end {
+		return nil
+	}
+
+	// computed upfront, the workers only read the label snapshot afterwards
+	err = s.refreshLabels()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	heights := make(chan uint64)
+	results := make(chan parallelResult, workers)
+	// every dispatched height holds a slot until it was committed
+	window := make(chan struct{}, workers*windowFactor)
+
+	go func() {
+		defer close(heights)
+		for height := start; height <= end; height++ {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case heights <- height:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for height := range heights {
+				result, err := s.fetchBlockWithSpentFilter(height)
+				select {
+				case results <- parallelResult{height: height, result: result, err: err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	pending := make(map[uint64]*blockResult)
+	next := start
+	for res := range results {
+		if res.err != nil {
+			logging.L.Err(res.err).Uint64("height", res.height).Msg("failed to fetch block")
+			return res.err
+		}
+		pending[res.height] = res.result
+
+		for {
+			result, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+
+			if result.labelCount < s.labelCount() {
+				// the label window grew after the block was fetched, it has to be scanned with the new labels
+				result, err = s.fetchBlockWithSpentFilter(next)
+				if err != nil {
+					return err
+				}
+			}
+
+			err = s.commitBlock(result)
+			if err != nil {
+				return err
+			}
+			next++
+			<-window
+		}
+	}
+
+	if next <= end {
+		// we only get here if the context was cancelled
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+// fetchBlockWithSpentFilter fetches a block and prefetches the spent filter,
+// so that committing the block only needs a request if the filter matches.
+func (s *Scanner) fetchBlockWithSpentFilter(height uint64) (*blockResult, error) {
+	result, err := s.fetchBlock(height)
+	if err != nil {
+		return nil, err
+	}
+
+	result.spentFilter, err = s.Client.GetFilter(height, networking.SpentOutpointsFilterType)
+	if err != nil {
+		logging.L.Err(err).Uint64("height", height).Msg("failed to get spent filter")
+		return nil, err
+	}
+	if result.blockHash == nil {
+		result.blockHash = &result.spentFilter.BlockHash
+	}
+
+	return result, nil
+}
@@ -0,0 +1,107 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/setavenger/blindbit-lib/wallet"
+)
+
+// slowOracle serves low blocks slower than high ones, so workers finish out of order
+type slowOracle struct {
+	*testOracle
+	tip uint64
+}
+
+func (o *slowOracle) GetTweaks(height uint64, dustLimit uint64) ([][]byte, error) {
+	if height <= o.tip {
+		time.Sleep(time.Duration(o.tip-height) * time.Millisecond)
+	}
+	return o.testOracle.GetTweaks(height, dustLimit)
+}
+
+func TestScanParallelCommitsInOrder(t *testing.T) {
+	oracle := newTestOracle(20)
+	w := newTestWallet(t, 1)
+	served := oracle.pay(t, 3, 1, 10_000, w.Address())
+	oracle.spend(4, served[0])
+	later := oracle.pay(t, 15, 2, 20_000, w.Address())
+
+	s := NewScanner(&slowOracle{testOracle: oracle, tip: 20}, w, 0)
+	if err := s.ScanParallel(context.Background(), 1, 20, 8); err != nil {
+		t.Fatal(err)
+	}
+
+	// the spend in block 4 is only seen if block 3 was committed before
+	utxo := findUTXO(w, served[0])
+	if utxo == nil || utxo.State != wallet.StateSpent || utxo.SpentHeight != 4 {
+		t.Fatalf("utxo %+v", utxo)
+	}
+	if utxo := findUTXO(w, later[0]); utxo == nil || utxo.Height != 15 {
+		t.Fatalf("utxo %+v", utxo)
+	}
+	if w.LastScanHeight != 20 {
+		t.Fatalf("last scan height %d", w.LastScanHeight)
+	}
+	// checkpoints at lower heights replace the higher ones, all are kept only if committed in order
+	if len(w.Checkpoints) != 20 {
+		t.Fatalf("%d checkpoints", len(w.Checkpoints))
+	}
+}
+
+// Blocks fetched before a label received funds are scanned again with the grown window
+func TestScanParallelLabelWindowGrows(t *testing.T) {
+	oracle := newTestOracle(10)
+	w := newTestWallet(t, 1)
+	w.LabelLookahead = 5
+
+	other := newTestWallet(t, 1)
+	other.LabelLookahead = 10
+	if _, err := other.ScanLabels(); err != nil {
+		t.Fatal(err)
+	}
+	first := oracle.pay(t, 3, 1, 10_000, other.LabelSlice()[5].Address)
+	second := oracle.pay(t, 4, 2, 10_000, other.LabelSlice()[9].Address)
+
+	s := NewScanner(oracle, w, 0)
+	if err := s.ScanParallel(context.Background(), 1, 10, 4); err != nil {
+		t.Fatal(err)
+	}
+	if utxo := findUTXO(w, first[0]); utxo == nil || utxo.Label == nil || utxo.Label.M != 5 {
+		t.Fatalf("utxo of label 5 %+v", utxo)
+	}
+	if utxo := findUTXO(w, second[0]); utxo == nil || utxo.Label == nil || utxo.Label.M != 9 {
+		t.Fatalf("utxo of label 9 %+v", utxo)
+	}
+}
+
+func TestScanParallelErrors(t *testing.T) {
+	oracle := newTestOracle(10)
+	w := newTestWallet(t, 1)
+	s := NewScanner(oracle, w, 0)
+
+	// blocks above the tip are not served
+	if err := s.ScanParallel(context.Background(), 1, 12, 4); err == nil {
+		t.Fatal("scanned blocks above the tip")
+	}
+	// everything up to the last scan height was committed
+	if w.LastScanHeight > 10 || len(w.Checkpoints) != int(w.LastScanHeight) {
+		t.Fatalf("last scan height %d checkpoints %d", w.LastScanHeight, len(w.Checkpoints))
+	}
+
+	oracle.setTip(1000, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.ScanParallel(ctx, s.NextHeight(), 1000, 4); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled: %v", err)
+	}
+
+	if err := NewScanner(oracle, nil, 0).ScanParallel(context.Background(), 1, 10, 4); !errors.Is(err, ErrNoWallet) {
+		t.Errorf("no wallet: %v", err)
+	}
+	if err := NewScanner(nil, w, 0).ScanParallel(context.Background(), 1, 10, 4); !errors.Is(err, ErrNoClient) {
+		t.Errorf("no client: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package scanner
+
+import (
+	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/blindbit-lib/networking"
+)
+
+// DetectReorg compares the wallet checkpoints with the block hashes currently served by the oracle.
+// Checkpoints are checked from the newest to the oldest,
+// so without a reorg only a single request is made.
+// Returns the height of the most recent block both agree on and whether a reorg happened.
+func (s *Scanner) DetectReorg() (forkHeight uint64, reorged bool, err error) {
+	if s.Wallet == nil {
+		return 0, false, ErrNoWallet
+	}
+	if s.Client == nil {
+		return 0, false, ErrNoClient
+	}
+
+	checkpoints := s.Wallet.Checkpoints
+	for i := len(checkpoints) - 1; i >= 0; i-- {
+		checkpoint := checkpoints[i]
+		blockHash, err := s.blockHash(checkpoint.Height)
+		if err != nil {
+			return 0, false, err
+		}
+		if blockHash == checkpoint.BlockHash {
+			return checkpoint.Height, i != len(checkpoints)-1, nil
+		}
+		logging.L.Warn().
+			Uint64("height", checkpoint.Height).
+			Hex("expected", checkpoint.BlockHash[:]).
+			Hex("got", blockHash[:]).
+			Msg("block hash changed")
+	}
+
+	if len(checkpoints) == 0 {
+		return 0, false, nil
+	}
+
+	// the reorg goes deeper than our checkpoints, we go back to before the oldest one
+	oldest := checkpoints[0].Height
+	logging.L.Warn().Uint64("height", oldest).Msg("reorg is deeper than the oldest checkpoint")
+	if oldest == 0 {
+		return 0, true, nil
+	}
+	return oldest - 1, true, nil
+}
+
+// HandleReorg detects a reorg and rolls the wallet back to the fork point.
+// The orphaned blocks are scanned again with the next scan.
+func (s *Scanner) HandleReorg() (bool, error) {
+	forkHeight, reorged, err := s.DetectReorg()
+	if err != nil {
+		logging.L.Err(err).Msg("failed to detect reorg")
+		return false, err
+	}
+	if !reorged {
+		return false, nil
+	}
+
+	logging.L.Warn().
+		Uint64("fork_height", forkHeight).
+		Uint64("last_scan", s.Wallet.LastScanHeight).
+		Msg("reorg detected, rolling back wallet")
+
+	s.Wallet.Rollback(forkHeight)
+	return true, nil
+}
+
+// blockHash returns the hash the oracle currently serves for height
+func (s *Scanner) blockHash(height uint64) ([32]byte, error) {
+	filter, err := s.Client.GetFilter(height, networking.NewUTXOFilterType)
+	if err != nil {
+		logging.L.Err(err).Uint64("height", height).Msg("failed to get block hash")
+		return [32]byte{}, err
+	}
+	return filter.BlockHash, nil
+}
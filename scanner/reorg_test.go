@@ -0,0 +1,137 @@
+package scanner
+
+import (
+	"testing"
+
+	"github.com/setavenger/blindbit-lib/wallet"
+)
+
+func TestScanRecordsCheckpointForEveryBlock(t *testing.T) {
+	oracle := newTestOracle(10)
+	w := newTestWallet(t, 1)
+	oracle.pay(t, 5, 1, 10_000, w.Address())
+
+	s := NewScanner(oracle, w, 0)
+	if err := s.Scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	// blocks 1 to 10, the genesis block is never scanned
+	if len(w.Checkpoints) != 10 {
+		t.Fatalf("%d checkpoints, want one per block", len(w.Checkpoints))
+	}
+	for _, checkpoint := range w.Checkpoints {
+		if checkpoint.BlockHash != oracle.block(checkpoint.Height).hash {
+			t.Fatalf("checkpoint %d has hash %x", checkpoint.Height, checkpoint.BlockHash)
+		}
+	}
+}
+
+func TestDetectReorgWithoutReorg(t *testing.T) {
+	oracle := newTestOracle(10)
+	w := newTestWallet(t, 1)
+	s := NewScanner(oracle, w, 0)
+	if err := s.Scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	forkHeight, reorged, err := s.DetectReorg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reorged || forkHeight != 10 {
+		t.Fatalf("reorged %t fork height %d", reorged, forkHeight)
+	}
+}
+
+// The spend of a utxo is reorged out in blocks without tweaks, the wallet has to notice it.
+func TestReorgOfBlocksWithoutTweaks(t *testing.T) {
+	oracle := newTestOracle(10)
+	w := newTestWallet(t, 1)
+	served := oracle.pay(t, 5, 1, 10_000, w.Address())
+	oracle.spend(8, served[0])
+
+	s := NewScanner(oracle, w, 0)
+	if err := s.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	utxo := findUTXO(w, served[0])
+	if utxo == nil || utxo.State != wallet.StateSpent || utxo.SpentHeight != 8 {
+		t.Fatalf("utxo before reorg %+v", utxo)
+	}
+
+	// blocks 7 to 10 are replaced, the spend is not part of the new chain
+	oracle.replace(7, 1)
+
+	forkHeight, reorged, err := s.DetectReorg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reorged || forkHeight != 6 {
+		t.Fatalf("reorged %t fork height %d", reorged, forkHeight)
+	}
+
+	if err = s.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	if utxo.State != wallet.StateUnspent || utxo.SpentHeight != 0 {
+		t.Fatalf("utxo after reorg state %s spent height %d", utxo.State, utxo.SpentHeight)
+	}
+	if w.LastScanHeight != 10 {
+		t.Fatalf("last scan height %d", w.LastScanHeight)
+	}
+	if last := w.Checkpoints[len(w.Checkpoints)-1]; last.BlockHash != oracle.block(10).hash {
+		t.Fatalf("checkpoint still has the orphaned hash %x", last.BlockHash)
+	}
+}
+
+func TestReorgRemovesOrphanedUTXOs(t *testing.T) {
+	oracle := newTestOracle(10)
+	w := newTestWallet(t, 1)
+	oracle.pay(t, 4, 1, 10_000, w.Address())
+	orphaned := oracle.pay(t, 9, 2, 20_000, w.Address())
+
+	s := NewScanner(oracle, w, 0)
+	if err := s.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.UTXOs) != 2 {
+		t.Fatalf("%d utxos before reorg", len(w.UTXOs))
+	}
+
+	oracle.replace(9, 1)
+	reorged, err := s.HandleReorg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reorged {
+		t.Fatal("reorg not detected")
+	}
+	if findUTXO(w, orphaned[0]) != nil || len(w.UTXOs) != 1 {
+		t.Fatalf("orphaned utxo kept, %d utxos", len(w.UTXOs))
+	}
+	if w.LastScanHeight != 8 {
+		t.Fatalf("last scan height %d", w.LastScanHeight)
+	}
+}
+
+func TestReorgDeeperThanCheckpoints(t *testing.T) {
+	oracle := newTestOracle(wallet.MaxCheckpoints + 20)
+	w := newTestWallet(t, 1)
+	s := NewScanner(oracle, w, 0)
+	if err := s.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Checkpoints) != wallet.MaxCheckpoints {
+		t.Fatalf("%d checkpoints", len(w.Checkpoints))
+	}
+
+	oracle.replace(1, 1)
+	forkHeight, reorged, err := s.DetectReorg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldest := w.Checkpoints[0].Height; !reorged || forkHeight != oldest-1 {
+		t.Fatalf("reorged %t fork height %d oldest checkpoint %d", reorged, forkHeight, oldest)
+	}
+}
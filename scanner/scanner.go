@@ -0,0 +1,417 @@
+// package scanner
+// Contains the logic to find the outputs of a wallet with the data served by a BlindBit Oracle.
+// It turns tweaks and utxos into fully populated wallet.OwnedUTXOs.
+package scanner
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/blindbit-lib/networking"
+	"github.com/setavenger/blindbit-lib/utils"
+	"github.com/setavenger/blindbit-lib/wallet"
+	"github.com/setavenger/go-bip352"
+)
+
+var (
+	ErrNoWallet = errors.New("scanner has no wallet")
+	ErrNoClient = errors.New("scanner has no client")
+)
+
+// Scanner scans blocks for outputs belonging to Wallet
+// and appends them to the wallet while advancing Wallet.LastScanHeight.
+type Scanner struct {
+	Client    networking.BlindBitConnector
+	Wallet    *wallet.Wallet
+	DustLimit uint64 // tweaks of transactions with only outputs below this value are not requested
+	// PendingExpiry after which pending transactions of the wallet are abandoned, 0 never abandons them.
+	// See wallet.Wallet.ReconcilePending and wallet.DefaultPendingExpiry.
+	PendingExpiry time.Duration
+
+	labelsMu sync.RWMutex
+	labels   []*bip352.Label // snapshot of Wallet.ScanLabels, refreshed when a label receives funds
+}
+
+// blockData is the raw data of a block as served by the oracle.
+// Data which was not served is nil and fetched on demand.
+type blockData struct {
+	height         uint64
+	blockHash      *[32]byte // nil if not served
+	tweaks         [][]byte
+	newUTXOsFilter *networking.Filter
+	spentFilter    *networking.Filter
+	utxos          []*networking.UTXOServed
+	spentIndex     *networking.SpentOutpointsIndex
+}
+
+// blockResult holds everything that was found for a block before it is committed to the wallet
+type blockResult struct {
+	height      uint64
+	blockHash   *[32]byte // nil if the hash was not needed to scan the block
+	labelCount  int       // number of labels the block was scanned with
+	found       []*wallet.OwnedUTXO
+	spentFilter *networking.Filter
+	spentIndex  *networking.SpentOutpointsIndex
+}
+
+func NewScanner(
+	client networking.BlindBitConnector,
+	w *wallet.Wallet,
+	dustLimit uint64,
+) *Scanner {
+	return &Scanner{
+		Client:    client,
+		Wallet:    w,
+		DustLimit: dustLimit,
+	}
+}
+
+// Scan scans from the wallets next height up to the current chain tip of the oracle.
+// A reorg of already scanned blocks is handled before.
+func (s *Scanner) Scan() error {
+	if s.Client == nil {
+		return ErrNoClient
+	}
+	_, err := s.HandleReorg()
+	if err != nil {
+		return err
+	}
+	chainTip, err := s.Client.GetChainTip()
+	if err != nil {
+		logging.L.Err(err).Msg("failed to get chain tip")
+		return err
+	}
+	return s.ScanRange(s.NextHeight(), chainTip)
+}
+
+// NextHeight returns the height the next scan has to start at
+func (s *Scanner) NextHeight() uint64 {
+	if s.Wallet.LastScanHeight < s.Wallet.BirthHeight {
+		return s.Wallet.BirthHeight
+	}
+	return s.Wallet.LastScanHeight + 1
+}
+
+// ScanRange scans all blocks from start to end (both inclusive) in order
+func (s *Scanner) ScanRange(start, end uint64) error {
+	if s.Wallet == nil {
+		return ErrNoWallet
+	}
+	// picks up labels which were added to the wallet since the last scan
+	err := s.refreshLabels()
+	if err != nil {
+		return err
+	}
+
+	for height := start; height <= end; height++ {
+		_, err := s.ScanBlock(height)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScanBlock scans a single block and commits the result to the wallet.
+// Returns the utxos that were newly found in the block.
+func (s *Scanner) ScanBlock(height uint64) ([]*wallet.OwnedUTXO, error) {
+	result, err := s.fetchBlock(height)
+	if err != nil {
+		return nil, err
+	}
+	err = s.commitBlock(result)
+	if err != nil {
+		return nil, err
+	}
+	return result.found, nil
+}
+
+// fetchBlock pulls all data for a block from the oracle and matches it against the wallet keys.
+// Does not modify the wallet.
+func (s *Scanner) fetchBlock(height uint64) (*blockResult, error) {
+	if s.Wallet == nil {
+		return nil, ErrNoWallet
+	}
+	if s.Client == nil {
+		return nil, ErrNoClient
+	}
+
+	tweaks, err := s.Client.GetTweaks(height, s.DustLimit)
+	if err != nil {
+		logging.L.Err(err).Uint64("height", height).Msg("failed to get tweaks")
+		return nil, err
+	}
+
+	return s.processBlock(&blockData{
+		height: height,
+		tweaks: tweaks,
+	})
+}
+
+// processBlock matches the block data against the wallet keys.
+// The new utxos filter and the utxos are only fetched if they are needed and were not served already.
+// Does not modify the wallet.
+func (s *Scanner) processBlock(data *blockData) (*blockResult, error) {
+	result := &blockResult{
+		height:      data.height,
+		blockHash:   data.blockHash,
+		labelCount:  s.labelCount(),
+		spentFilter: data.spentFilter,
+		spentIndex:  data.spentIndex,
+	}
+
+	if len(data.tweaks) == 0 {
+		return result, nil
+	}
+
+	utxos := data.utxos
+	if utxos == nil {
+		filter := data.newUTXOsFilter
+		if filter == nil {
+			var err error
+			filter, err = s.Client.GetFilter(data.height, networking.NewUTXOFilterType)
+			if err != nil {
+				logging.L.Err(err).Uint64("height", data.height).Msg("failed to get new utxos filter")
+				return nil, err
+			}
+		}
+		result.blockHash = &filter.BlockHash
+
+		isMatch, err := s.blockMightContainOutputs(filter, data.tweaks)
+		if err != nil {
+			logging.L.Err(err).Uint64("height", data.height).Msg("failed to match new utxos filter")
+			return nil, err
+		}
+		if !isMatch {
+			// nothing for us in this block, no need to download the utxos
+			return result, nil
+		}
+
+		utxos, err = s.Client.GetUTXOs(data.height)
+		if err != nil {
+			logging.L.Err(err).Uint64("height", data.height).Msg("failed to get utxos")
+			return nil, err
+		}
+	}
+
+	var err error
+	result.found, err = s.matchOutputs(data.tweaks, utxos)
+	if err != nil {
+		logging.L.Err(err).Uint64("height", data.height).Msg("failed to match outputs")
+		return nil, err
+	}
+	for _, utxo := range result.found {
+		utxo.Height = data.height
+	}
+
+	return result, nil
+}
+
+// commitBlock adds the result of a block to the wallet and advances the scan height.
+// Pending transactions of the wallet are reconciled with the spent outputs.
+// The block hash is recorded as a checkpoint for every block, see DetectReorg.
+func (s *Scanner) commitBlock(result *blockResult) error {
+	// fetched first, markSpentOutputs reuses a spent filter fetched for the hash
+	blockHash, err := s.resultBlockHash(result)
+	if err != nil {
+		return err
+	}
+
+	if len(result.found) > 0 {
+		added, err := s.Wallet.AddUTXOs(result.found...)
+		if err != nil {
+			return err
+		}
+		logging.L.Info().
+			Uint64("height", result.height).
+			Int("added", added).
+			Msg("found utxos")
+
+		for _, utxo := range result.found {
+			if utxo.Label != nil {
+				// a label received funds, the lookahead window might have to grow
+				err = s.refreshLabels()
+				if err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+
+	// checked after adding the new utxos as they could be spent in the same block
+	_, err = s.markSpentOutputs(result.height, result.spentFilter, result.spentIndex)
+	if err != nil {
+		return err
+	}
+
+	reconciled := s.Wallet.ReconcilePending(s.PendingExpiry)
+	if len(reconciled.Confirmed) > 0 || len(reconciled.Abandoned) > 0 {
+		logging.L.Info().
+			Uint64("height", result.height).
+			Int("confirmed", len(reconciled.Confirmed)).
+			Int("abandoned", len(reconciled.Abandoned)).
+			Msg("reconciled pending transactions")
+	}
+
+	s.Wallet.AddCheckpoint(result.height, blockHash)
+
+	s.Wallet.LastScanHeight = result.height
+	return nil
+}
+
+// resultBlockHash returns the hash of the block of result.
+// Blocks without tweaks carry no hash, the spent filter is fetched for them and kept in result.
+func (s *Scanner) resultBlockHash(result *blockResult) ([32]byte, error) {
+	switch {
+	case result.blockHash != nil:
+		return *result.blockHash, nil
+	case result.spentIndex != nil:
+		return result.spentIndex.BlockHash, nil
+	case result.spentFilter == nil:
+		filter, err := s.Client.GetFilter(result.height, networking.SpentOutpointsFilterType)
+		if err != nil {
+			logging.L.Err(err).Uint64("height", result.height).Msg("failed to get spent filter")
+			return [32]byte{}, err
+		}
+		result.spentFilter = filter
+	}
+	return result.spentFilter.BlockHash, nil
+}
+
+// matchOutputs computes the potential outputs for every tweak and
+// checks them against the taproot utxos of the block.
+func (s *Scanner) matchOutputs(
+	tweaks [][]byte,
+	utxos []*networking.UTXOServed,
+) (
+	[]*wallet.OwnedUTXO, error,
+) {
+	// x-only output -> served utxo
+	utxoMap := make(map[[32]byte]*networking.UTXOServed, len(utxos))
+	var txOutputs [][32]byte
+	for _, utxo := range utxos {
+		if !bip352.IsP2TR(utxo.ScriptPubKey[:]) {
+			continue
+		}
+		output := utils.ConvertToFixedLength32(utxo.ScriptPubKey[2:])
+		utxoMap[output] = utxo
+		txOutputs = append(txOutputs, output)
+	}
+
+	if len(txOutputs) == 0 {
+		return nil, nil
+	}
+
+	labels := s.scanLabels()
+	spendPubKey := s.Wallet.PubKeySpend.ToArray()
+
+	var found []*wallet.OwnedUTXO
+	for _, tweak := range tweaks {
+		if len(tweak) != 33 {
+			return nil, fmt.Errorf("invalid tweak length: %d", len(tweak))
+		}
+		// the tweak is modified in place when computing the shared secret
+		publicComponent := utils.ConvertToFixedLength33(tweak)
+
+		// txOutputs is modified by the receiver function hence we pass a copy
+		outputs := make([][32]byte, len(txOutputs))
+		copy(outputs, txOutputs)
+
+		foundOutputs, err := bip352.ReceiverScanTransaction(
+			s.Wallet.SecretKeyScan.ToArray(),
+			&spendPubKey,
+			labels,
+			outputs,
+			&publicComponent,
+			nil, // tweaks already include the input_hash
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, foundOutput := range foundOutputs {
+			utxo, ok := utxoMap[foundOutput.Output]
+			if !ok {
+				// should not happen, we only pass outputs from the map into the scan function
+				return nil, fmt.Errorf("found output %x was not served", foundOutput.Output)
+			}
+
+			// utxo.Spent is not used, the oracle does not serve the spending height.
+			// The spend is found by markSpentOutputs once the spending block is scanned,
+			// which records SpentHeight so the spend can be rolled back on a reorg.
+			found = append(found, &wallet.OwnedUTXO{
+				Txid:         utxo.Txid,
+				Vout:         utxo.Vout,
+				Amount:       utxo.Amount,
+				PrivKeyTweak: foundOutput.SecKeyTweak,
+				PubKey:       foundOutput.Output,
+				Timestamp:    utxo.Timestamp,
+				State:        wallet.StateUnspent,
+				Label:        copyLabel(foundOutput.Label),
+			})
+		}
+	}
+
+	return found, nil
+}
+
+// scanLabels returns the snapshot of the labels the wallet has to be scanned for.
+// The snapshot is safe to use from several goroutines.
+func (s *Scanner) scanLabels() []*bip352.Label {
+	s.labelsMu.RLock()
+	labels := s.labels
+	s.labelsMu.RUnlock()
+	if labels != nil {
+		return labels
+	}
+
+	err := s.refreshLabels()
+	if err != nil {
+		// computing labels only fails on invalid keys, in which case scanning is futile anyways
+		logging.L.Err(err).Msg("failed to compute scan labels")
+	}
+
+	s.labelsMu.RLock()
+	defer s.labelsMu.RUnlock()
+	return s.labels
+}
+
+// refreshLabels recomputes the label snapshot from the wallet.
+// This grows the lookahead window if a high label received funds.
+// Must not be called while a worker might access the wallet labels.
+func (s *Scanner) refreshLabels() error {
+	s.labelsMu.Lock()
+	defer s.labelsMu.Unlock()
+
+	labels, err := s.Wallet.ScanLabels()
+	if err != nil {
+		return err
+	}
+
+	if len(labels) > len(s.labels) && s.labels != nil {
+		logging.L.Info().
+			Int("old", len(s.labels)).
+			Int("new", len(labels)).
+			Msg("label window grew")
+	}
+	s.labels = labels
+	return nil
+}
+
+// labelCount returns the number of labels in the current snapshot
+func (s *Scanner) labelCount() int {
+	return len(s.scanLabels())
+}
+
+// copyLabel detaches the label of an OwnedUTXO from the wallets label slice
+func copyLabel(label *bip352.Label) *bip352.Label {
+	if label == nil {
+		return nil
+	}
+	labelCopy := *label
+	return &labelCopy
+}
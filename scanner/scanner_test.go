@@ -0,0 +1,216 @@
+package scanner
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/setavenger/blindbit-lib/networking"
+	"github.com/setavenger/blindbit-lib/wallet"
+	"github.com/setavenger/go-bip352"
+)
+
+// A new wallet finds payments to labels it never handed out itself, as long as they are within the lookahead
+func TestScanFindsLabelsWithinLookahead(t *testing.T) {
+	oracle := newTestOracle(10)
+	w := newTestWallet(t, 1)
+
+	// another instance of the wallet handed out the labels
+	other := newTestWallet(t, 1)
+	other.LabelLookahead = 10
+	if _, err := other.ScanLabels(); err != nil {
+		t.Fatal(err)
+	}
+	inWindow := oracle.pay(t, 3, 1, 10_000, other.LabelSlice()[3].Address)
+	// label 8 is only scanned for once label 3 received funds
+	grown := oracle.pay(t, 5, 2, 20_000, other.LabelSlice()[8].Address)
+	outside := oracle.pay(t, 7, 3, 30_000, other.LabelSlice()[10].Address)
+
+	s := NewScanner(oracle, w, 0)
+	if err := s.Scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	if utxo := findUTXO(w, inWindow[0]); utxo == nil || utxo.Label == nil || utxo.Label.M != 3 {
+		t.Fatalf("utxo of label 3 %+v", utxo)
+	}
+	if utxo := findUTXO(w, grown[0]); utxo == nil || utxo.Label == nil || utxo.Label.M != 8 {
+		t.Fatalf("utxo of label 8 %+v", utxo)
+	}
+	if findUTXO(w, outside[0]) == nil {
+		// label 10 is within the window once label 8 was found
+		t.Fatal("utxo of label 10 was not found")
+	}
+}
+
+func TestScanFindsOwnedUTXOs(t *testing.T) {
+	oracle := newTestOracle(10)
+	w, other := newTestWallet(t, 1), newTestWallet(t, 2)
+	served := oracle.pay(t, 4, 1, 10_000, w.Address(), other.Address(), w.Address())
+
+	s := NewScanner(oracle, w, 0)
+	if err := s.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	if w.LastScanHeight != 10 {
+		t.Fatalf("last scan height %d", w.LastScanHeight)
+	}
+	if len(w.UTXOs) != 2 {
+		t.Fatalf("found %d utxos", len(w.UTXOs))
+	}
+	if findUTXO(w, served[1]) != nil {
+		t.Fatal("found the output of another wallet")
+	}
+
+	for _, output := range []*networking.UTXOServed{served[0], served[2]} {
+		utxo := findUTXO(w, output)
+		if utxo == nil {
+			t.Fatalf("output %d not found", output.Vout)
+		}
+		if utxo.Height != 4 || utxo.Amount != 10_000 || utxo.Timestamp != output.Timestamp {
+			t.Fatalf("utxo %+v", utxo)
+		}
+		if utxo.State != wallet.StateUnspent || utxo.Label != nil {
+			t.Fatalf("state %s label %v", utxo.State, utxo.Label)
+		}
+		if !bytes.Equal(utxo.PubKey[:], output.ScriptPubKey[2:]) {
+			t.Fatalf("pub key %x", utxo.PubKey)
+		}
+
+		// the spend key tweaked with PrivKeyTweak has to control the output
+		secret := w.SecretKeySpend.ToArray()
+		if err := bip352.AddPrivateKeys(&secret, &utxo.PrivKeyTweak); err != nil {
+			t.Fatal(err)
+		}
+		if pubKey := bip352.PubKeyFromSecKey(&secret); !bytes.Equal(pubKey[1:], utxo.PubKey[:]) {
+			t.Fatalf("tweaked key %x does not match output %x", pubKey[1:], utxo.PubKey)
+		}
+	}
+
+	// rescanning does not add the utxos twice
+	if err := s.ScanRange(4, 4); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.UTXOs) != 2 {
+		t.Fatalf("%d utxos after rescan", len(w.UTXOs))
+	}
+}
+
+func TestScanBlock(t *testing.T) {
+	oracle := newTestOracle(5)
+	w := newTestWallet(t, 1)
+	served := oracle.pay(t, 2, 1, 10_000, w.Address())
+	// the oracle already knows the output is spent
+	served[0].Spent = true
+	oracle.spend(4, served[0])
+
+	s := NewScanner(oracle, w, 0)
+	found, err := s.ScanBlock(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Txid != served[0].Txid || found[0].Height != 2 {
+		t.Fatalf("found %+v", found)
+	}
+	// the spend is recorded with its height once the spending block is scanned
+	if found[0].State != wallet.StateUnspent {
+		t.Fatalf("state %s", found[0].State)
+	}
+	if w.LastScanHeight != 2 {
+		t.Fatalf("last scan height %d", w.LastScanHeight)
+	}
+
+	found, err = s.ScanBlock(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 0 {
+		t.Fatalf("found %d utxos in an empty block", len(found))
+	}
+
+	if _, err = s.ScanBlock(4); err != nil {
+		t.Fatal(err)
+	}
+	utxo := findUTXO(w, served[0])
+	if utxo.State != wallet.StateSpent || utxo.SpentHeight != 4 {
+		t.Fatalf("state %s spent height %d", utxo.State, utxo.SpentHeight)
+	}
+}
+
+// A spend the oracle served with the utxo is reverted when the spending block is reorged out
+func TestServedSpentUTXORollback(t *testing.T) {
+	oracle := newTestOracle(10)
+	w := newTestWallet(t, 1)
+	served := oracle.pay(t, 3, 1, 10_000, w.Address())
+	served[0].Spent = true
+	oracle.spend(6, served[0])
+
+	s := NewScanner(oracle, w, 0)
+	if err := s.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	if utxo := findUTXO(w, served[0]); utxo.State != wallet.StateSpent || utxo.SpentHeight != 6 {
+		t.Fatalf("state %s spent height %d", utxo.State, utxo.SpentHeight)
+	}
+
+	// the spend is not part of the new chain
+	served[0].Spent = false
+	oracle.replace(5, 1)
+	if err := s.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	if utxo := findUTXO(w, served[0]); utxo.State != wallet.StateUnspent || utxo.SpentHeight != 0 {
+		t.Fatalf("after reorg state %s spent height %d", utxo.State, utxo.SpentHeight)
+	}
+}
+
+func TestScanStartsAtBirthHeight(t *testing.T) {
+	oracle := newTestOracle(10)
+	w := newTestWallet(t, 1)
+	w.BirthHeight = 6
+	before := oracle.pay(t, 4, 1, 10_000, w.Address())
+	after := oracle.pay(t, 7, 2, 10_000, w.Address())
+
+	s := NewScanner(oracle, w, 0)
+	if next := s.NextHeight(); next != 6 {
+		t.Fatalf("next height %d", next)
+	}
+	if err := s.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	if findUTXO(w, before[0]) != nil {
+		t.Fatal("found an output below the birth height")
+	}
+	if findUTXO(w, after[0]) == nil {
+		t.Fatal("output above the birth height not found")
+	}
+	if n := oracle.count("tweaks"); n != 5 {
+		t.Fatalf("%d tweak requests, want 5", n)
+	}
+	if next := s.NextHeight(); next != 11 {
+		t.Fatalf("next height %d", next)
+	}
+}
+
+func TestScannerErrors(t *testing.T) {
+	oracle := newTestOracle(5)
+
+	if err := NewScanner(nil, newTestWallet(t, 1), 0).Scan(); !errors.Is(err, ErrNoClient) {
+		t.Errorf("no client: %v", err)
+	}
+	if err := NewScanner(oracle, nil, 0).ScanRange(1, 5); !errors.Is(err, ErrNoWallet) {
+		t.Errorf("no wallet: %v", err)
+	}
+	if _, err := NewScanner(oracle, nil, 0).ScanBlock(1); !errors.Is(err, ErrNoWallet) {
+		t.Errorf("no wallet: %v", err)
+	}
+
+	// blocks above the tip are not served
+	w := newTestWallet(t, 1)
+	if err := NewScanner(oracle, w, 0).ScanRange(4, 6); err == nil {
+		t.Fatal("scanned a block above the tip")
+	}
+	if w.LastScanHeight != 5 {
+		t.Fatalf("last scan height %d", w.LastScanHeight)
+	}
+}
@@ -0,0 +1,155 @@
+package scanner
+
+import (
+	"bytes"
+	"crypto/sha256"
+
+	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/blindbit-lib/networking"
+	"github.com/setavenger/blindbit-lib/wallet"
+)
+
+// SpentOutpointHash computes the short outpoint hash the oracle uses in the spent outpoints index and filter.
+// hash = sha256(outpoint || blockHash)[:8]
+// The outpoint is serialised as (txid in little endian || vout in little endian),
+// blockHash in the order served by the oracle.
+func SpentOutpointHash(utxo *wallet.OwnedUTXO, blockHash [32]byte) ([8]byte, error) {
+	outpoint, err := utxo.SerialiseToOutpoint()
+	if err != nil {
+		return [8]byte{}, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(outpoint[:])
+	buf.Write(blockHash[:])
+	hashed := sha256.Sum256(buf.Bytes())
+
+	var shortHash [8]byte
+	copy(shortHash[:], hashed[:8])
+	return shortHash, nil
+}
+
+// spendableUTXOs returns all wallet utxos which can still be spent in a block
+func (s *Scanner) spendableUTXOs() []*wallet.OwnedUTXO {
+	var utxos []*wallet.OwnedUTXO
+	for _, utxo := range s.Wallet.UTXOs {
+		if utxo.State == wallet.StateUnspent || utxo.State == wallet.StateUnconfirmedSpent {
+			utxos = append(utxos, utxo)
+		}
+	}
+	return utxos
+}
+
+// markSpentOutputs checks whether any of the wallet utxos were spent in the block at height.
+// The spent filter is checked first, only if it matches the spent outpoints index is downloaded.
+// If the index was already served (index != nil) the filter is not needed.
+// filter and index are fetched from the oracle if nil.
+// Matched utxos are moved to wallet.StateSpent.
+// Returns the utxos that were marked as spent.
+func (s *Scanner) markSpentOutputs(
+	height uint64,
+	filter *networking.Filter,
+	index *networking.SpentOutpointsIndex,
+) (
+	[]*wallet.OwnedUTXO, error,
+) {
+	utxos := s.spendableUTXOs()
+	if len(utxos) == 0 {
+		return nil, nil
+	}
+
+	if index == nil {
+		isMatch, err := s.spentFilterMatches(height, filter, utxos)
+		if err != nil {
+			return nil, err
+		}
+		if !isMatch {
+			return nil, nil
+		}
+
+		fetchedIndex, err := s.Client.GetSpentOutpointsIndex(height)
+		if err != nil {
+			logging.L.Err(err).Uint64("height", height).Msg("failed to get spent outpoints index")
+			return nil, err
+		}
+		index = &fetchedIndex
+	}
+
+	hashes, err := hashUTXOs(utxos, index.BlockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var spent []*wallet.OwnedUTXO
+	for _, hash := range index.Data {
+		utxo, ok := hashes[hash]
+		if !ok {
+			continue
+		}
+		utxo.State = wallet.StateSpent
+		utxo.SpentHeight = height
+		spent = append(spent, utxo)
+		logging.L.Debug().
+			Hex("txid", utxo.Txid[:]).
+			Uint32("vout", utxo.Vout).
+			Uint64("height", height).
+			Msg("utxo spent")
+	}
+
+	return spent, nil
+}
+
+// spentFilterMatches checks whether any of the utxos might have been spent in the block at height.
+// The filter is fetched from the oracle if nil.
+func (s *Scanner) spentFilterMatches(
+	height uint64,
+	filter *networking.Filter,
+	utxos []*wallet.OwnedUTXO,
+) (
+	bool, error,
+) {
+	var err error
+	if filter == nil {
+		filter, err = s.Client.GetFilter(height, networking.SpentOutpointsFilterType)
+		if err != nil {
+			logging.L.Err(err).Uint64("height", height).Msg("failed to get spent filter")
+			return false, err
+		}
+	}
+
+	hashes, err := hashUTXOs(utxos, filter.BlockHash)
+	if err != nil {
+		return false, err
+	}
+
+	values := make([][]byte, 0, len(hashes))
+	for hash := range hashes {
+		values = append(values, hash[:])
+	}
+
+	isMatch, err := MatchFilter(filter, values)
+	if err != nil {
+		logging.L.Err(err).Uint64("height", height).Msg("failed to match spent filter")
+		return false, err
+	}
+	return isMatch, nil
+}
+
+// hashUTXOs maps the short outpoint hashes to the utxos
+func hashUTXOs(
+	utxos []*wallet.OwnedUTXO,
+	blockHash [32]byte,
+) (
+	map[[8]byte]*wallet.OwnedUTXO, error,
+) {
+	hashes := make(map[[8]byte]*wallet.OwnedUTXO, len(utxos))
+	for _, utxo := range utxos {
+		hash, err := SpentOutpointHash(utxo, blockHash)
+		if err != nil {
+			logging.L.Err(err).Msg("failed to compute spent outpoint hash")
+			return nil, err
+		}
+		hashes[hash] = utxo
+	}
+	return hashes, nil
+}
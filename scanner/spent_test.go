@@ -0,0 +1,133 @@
+package scanner
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/setavenger/blindbit-lib/wallet"
+)
+
+func TestSpentOutpointHash(t *testing.T) {
+	utxo := &wallet.OwnedUTXO{Txid: [32]byte{1, 2, 3, 31: 0xff}, Vout: 258}
+	blockHash := testBlockHash(7, 0)
+
+	hash, err := SpentOutpointHash(utxo, blockHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the outpoint as serialised in transactions
+	var buf bytes.Buffer
+	outpoint := utxo.OutPoint()
+	buf.Write(outpoint.Hash[:])
+	buf.Write([]byte{2, 1, 0, 0})
+	buf.Write(blockHash[:])
+	want := sha256.Sum256(buf.Bytes())
+	if !bytes.Equal(hash[:], want[:8]) {
+		t.Fatalf("hash %x, want %x", hash, want[:8])
+	}
+
+	other, err := SpentOutpointHash(utxo, testBlockHash(8, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == hash {
+		t.Fatal("hash does not depend on the block hash")
+	}
+}
+
+func TestMarkSpentOutputs(t *testing.T) {
+	oracle := newTestOracle(10)
+	w, other := newTestWallet(t, 1), newTestWallet(t, 2)
+	served := oracle.pay(t, 2, 1, 10_000, w.Address(), w.Address())
+	foreign := oracle.pay(t, 3, 2, 10_000, other.Address())
+	oracle.spend(4, foreign[0])
+	oracle.spend(6, served[1])
+
+	s := NewScanner(oracle, w, 0)
+	if err := s.ScanRange(1, 3); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.UTXOs) != 2 {
+		t.Fatalf("%d utxos", len(w.UTXOs))
+	}
+
+	// the spend of a foreign output does not match the spent filter
+	spent, err := s.markSpentOutputs(4, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spent) != 0 {
+		t.Fatalf("marked %d utxos spent", len(spent))
+	}
+	if n := oracle.count("spent-index"); n != 0 {
+		t.Fatalf("%d spent index requests without a filter match", n)
+	}
+
+	spent, err = s.markSpentOutputs(6, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spent) != 1 || spent[0] != findUTXO(w, served[1]) {
+		t.Fatalf("spent %+v", spent)
+	}
+	if spent[0].State != wallet.StateSpent || spent[0].SpentHeight != 6 {
+		t.Fatalf("state %s spent height %d", spent[0].State, spent[0].SpentHeight)
+	}
+	if utxo := findUTXO(w, served[0]); utxo.State != wallet.StateUnspent {
+		t.Fatalf("unspent utxo is %s", utxo.State)
+	}
+	if n := oracle.count("spent-index"); n != 1 {
+		t.Fatalf("%d spent index requests", n)
+	}
+
+	// a served index is used without the filter
+	filters := oracle.count("spent")
+	index, err := oracle.GetSpentOutpointsIndex(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	findUTXO(w, served[1]).State = wallet.StateUnspent
+	spent, err = s.markSpentOutputs(6, nil, &index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spent) != 1 || oracle.count("spent") != filters {
+		t.Fatalf("spent %d, %d filter requests", len(spent), oracle.count("spent")-filters)
+	}
+}
+
+func TestScanMarksSpentOutputs(t *testing.T) {
+	oracle := newTestOracle(10)
+	w := newTestWallet(t, 1)
+	served := oracle.pay(t, 3, 1, 10_000, w.Address(), w.Address(), w.Address())
+	// spent in the block it was received in
+	oracle.spend(3, served[0])
+	oracle.spend(8, served[1])
+
+	s := NewScanner(oracle, w, 0)
+	// an unconfirmed spend of the wallet is confirmed by the block
+	_, err := s.ScanBlock(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	findUTXO(w, served[1]).State = wallet.StateUnconfirmedSpent
+	if err := s.Scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []struct {
+		state  wallet.UTXOState
+		height uint64
+	}{
+		{wallet.StateSpent, 3},
+		{wallet.StateSpent, 8},
+		{wallet.StateUnspent, 0},
+	} {
+		utxo := findUTXO(w, served[i])
+		if utxo.State != want.state || utxo.SpentHeight != want.height {
+			t.Errorf("utxo %d: state %s spent height %d", i, utxo.State, utxo.SpentHeight)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/blindbit-lib/networking"
+	"github.com/setavenger/blindbit-lib/proto/pb"
+)
+
+type StreamMode int
+
+const (
+	// StreamModeSlim streams tweaks and filters, utxos and spent indices are fetched on demand
+	StreamModeSlim StreamMode = iota
+	// StreamModeFull streams all block data including utxos and spent indices
+	StreamModeFull
+)
+
+var ErrStreamEndedEarly = errors.New("stream ended before reaching the end height")
+
+// StreamConfig configures a streaming scan.
+// Zero values are replaced by the values of DefaultStreamConfig.
+type StreamConfig struct {
+	Mode StreamMode
+	// BufferSize is the number of batches received ahead of processing.
+	// If the buffer is full receiving pauses until the scanner caught up.
+	BufferSize int
+	// MaxRetries is the number of times a broken stream is reopened without making progress
+	MaxRetries int
+	// RetryDelay is the time waited before reopening a broken stream
+	RetryDelay time.Duration
+}
+
+func DefaultStreamConfig() StreamConfig {
+	return StreamConfig{
+		Mode:       StreamModeSlim,
+		BufferSize: 16,
+		MaxRetries: 5,
+		RetryDelay: 2 * time.Second,
+	}
+}
+
+func (c StreamConfig) withDefaults() StreamConfig {
+	defaults := DefaultStreamConfig()
+	if c.BufferSize <= 0 {
+		c.BufferSize = defaults.BufferSize
+	}
+	if c.MaxRetries <= 0 {
+		c.MaxRetries = defaults.MaxRetries
+	}
+	if c.RetryDelay <= 0 {
+		c.RetryDelay = defaults.RetryDelay
+	}
+	return c
+}
+
+// StreamScan scans the blocks from start to end (both inclusive) with one stream of block batches.
+// Batches are processed and committed to the wallet strictly in order.
+// If the stream breaks it is reopened from the block after the last committed height.
+// Data which is not part of the streamed batches is fetched on demand through Scanner.Client.
+func (s *Scanner) StreamScan(
+	ctx context.Context,
+	streamer networking.BlockBatchStreamer,
+	start, end uint64,
+	cfg StreamConfig,
+) error {
+	if s.Wallet == nil {
+		return ErrNoWallet
+	}
+	if s.Client == nil {
+		return ErrNoClient
+	}
+	cfg = cfg.withDefaults()
+
+	err := s.refreshLabels()
+	if err != nil {
+		return err
+	}
+
+	reorged, err := s.HandleReorg()
+	if err != nil {
+		return err
+	}
+	if reorged && s.NextHeight() < start {
+		// the orphaned blocks have to be scanned again
+		start = s.NextHeight()
+	}
+
+	var retries int
+	next := start
+	for next <= end {
+		newNext, err := s.streamRange(ctx, streamer, next, end, cfg)
+		if newNext > next {
+			// progress was made, so we start counting again
+			retries = 0
+		}
+		next = newNext
+
+		if err == nil {
+			if next > end {
+				break
+			}
+			err = ErrStreamEndedEarly
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		retries++
+		if retries > cfg.MaxRetries {
+			logging.L.Err(err).Uint64("height", next).Msg("giving up on stream")
+			return err
+		}
+
+		logging.L.Warn().Err(err).
+			Uint64("height", next).
+			Int("retry", retries).
+			Msg("stream broke, reopening")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(cfg.RetryDelay):
+		}
+	}
+
+	return nil
+}
+
+// streamRange opens a single stream and processes the batches until the stream ends or fails.
+// Returns the next height which has to be scanned.
+func (s *Scanner) streamRange(
+	ctx context.Context,
+	streamer networking.BlockBatchStreamer,
+	start, end uint64,
+	cfg StreamConfig,
+) (
+	uint64, error,
+) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the buffered channel provides the backpressure,
+	// receiving blocks while the channel is full
+	batches := make(chan *blockData, cfg.BufferSize)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(batches)
+		errChan <- receiveBatches(ctx, streamer, start, end, cfg.Mode, batches)
+	}()
+
+	next := start
+	for data := range batches {
+		if data.height != next {
+			return next, fmt.Errorf("stream out of order: expected height %d got %d", next, data.height)
+		}
+
+		result, err := s.processBlock(data)
+		if err != nil {
+			return next, err
+		}
+
+		err = s.commitBlock(result)
+		if err != nil {
+			return next, err
+		}
+		next++
+	}
+
+	return next, <-errChan
+}
+
+// receiveBatches reads batches from a newly opened stream and forwards them to out
+func receiveBatches(
+	ctx context.Context,
+	streamer networking.BlockBatchStreamer,
+	start, end uint64,
+	mode StreamMode,
+	out chan<- *blockData,
+) error {
+	var recv func() (*blockData, error)
+
+	switch mode {
+	case StreamModeSlim:
+		stream, err := streamer.StreamBlockBatchSlim(ctx, start, end)
+		if err != nil {
+			logging.L.Err(err).Msg("failed to open slim stream")
+			return err
+		}
+		recv = func() (*blockData, error) {
+			batch, err := stream.Recv()
+			if err != nil {
+				return nil, err
+			}
+			return convertBlockBatchSlim(batch)
+		}
+	case StreamModeFull:
+		stream, err := streamer.StreamBlockBatchFull(ctx, start, end)
+		if err != nil {
+			logging.L.Err(err).Msg("failed to open full stream")
+			return err
+		}
+		recv = func() (*blockData, error) {
+			batch, err := stream.Recv()
+			if err != nil {
+				return nil, err
+			}
+			return convertBlockBatchFull(batch)
+		}
+	default:
+		return fmt.Errorf("unknown stream mode: %d", mode)
+	}
+
+	for {
+		data, err := recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		select {
+		case out <- data:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func convertBlockBatchSlim(batch *pb.BlockBatchSlim) (*blockData, error) {
+	height := batch.GetBlockIdentifier().GetBlockHeight()
+	data := &blockData{
+		height:    height,
+		blockHash: convertBlockHash(batch.GetBlockIdentifier()),
+		tweaks:    batch.GetTweaks(),
+	}
+
+	var err error
+	if batch.GetNewUtxosFilter() != nil {
+		data.newUTXOsFilter, err = networking.ConvertPbFilter(batch.GetNewUtxosFilter(), height)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if batch.GetSpentUtxosFilter() != nil {
+		data.spentFilter, err = networking.ConvertPbFilter(batch.GetSpentUtxosFilter(), height)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+func convertBlockBatchFull(batch *pb.BlockBatchFull) (*blockData, error) {
+	height := batch.GetBlockIdentifier().GetBlockHeight()
+	data := &blockData{
+		height:    height,
+		blockHash: convertBlockHash(batch.GetBlockIdentifier()),
+		tweaks:    batch.GetTweaks(),
+	}
+
+	// non nil, the served utxos are complete even if empty
+	var err error
+	data.utxos, err = networking.ConvertPbUTXOs(batch.GetUtxos())
+	if err != nil {
+		return nil, err
+	}
+
+	if batch.GetNewUtxosFilter() != nil {
+		data.newUTXOsFilter, err = networking.ConvertPbFilter(batch.GetNewUtxosFilter(), height)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if batch.GetSpentUtxosFilter() != nil {
+		data.spentFilter, err = networking.ConvertPbFilter(batch.GetSpentUtxosFilter(), height)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	spentIndex, err := networking.ConvertPbSpentOutpointsIndex(&pb.SpentOutpointsIndexResponse{
+		BlockIdentifier: batch.GetBlockIdentifier(),
+		Data:            batch.GetSpentUtxos(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	data.spentIndex = &spentIndex
+
+	return data, nil
+}
+
+// convertBlockHash returns nil if the identifier does not contain a valid hash
+func convertBlockHash(identifier *pb.BlockIdentifier) *[32]byte {
+	if len(identifier.GetBlockHash()) != 32 {
+		return nil
+	}
+	blockHash := [32]byte(identifier.GetBlockHash())
+	return &blockHash
+}
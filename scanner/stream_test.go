@@ -0,0 +1,224 @@
+package scanner
+
+import (
+	"context"
+	"crypto/sha256"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/setavenger/blindbit-lib/networking"
+	"github.com/setavenger/blindbit-lib/proto/pb"
+	"github.com/setavenger/blindbit-lib/wallet"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testStreamServer streams the blocks of a testOracle as the gRPC oracle does
+type testStreamServer struct {
+	pb.UnimplementedOracleServiceServer
+	oracle *testOracle
+}
+
+func (s *testStreamServer) StreamBlockBatchFull(
+	req *pb.RangedBlockHeightRequest, stream pb.OracleService_StreamBlockBatchFullServer,
+) error {
+	for height := req.GetStart(); height <= req.GetEnd(); height++ {
+		block := s.oracle.block(height)
+		batch := &pb.BlockBatchFull{
+			BlockIdentifier:  &pb.BlockIdentifier{BlockHash: block.hash[:], BlockHeight: height},
+			Tweaks:           block.tweaks,
+			NewUtxosFilter:   s.filter(height, networking.NewUTXOFilterType),
+			SpentUtxosFilter: s.filter(height, networking.SpentOutpointsFilterType),
+			SpentUtxos:       fullSpentHashes(block),
+		}
+		for _, utxo := range block.utxos {
+			batch.Utxos = append(batch.Utxos, &pb.UTXO{
+				Txid:         utxo.Txid[:],
+				Vout:         utxo.Vout,
+				Value:        utxo.Amount,
+				ScriptPubKey: utxo.ScriptPubKey[:],
+				BlockHeight:  utxo.BlockHeight,
+				BlockHash:    utxo.BlockHash[:],
+				Timestamp:    utxo.Timestamp,
+			})
+		}
+		if len(block.tweaks) > 0 {
+			// the oracle serves all outputs of a block, not only taproot ones
+			batch.Utxos = append(batch.Utxos, &pb.UTXO{
+				Txid:         []byte{31: 0xcc},
+				ScriptPubKey: []byte{0x00, 0x14, 19: 0},
+				BlockHeight:  height,
+				BlockHash:    block.hash[:],
+			})
+		}
+		if err := stream.Send(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *testStreamServer) StreamBlockBatchSlim(
+	req *pb.RangedBlockHeightRequest, stream pb.OracleService_StreamBlockBatchSlimServer,
+) error {
+	for height := req.GetStart(); height <= req.GetEnd(); height++ {
+		block := s.oracle.block(height)
+		err := stream.Send(&pb.BlockBatchSlim{
+			BlockIdentifier:  &pb.BlockIdentifier{BlockHash: block.hash[:], BlockHeight: height},
+			Tweaks:           block.tweaks,
+			NewUtxosFilter:   s.filter(height, networking.NewUTXOFilterType),
+			SpentUtxosFilter: s.filter(height, networking.SpentOutpointsFilterType),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filter builds the filter without counting it as a request to the oracle
+func (s *testStreamServer) filter(height uint64, filterType networking.FilterType) *pb.FilterData {
+	filter, err := s.oracle.GetFilter(height, filterType)
+	if err != nil {
+		panic(err)
+	}
+	s.oracle.mu.Lock()
+	s.oracle.requests[string(filterType)]--
+	s.oracle.mu.Unlock()
+
+	pbFilterType := pb.FilterType_FILTER_TYPE_NEW_UTXOS
+	if filterType == networking.SpentOutpointsFilterType {
+		pbFilterType = pb.FilterType_FILTER_TYPE_SPENT
+	}
+	return &pb.FilterData{Blockhash: filter.BlockHash[:], FilterType: pbFilterType, Data: filter.Data}
+}
+
+// fullSpentHashes are the spent outpoint hashes before the oracle shortens them to 8 bytes
+func fullSpentHashes(block *testBlock) [][]byte {
+	hashes := make([][]byte, len(block.spent))
+	for i, utxo := range block.spent {
+		outpoint, err := utxo.SerialiseToOutpoint()
+		if err != nil {
+			panic(err)
+		}
+		hash := sha256.Sum256(append(outpoint[:], block.hash[:]...))
+		hashes[i] = hash[:]
+	}
+	return hashes
+}
+
+func newTestStreamer(t *testing.T, oracle *testOracle) networking.BlockBatchStreamer {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	pb.RegisterOracleServiceServer(server, &testStreamServer{oracle: oracle})
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	client, err := networking.NewClientBlindBitGRPC(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return client
+}
+
+func TestStreamScanFullBatches(t *testing.T) {
+	oracle := newTestOracle(10)
+	w := newTestWallet(t, 1)
+	served := oracle.pay(t, 3, 1, 10_000, w.Address())
+	oracle.spend(6, served[0])
+
+	s := NewScanner(oracle, w, 0)
+	err := s.StreamScan(context.Background(), newTestStreamer(t, oracle), 1, 10, StreamConfig{
+		Mode:       StreamModeFull,
+		MaxRetries: 1,
+		RetryDelay: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	utxo := findUTXO(w, served[0])
+	if utxo == nil || utxo.Height != 3 {
+		t.Fatalf("utxo %+v", utxo)
+	}
+	if utxo.State != wallet.StateSpent || utxo.SpentHeight != 6 {
+		t.Fatalf("state %s spent height %d, the served spent index was not matched", utxo.State, utxo.SpentHeight)
+	}
+	if w.LastScanHeight != 10 || len(w.Checkpoints) != 10 {
+		t.Fatalf("last scan height %d checkpoints %d", w.LastScanHeight, len(w.Checkpoints))
+	}
+	// everything was served by the stream
+	for _, request := range []string{"utxos", "spent-index", "tweaks"} {
+		if n := oracle.count(request); n != 0 {
+			t.Errorf("%d %s requests", n, request)
+		}
+	}
+}
+
+func TestStreamScanSlimBatches(t *testing.T) {
+	oracle := newTestOracle(10)
+	w := newTestWallet(t, 1)
+	served := oracle.pay(t, 3, 1, 10_000, w.Address())
+	oracle.spend(6, served[0])
+
+	s := NewScanner(oracle, w, 0)
+	err := s.StreamScan(context.Background(), newTestStreamer(t, oracle), 1, 10, StreamConfig{
+		Mode:       StreamModeSlim,
+		MaxRetries: 1,
+		RetryDelay: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	utxo := findUTXO(w, served[0])
+	if utxo == nil || utxo.State != wallet.StateSpent || utxo.SpentHeight != 6 {
+		t.Fatalf("utxo %+v", utxo)
+	}
+	// utxos and spent index are only fetched for the blocks the filters matched
+	if n := oracle.count("utxos"); n != 1 {
+		t.Errorf("%d utxo requests", n)
+	}
+	if n := oracle.count("spent-index"); n != 1 {
+		t.Errorf("%d spent index requests", n)
+	}
+}
+
+func TestConvertBlockBatchFullTruncatesSpentHashes(t *testing.T) {
+	oracle := newTestOracle(5)
+	w := newTestWallet(t, 1)
+	served := oracle.pay(t, 2, 1, 10_000, w.Address())
+	oracle.spend(4, served[0])
+	block := oracle.block(4)
+
+	data, err := convertBlockBatchFull(&pb.BlockBatchFull{
+		BlockIdentifier: &pb.BlockIdentifier{BlockHash: block.hash[:], BlockHeight: 4},
+		SpentUtxos:      fullSpentHashes(block),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.spentIndex == nil || len(data.spentIndex.Data) != 1 {
+		t.Fatalf("spent index %+v", data.spentIndex)
+	}
+	if want := spentHashes(block)[0]; data.spentIndex.Data[0] != want {
+		t.Fatalf("short hash %x, want %x", data.spentIndex.Data[0], want)
+	}
+	if data.utxos == nil {
+		t.Fatal("served utxos are nil")
+	}
+}
@@ -0,0 +1,76 @@
+package types
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+)
+
+// FeeRate is a fee rate with a resolution of 1 sat/kvB, which allows fractional sat/vB rates like 1.5 sat/vB.
+// It is a struct so plain numbers do not convert to it, a number could be meant as sat/vB or sat/kvB.
+// Create it with FeeRateFromSatPerVByte or FeeRateFromSatPerKvB, the zero value is no fee rate.
+// In JSON it is encoded as a number in sat/kvB.
+type FeeRate struct {
+	satPerKvB uint64
+}
+
+// satPerKvBPerSatPerVByte is 1 sat/vB in sat/kvB
+const satPerKvBPerSatPerVByte = 1000
+
+// FeeRateFromSatPerKvB converts a rate in sat/kvB, as used by Bitcoin Core
+func FeeRateFromSatPerKvB(satPerKvB uint64) FeeRate {
+	return FeeRate{satPerKvB: satPerKvB}
+}
+
+// FeeRateFromSatPerVByte converts a rate in sat/vB, as returned by most fee APIs.
+// Rates below 1 sat/kvB are rounded up, negative rates return 0.
+func FeeRateFromSatPerVByte(satPerVByte float64) FeeRate {
+	if satPerVByte <= 0 || math.IsNaN(satPerVByte) {
+		return FeeRate{}
+	}
+	// the tolerance avoids rounding up 1.1 to 1101 because of floating point errors
+	return FeeRate{satPerKvB: uint64(math.Ceil(satPerVByte*satPerKvBPerSatPerVByte - 1e-6))}
+}
+
+// FeeForVSize returns the fee in sats for vSize vBytes, rounded up
+func (f FeeRate) FeeForVSize(vSize int64) uint64 {
+	return (uint64(vSize)*f.satPerKvB + satPerKvBPerSatPerVByte - 1) / satPerKvBPerSatPerVByte
+}
+
+// FeeForWeight returns the fee in sats for weight weight units, rounded up
+func (f FeeRate) FeeForWeight(weight int64) uint64 {
+	// 4 weight units are one vByte
+	return (uint64(weight)*f.satPerKvB + 4*satPerKvBPerSatPerVByte - 1) / (4 * satPerKvBPerSatPerVByte)
+}
+
+// SatPerKvB returns the fee rate in sat/kvB
+func (f FeeRate) SatPerKvB() uint64 {
+	return f.satPerKvB
+}
+
+// SatPerVB returns the fee rate in sat/vB
+func (f FeeRate) SatPerVB() float64 {
+	return float64(f.satPerKvB) / satPerKvBPerSatPerVByte
+}
+
+// IsZero returns true if no fee rate is set
+func (f FeeRate) IsZero() bool {
+	return f.satPerKvB == 0
+}
+
+// Less returns true if f is lower than other
+func (f FeeRate) Less(other FeeRate) bool {
+	return f.satPerKvB < other.satPerKvB
+}
+
+func (f FeeRate) String() string {
+	return strconv.FormatFloat(f.SatPerVB(), 'f', -1, 64) + " sat/vB"
+}
+
+func (f FeeRate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(f.satPerKvB)
+}
+
+func (f *FeeRate) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &f.satPerKvB)
+}
@@ -0,0 +1,103 @@
+package types
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestFeeRateFromSatPerVByte(t *testing.T) {
+	tests := []struct {
+		satPerVByte float64
+		satPerKvB   uint64
+	}{
+		{1, 1000},
+		{1.1, 1100},
+		{1.5, 1500},
+		{0.0001, 1},
+		{12.3456, 12346},
+		{0, 0},
+		{-1, 0},
+		{math.NaN(), 0},
+	}
+	for _, test := range tests {
+		if feeRate := FeeRateFromSatPerVByte(test.satPerVByte); feeRate.SatPerKvB() != test.satPerKvB {
+			t.Errorf("%v sat/vB: %d sat/kvB, want %d", test.satPerVByte, feeRate.SatPerKvB(), test.satPerKvB)
+		}
+	}
+}
+
+func TestFeeRateFee(t *testing.T) {
+	tests := []struct {
+		feeRate FeeRate
+		vSize   int64
+		fee     uint64
+	}{
+		{FeeRateFromSatPerVByte(2), 111, 222},
+		{FeeRateFromSatPerKvB(1500), 111, 167}, // 166.5 rounded up
+		{FeeRateFromSatPerKvB(1), 111, 1},
+		{FeeRateFromSatPerKvB(1500), 0, 0},
+	}
+	for _, test := range tests {
+		if fee := test.feeRate.FeeForVSize(test.vSize); fee != test.fee {
+			t.Errorf("%s for %d vB: %d, want %d", test.feeRate, test.vSize, fee, test.fee)
+		}
+	}
+
+	// 443 wu are 110.75 vB
+	if fee := FeeRateFromSatPerVByte(2).FeeForWeight(443); fee != 222 {
+		t.Errorf("fee for weight %d", fee)
+	}
+	if fee := FeeRateFromSatPerVByte(2).FeeForWeight(444); fee != 222 {
+		t.Errorf("fee for weight %d", fee)
+	}
+}
+
+func TestFeeRateString(t *testing.T) {
+	for satPerKvB, want := range map[uint64]string{
+		1000: "1 sat/vB",
+		1500: "1.5 sat/vB",
+		1:    "0.001 sat/vB",
+	} {
+		if s := FeeRateFromSatPerKvB(satPerKvB).String(); s != want {
+			t.Errorf("%d: %s, want %s", satPerKvB, s, want)
+		}
+	}
+}
+
+func TestFeeRateCompare(t *testing.T) {
+	low, high := FeeRateFromSatPerKvB(1000), FeeRateFromSatPerKvB(1001)
+	if !low.Less(high) || high.Less(low) || low.Less(low) {
+		t.Error("wrong order")
+	}
+	if !(FeeRate{}).IsZero() || low.IsZero() {
+		t.Error("wrong zero value")
+	}
+	if FeeRateFromSatPerVByte(1) != low {
+		t.Error("equal fee rates are not equal")
+	}
+}
+
+// FeeRate is encoded as a number in sat/kvB, as it was before it became a struct
+func TestFeeRateJSON(t *testing.T) {
+	data, err := json.Marshal(struct {
+		FeeRate FeeRate `json:"fee_rate"`
+		Zero    FeeRate `json:"zero,omitzero"`
+	}{FeeRate: FeeRateFromSatPerKvB(1500)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"fee_rate":1500}` {
+		t.Fatalf("encoded %s", data)
+	}
+
+	var decoded struct {
+		FeeRate FeeRate `json:"fee_rate"`
+	}
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.FeeRate != FeeRateFromSatPerKvB(1500) {
+		t.Fatalf("decoded %s", decoded.FeeRate)
+	}
+}
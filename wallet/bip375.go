@@ -0,0 +1,502 @@
+package wallet
+
+/*
+BIP375 - Sending Silent Payments with PSBTs
+
+The silent payment outputs of a transaction depend on all inputs.
+The PSBT therefore carries the silent payment addresses of the outputs
+and the ECDH shares of the inputs, the output scripts are filled in once all shares are known.
+
+Roles as used in this file:
+ 1. Constructor: CreateSilentPaymentPsbt adds inputs and outputs, SP outputs get an empty script
+ 2. Signer (ECDH): AddInputEcdhShare / AddGlobalEcdhShare
+ 3. Updater: ComputeSilentPaymentOutputs computes the output scripts
+ 4. Signer: SignTweakedPsbt / SignPsbtWithSigner (or any other taproot signer)
+
+btcd only supports PSBTv0 so the BIP375 fields are stored as unknowns.
+Only taproot inputs are supported at the moment.
+*/
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/setavenger/go-bip352"
+)
+
+// PSBT key types defined in BIP375
+const (
+	PsbtGlobalSpEcdhShare byte = 0x07
+	PsbtGlobalSpDleq      byte = 0x08
+	PsbtInSpEcdhShare     byte = 0x1d
+	PsbtInSpDleq          byte = 0x1e
+	PsbtOutSpV0Info       byte = 0x09
+	PsbtOutSpV0Label      byte = 0x0a
+)
+
+var (
+	ErrMissingEcdhShare     = errors.New("ecdh share missing for silent payment output")
+	ErrSpOutputsNotComputed = errors.New("silent payment outputs have not been computed yet")
+	ErrUnsupportedInput     = errors.New("only taproot inputs are supported for silent payments")
+)
+
+// SilentPaymentInfo is the value of PSBT_OUT_SP_V0_INFO.
+// SpendPubKey already includes the label if the address is labeled.
+type SilentPaymentInfo struct {
+	ScanPubKey  [33]byte
+	SpendPubKey [33]byte
+}
+
+func SetOutputSpInfo(output *psbt.POutput, info SilentPaymentInfo) {
+	value := append(info.ScanPubKey[:], info.SpendPubKey[:]...)
+	output.Unknowns = setUnknown(output.Unknowns, []byte{PsbtOutSpV0Info}, value)
+}
+
+// OutputSpInfo returns nil if the output is not a silent payment output
+func OutputSpInfo(output *psbt.POutput) (*SilentPaymentInfo, error) {
+	value := getUnknown(output.Unknowns, []byte{PsbtOutSpV0Info})
+	if value == nil {
+		return nil, nil
+	}
+	if len(value) != 66 {
+		return nil, fmt.Errorf("invalid silent payment info length %d", len(value))
+	}
+	return &SilentPaymentInfo{
+		ScanPubKey:  [33]byte(value[:33]),
+		SpendPubKey: [33]byte(value[33:]),
+	}, nil
+}
+
+// SetOutputSpLabel records the label m of the output.
+// Lets a signer verify that the output (e.g. change) goes back to its own wallet.
+func SetOutputSpLabel(output *psbt.POutput, m uint32) {
+	var value [4]byte
+	binary.LittleEndian.PutUint32(value[:], m)
+	output.Unknowns = setUnknown(output.Unknowns, []byte{PsbtOutSpV0Label}, value[:])
+}
+
+// OutputSpLabel returns false if the output has no label
+func OutputSpLabel(output *psbt.POutput) (uint32, bool, error) {
+	value := getUnknown(output.Unknowns, []byte{PsbtOutSpV0Label})
+	if value == nil {
+		return 0, false, nil
+	}
+	if len(value) != 4 {
+		return 0, false, fmt.Errorf("invalid silent payment label length %d", len(value))
+	}
+	return binary.LittleEndian.Uint32(value), true, nil
+}
+
+// CreateSilentPaymentPsbt creates a PSBT for the vins and recipients.
+// Silent payment recipients get an output with an empty script and PSBT_OUT_SP_V0_INFO,
+// the script is computed by ComputeSilentPaymentOutputs once all ECDH shares were added.
+// vins need the Amount and ScriptPubKey, secret keys are not needed.
+// Inputs and outputs are sorted according to BIP 69, silent payment outputs are sorted by amount only.
+func CreateSilentPaymentPsbt(
+	recipients []Recipient,
+	vins []*bip352.Vin,
+	chainParams *chaincfg.Params,
+) (
+	*psbt.Packet, error,
+) {
+	mainnet := chainParams.Name == chaincfg.MainNetParams.Name
+
+	var txOutputs []*wire.TxOut
+	var pOutputs []psbt.POutput
+	for _, recipient := range recipients {
+		if recipient.GetAmount() == 0 {
+			return nil, ErrRecipientAmountIsZero
+		}
+
+		if len(recipient.GetPkScript()) > 0 || !bip352.IsSilentPaymentAddress(recipient.GetAddress()) {
+			// ParseRecipients does not need the vins if there are no silent payment recipients
+			parsed, err := ParseRecipients([]Recipient{recipient}, nil, chainParams)
+			if err != nil {
+				return nil, err
+			}
+			txOutputs = append(txOutputs, wire.NewTxOut(int64(recipient.GetAmount()), parsed[0].GetPkScript()))
+			pOutputs = append(pOutputs, psbt.POutput{})
+			continue
+		}
+
+		scanPubKey, spendPubKey, err := bip352.DecodeSilentPaymentAddressToKeys(recipient.GetAddress(), mainnet)
+		if err != nil {
+			return nil, err
+		}
+		var pOutput psbt.POutput
+		SetOutputSpInfo(&pOutput, SilentPaymentInfo{ScanPubKey: scanPubKey, SpendPubKey: spendPubKey})
+		txOutputs = append(txOutputs, wire.NewTxOut(int64(recipient.GetAmount()), nil))
+		pOutputs = append(pOutputs, pOutput)
+	}
+
+	var txInputs []*wire.TxIn
+	var pInputs []psbt.PInput
+	for _, vin := range vins {
+		if !bip352.IsP2TR(vin.ScriptPubKey) {
+			return nil, ErrUnsupportedInput
+		}
+		hash, err := chainhash.NewHash(bip352.ReverseBytesCopy(vin.Txid[:]))
+		if err != nil {
+			return nil, err
+		}
+		txIn := wire.NewTxIn(wire.NewOutPoint(hash, vin.Vout), nil, nil)
+		txIn.Sequence = RBFSequence
+		txInputs = append(txInputs, txIn)
+		pInputs = append(pInputs, psbt.PInput{
+			WitnessUtxo: wire.NewTxOut(int64(vin.Amount), vin.ScriptPubKey),
+		})
+	}
+
+	// sort inputs and outputs together with their psbt counterparts
+	sortInputs(txInputs, pInputs)
+	sortOutputs(txOutputs, pOutputs)
+
+	return &psbt.Packet{
+		UnsignedTx: &wire.MsgTx{
+			Version: 2,
+			TxIn:    txInputs,
+			TxOut:   txOutputs,
+		},
+		Inputs:  pInputs,
+		Outputs: pOutputs,
+	}, nil
+}
+
+// AddInputEcdhShare adds the ECDH share of the input at index for every silent payment scan key in the outputs.
+// Every share comes with a DLEQ proof (BIP374), so other parties can verify it.
+// secretKey is the secret key of the spent output, it is negated if needed.
+func AddInputEcdhShare(packet *psbt.Packet, index int, secretKey [32]byte) error {
+	if index < 0 || index >= len(packet.Inputs) {
+		return fmt.Errorf("input index %d out of range", index)
+	}
+	input := &packet.Inputs[index]
+	if input.WitnessUtxo == nil || !bip352.IsP2TR(input.WitnessUtxo.PkScript) {
+		return ErrUnsupportedInput
+	}
+
+	scanKeys, err := outputScanKeys(packet)
+	if err != nil {
+		return err
+	}
+
+	secretKey = evenSecretKey(secretKey)
+	for _, scanKey := range scanKeys {
+		share, err := CreateEcdhShare(secretKey, scanKey)
+		if err != nil {
+			return err
+		}
+		setInputEcdhShare(input, share)
+	}
+
+	return nil
+}
+
+// setInputEcdhShare stores the share and its proof in the input
+func setInputEcdhShare(input *psbt.PInput, share *EcdhShare) {
+	input.Unknowns = setUnknown(input.Unknowns, append([]byte{PsbtInSpEcdhShare}, share.ScanPubKey[:]...), share.Share[:])
+	input.Unknowns = setUnknown(input.Unknowns, append([]byte{PsbtInSpDleq}, share.ScanPubKey[:]...), share.Proof[:])
+}
+
+// AddGlobalEcdhShare adds one ECDH share covering all inputs for every silent payment scan key in the outputs.
+// Can only be used if a single party holds all input secret keys.
+// secretKeys have to be in the same order as the inputs of the packet.
+func AddGlobalEcdhShare(packet *psbt.Packet, secretKeys [][32]byte) error {
+	if len(secretKeys) != len(packet.Inputs) {
+		return fmt.Errorf("mismatch with psbt inputs (%d) and secret keys (%d)", len(packet.Inputs), len(secretKeys))
+	}
+
+	scanKeys, err := outputScanKeys(packet)
+	if err != nil {
+		return err
+	}
+
+	evenKeys := make([][32]byte, len(secretKeys))
+	for i, secretKey := range secretKeys {
+		input := packet.Inputs[i]
+		if input.WitnessUtxo == nil || !bip352.IsP2TR(input.WitnessUtxo.PkScript) {
+			return ErrUnsupportedInput
+		}
+		evenKeys[i] = evenSecretKey(secretKey)
+	}
+	secretKeySum := bip352.RecursiveAddPrivateKeys(evenKeys)
+
+	for _, scanKey := range scanKeys {
+		share, err := CreateEcdhShare(secretKeySum, scanKey)
+		if err != nil {
+			return err
+		}
+		setGlobalEcdhShare(packet, share)
+	}
+
+	return nil
+}
+
+// setGlobalEcdhShare stores the share and its proof in the global section of the packet
+func setGlobalEcdhShare(packet *psbt.Packet, share *EcdhShare) {
+	packet.Unknowns = setUnknown(packet.Unknowns, append([]byte{PsbtGlobalSpEcdhShare}, share.ScanPubKey[:]...), share.Share[:])
+	packet.Unknowns = setUnknown(packet.Unknowns, append([]byte{PsbtGlobalSpDleq}, share.ScanPubKey[:]...), share.Proof[:])
+}
+
+// ComputeSilentPaymentOutputs computes the scripts of all silent payment outputs.
+// Needs either a global ECDH share or a share of every input for each scan key.
+// The DLEQ proofs of all used shares are verified, shares without a valid proof are rejected.
+// Outputs to the same scan key get increasing k in the order they appear in the transaction.
+func ComputeSilentPaymentOutputs(packet *psbt.Packet) error {
+	if len(packet.Outputs) != len(packet.UnsignedTx.TxOut) {
+		return fmt.Errorf("mismatch with txOuts (%d) and psbt outputs (%d)", len(packet.UnsignedTx.TxOut), len(packet.Outputs))
+	}
+
+	scanKeys, err := outputScanKeys(packet)
+	if err != nil {
+		return err
+	}
+	if len(scanKeys) == 0 {
+		return nil
+	}
+
+	inputHash, pubKeys, err := psbtInputHash(packet)
+	if err != nil {
+		return err
+	}
+
+	sharedSecrets := make(map[[33]byte]*[33]byte, len(scanKeys))
+	for _, scanKey := range scanKeys {
+		share, err := combinedEcdhShare(packet, scanKey, pubKeys)
+		if err != nil {
+			return err
+		}
+		// shared_secret = input_hash * (a_sum * B_scan)
+		hashCopy := *inputHash
+		sharedSecret, err := bip352.CreateSharedSecret(&share, &hashCopy, nil)
+		if err != nil {
+			return err
+		}
+		sharedSecrets[scanKey] = sharedSecret
+	}
+
+	counters := make(map[[33]byte]uint32, len(scanKeys))
+	for i := range packet.Outputs {
+		info, err := OutputSpInfo(&packet.Outputs[i])
+		if err != nil {
+			return err
+		}
+		if info == nil {
+			continue
+		}
+
+		k := counters[info.ScanPubKey]
+		output, err := bip352.CreateOutputPubKey(*sharedSecrets[info.ScanPubKey], info.SpendPubKey, k)
+		if err != nil {
+			return err
+		}
+		counters[info.ScanPubKey] = k + 1
+
+		packet.UnsignedTx.TxOut[i].PkScript = append([]byte{0x51, 0x20}, output[:]...)
+	}
+
+	return nil
+}
+
+// combinedEcdhShare returns the global share for scanKey or the sum of all input shares.
+// pubKeys are the public keys of the inputs, needed to verify the DLEQ proofs.
+func combinedEcdhShare(packet *psbt.Packet, scanKey [33]byte, pubKeys [][33]byte) ([33]byte, error) {
+	global, err := psbtEcdhShare(packet.Unknowns, PsbtGlobalSpEcdhShare, PsbtGlobalSpDleq, scanKey)
+	if err != nil {
+		return [33]byte{}, err
+	}
+	if global != nil {
+		publicKeySum, err := bip352.SumPublicKeys(pubKeys)
+		if err != nil {
+			return [33]byte{}, err
+		}
+		global.PubKey = *publicKeySum
+		err = global.Verify()
+		if err != nil {
+			return [33]byte{}, fmt.Errorf("global ecdh share: %w", err)
+		}
+		return global.Share, nil
+	}
+
+	shares := make([][33]byte, len(packet.Inputs))
+	for i, input := range packet.Inputs {
+		share, err := psbtEcdhShare(input.Unknowns, PsbtInSpEcdhShare, PsbtInSpDleq, scanKey)
+		if err != nil {
+			return [33]byte{}, fmt.Errorf("input %d: %w", i, err)
+		}
+		if share == nil {
+			return [33]byte{}, fmt.Errorf("%w: input %d scan key %x", ErrMissingEcdhShare, i, scanKey)
+		}
+		share.PubKey = pubKeys[i]
+		err = share.Verify()
+		if err != nil {
+			return [33]byte{}, fmt.Errorf("input %d: %w", i, err)
+		}
+		shares[i] = share.Share
+	}
+
+	sum, err := bip352.SumPublicKeys(shares)
+	if err != nil {
+		return [33]byte{}, err
+	}
+	return *sum, nil
+}
+
+// psbtEcdhShare reads the share and proof for scanKey, returns nil if there is no share.
+// The PubKey of the returned share is not set.
+func psbtEcdhShare(unknowns []*psbt.Unknown, shareType, proofType byte, scanKey [33]byte) (*EcdhShare, error) {
+	share := getUnknown(unknowns, append([]byte{shareType}, scanKey[:]...))
+	if share == nil {
+		return nil, nil
+	}
+	if len(share) != 33 {
+		return nil, fmt.Errorf("invalid ecdh share length %d", len(share))
+	}
+
+	proof := getUnknown(unknowns, append([]byte{proofType}, scanKey[:]...))
+	if proof == nil {
+		return nil, ErrMissingDLEQProof
+	}
+	if len(proof) != 64 {
+		return nil, fmt.Errorf("invalid dleq proof length %d", len(proof))
+	}
+
+	return &EcdhShare{
+		ScanPubKey: scanKey,
+		Share:      [33]byte(share),
+		Proof:      [64]byte(proof),
+	}, nil
+}
+
+// psbtInputHash computes the BIP352 input_hash from the inputs of the packet.
+// Also returns the public keys of the inputs.
+func psbtInputHash(packet *psbt.Packet) (*[32]byte, [][33]byte, error) {
+	if len(packet.Inputs) != len(packet.UnsignedTx.TxIn) {
+		return nil, nil, fmt.Errorf("mismatch with txIns (%d) and psbt inputs (%d)", len(packet.UnsignedTx.TxIn), len(packet.Inputs))
+	}
+
+	vins := make([]*bip352.Vin, len(packet.Inputs))
+	pubKeys := make([][33]byte, len(packet.Inputs))
+	for i, input := range packet.Inputs {
+		if input.WitnessUtxo == nil || !bip352.IsP2TR(input.WitnessUtxo.PkScript) {
+			return nil, nil, ErrUnsupportedInput
+		}
+		outpoint := packet.UnsignedTx.TxIn[i].PreviousOutPoint
+		vins[i] = &bip352.Vin{
+			Txid: [32]byte(bip352.ReverseBytesCopy(outpoint.Hash[:])),
+			Vout: outpoint.Index,
+		}
+		// taproot keys are always even
+		pubKeys[i][0] = 0x02
+		copy(pubKeys[i][1:], input.WitnessUtxo.PkScript[2:])
+	}
+
+	publicKeySum, err := bip352.SumPublicKeys(pubKeys)
+	if err != nil {
+		return nil, nil, err
+	}
+	inputHash, err := bip352.ComputeInputHash(vins, publicKeySum)
+	if err != nil {
+		return nil, nil, err
+	}
+	return inputHash, pubKeys, nil
+}
+
+// outputScanKeys returns the distinct scan keys of all silent payment outputs
+func outputScanKeys(packet *psbt.Packet) ([][33]byte, error) {
+	var scanKeys [][33]byte
+	seen := make(map[[33]byte]struct{})
+	for i := range packet.Outputs {
+		info, err := OutputSpInfo(&packet.Outputs[i])
+		if err != nil {
+			return nil, err
+		}
+		if info == nil {
+			continue
+		}
+		if _, ok := seen[info.ScanPubKey]; ok {
+			continue
+		}
+		seen[info.ScanPubKey] = struct{}{}
+		scanKeys = append(scanKeys, info.ScanPubKey)
+	}
+	return scanKeys, nil
+}
+
+// ecdhShare computes secretKey * scanKey
+func ecdhShare(scanKey [33]byte, secretKey [32]byte) ([33]byte, error) {
+	share, err := bip352.CreateSharedSecret(&scanKey, &secretKey, nil)
+	if err != nil {
+		return [33]byte{}, err
+	}
+	return *share, nil
+}
+
+// evenSecretKey negates the key if its public key has an odd y coordinate, as taproot keys are always even
+func evenSecretKey(secretKey [32]byte) [32]byte {
+	_, pk := btcec.PrivKeyFromBytes(secretKey[:])
+	if pk.Y().Bit(0) == 1 {
+		return bip352.NegateSecretKey(secretKey)
+	}
+	return secretKey
+}
+
+// sortInputs sorts by the outpoint according to BIP 69
+func sortInputs(txIns []*wire.TxIn, pInputs []psbt.PInput) {
+	sort.Sort(&inputSorter{txIns: txIns, pInputs: pInputs})
+}
+
+// sortOutputs sorts by amount and script according to BIP 69
+func sortOutputs(txOuts []*wire.TxOut, pOutputs []psbt.POutput) {
+	sort.Sort(&outputSorter{txOuts: txOuts, pOutputs: pOutputs})
+}
+
+type inputSorter struct {
+	txIns   []*wire.TxIn
+	pInputs []psbt.PInput
+}
+
+func (s *inputSorter) Len() int { return len(s.txIns) }
+
+func (s *inputSorter) Swap(i, j int) {
+	s.txIns[i], s.txIns[j] = s.txIns[j], s.txIns[i]
+	s.pInputs[i], s.pInputs[j] = s.pInputs[j], s.pInputs[i]
+}
+
+func (s *inputSorter) Less(i, j int) bool {
+	a, b := s.txIns[i].PreviousOutPoint, s.txIns[j].PreviousOutPoint
+	if a.Hash != b.Hash {
+		// BIP 69 compares the txid in display order
+		for k := chainhash.HashSize - 1; k >= 0; k-- {
+			if a.Hash[k] != b.Hash[k] {
+				return a.Hash[k] < b.Hash[k]
+			}
+		}
+	}
+	return a.Index < b.Index
+}
+
+type outputSorter struct {
+	txOuts   []*wire.TxOut
+	pOutputs []psbt.POutput
+}
+
+func (s *outputSorter) Len() int { return len(s.txOuts) }
+
+func (s *outputSorter) Swap(i, j int) {
+	s.txOuts[i], s.txOuts[j] = s.txOuts[j], s.txOuts[i]
+	s.pOutputs[i], s.pOutputs[j] = s.pOutputs[j], s.pOutputs[i]
+}
+
+func (s *outputSorter) Less(i, j int) bool {
+	if s.txOuts[i].Value != s.txOuts[j].Value {
+		return s.txOuts[i].Value < s.txOuts[j].Value
+	}
+	return string(s.txOuts[i].PkScript) < string(s.txOuts[j].PkScript)
+}
@@ -0,0 +1,246 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/setavenger/go-bip352"
+)
+
+// testSpVins returns n taproot inputs with secret keys derived from their index
+func testSpVins(n int) ([]*bip352.Vin, [][32]byte) {
+	vins := make([]*bip352.Vin, n)
+	secretKeys := make([][32]byte, n)
+	for i := range vins {
+		secretKeys[i] = [32]byte{byte(i + 1), 0xcc}
+		pubKey := bip352.PubKeyFromSecKey(&secretKeys[i])
+		secretKey := secretKeys[i]
+		vins[i] = &bip352.Vin{
+			Txid:         [32]byte{byte(n - i), 31: byte(i)},
+			Vout:         uint32(i),
+			Amount:       uint64(10_000 * (i + 1)),
+			SecretKey:    &secretKey,
+			Taproot:      true,
+			ScriptPubKey: append([]byte{txscript.OP_1, txscript.OP_DATA_32}, pubKey[1:]...),
+		}
+	}
+	return vins, secretKeys
+}
+
+// testSpPsbt pays two outputs to a silent payment address and one to a plain taproot script
+func testSpPsbt(t *testing.T, address string, vins []*bip352.Vin) *psbt.Packet {
+	t.Helper()
+	plain := append([]byte{txscript.OP_1, txscript.OP_DATA_32}, bytes.Repeat([]byte{0xee}, 32)...)
+	packet, err := CreateSilentPaymentPsbt([]Recipient{
+		&RecipientImpl{Address: address, Amount: 30_000},
+		&RecipientImpl{PkScript: plain, Amount: 5_000},
+		&RecipientImpl{Address: address, Amount: 20_000},
+	}, vins, &chaincfg.SigNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+// expectedSpOutputs computes the outputs for the amounts in transaction order with the sending code of go-bip352
+func expectedSpOutputs(t *testing.T, address string, vins []*bip352.Vin, amounts ...uint64) map[uint64][]byte {
+	t.Helper()
+	recipients := make([]*bip352.Recipient, len(amounts))
+	for i, amount := range amounts {
+		recipients[i] = &bip352.Recipient{SilentPaymentAddress: address, Amount: amount}
+	}
+	if err := bip352.SenderCreateOutputs(recipients, vins, false, false); err != nil {
+		t.Fatal(err)
+	}
+	scripts := make(map[uint64][]byte, len(recipients))
+	for _, recipient := range recipients {
+		scripts[recipient.Amount] = append([]byte{txscript.OP_1, txscript.OP_DATA_32}, recipient.Output[:]...)
+	}
+	return scripts
+}
+
+func checkSpOutputs(t *testing.T, packet *psbt.Packet, want map[uint64][]byte) {
+	t.Helper()
+	for i, txOut := range packet.UnsignedTx.TxOut {
+		script, ok := want[uint64(txOut.Value)]
+		if !ok {
+			continue
+		}
+		if !bytes.Equal(txOut.PkScript, script) {
+			t.Fatalf("output %d script %x, want %x", i, txOut.PkScript, script)
+		}
+	}
+}
+
+func TestCreateSilentPaymentPsbt(t *testing.T) {
+	recipient := newTestWallet(t, 2)
+	vins, _ := testSpVins(3)
+	packet := testSpPsbt(t, recipient.Address(), vins)
+
+	// outputs are sorted by amount, silent payment outputs have no script yet
+	var amounts []int64
+	for i, txOut := range packet.UnsignedTx.TxOut {
+		amounts = append(amounts, txOut.Value)
+		info, err := OutputSpInfo(&packet.Outputs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (info != nil) != (len(txOut.PkScript) == 0) {
+			t.Fatalf("output %d: info %v script %x", i, info, txOut.PkScript)
+		}
+		if info != nil && (info.ScanPubKey != recipient.PubKeyScan || info.SpendPubKey != recipient.PubKeySpend) {
+			t.Fatalf("output %d: info %+v", i, info)
+		}
+	}
+	if amounts[0] != 5_000 || amounts[1] != 20_000 || amounts[2] != 30_000 {
+		t.Fatalf("amounts %v", amounts)
+	}
+
+	// inputs are sorted by the txid in display order and keep their witness utxo
+	for i, txIn := range packet.UnsignedTx.TxIn {
+		vin := vins[txIn.PreviousOutPoint.Index]
+		if want := vins[len(vins)-1-i]; vin != want {
+			t.Fatalf("input %d spends vin %d", i, txIn.PreviousOutPoint.Index)
+		}
+		if !bytes.Equal(packet.Inputs[i].WitnessUtxo.PkScript, vin.ScriptPubKey) || packet.Inputs[i].WitnessUtxo.Value != int64(vin.Amount) {
+			t.Fatalf("input %d witness utxo does not belong to the outpoint", i)
+		}
+		if txIn.Sequence != RBFSequence {
+			t.Fatalf("input %d sequence %x", i, txIn.Sequence)
+		}
+	}
+
+	// the silent payment fields survive serialisation
+	parsed := transferPsbt(t, packet)
+	for i := range parsed.Outputs {
+		info, err := OutputSpInfo(&parsed.Outputs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (info != nil) != (len(parsed.UnsignedTx.TxOut[i].PkScript) == 0) {
+			t.Fatalf("output %d lost its info", i)
+		}
+	}
+
+	p2wpkh := &RecipientImpl{PkScript: []byte{0x00, 0x14, 19: 0}, Amount: 1_000}
+	if _, err := CreateSilentPaymentPsbt([]Recipient{p2wpkh}, []*bip352.Vin{{ScriptPubKey: p2wpkh.PkScript}}, &chaincfg.SigNetParams); !errors.Is(err, ErrUnsupportedInput) {
+		t.Fatalf("p2wpkh input: %v", err)
+	}
+	if _, err := CreateSilentPaymentPsbt([]Recipient{&RecipientImpl{Address: recipient.Address()}}, vins, &chaincfg.SigNetParams); !errors.Is(err, ErrRecipientAmountIsZero) {
+		t.Fatalf("zero amount: %v", err)
+	}
+}
+
+func TestOutputSpLabel(t *testing.T) {
+	var output psbt.POutput
+	if _, ok, err := OutputSpLabel(&output); ok || err != nil {
+		t.Fatalf("label without field: %t %v", ok, err)
+	}
+	SetOutputSpLabel(&output, 258)
+	SetOutputSpLabel(&output, 7)
+	if len(output.Unknowns) != 1 {
+		t.Fatalf("%d unknowns", len(output.Unknowns))
+	}
+	if m, ok, err := OutputSpLabel(&output); m != 7 || !ok || err != nil {
+		t.Fatalf("label %d %t %v", m, ok, err)
+	}
+
+	output.Unknowns[0].Value = output.Unknowns[0].Value[:3]
+	if _, _, err := OutputSpLabel(&output); err == nil {
+		t.Fatal("accepted a label of 3 bytes")
+	}
+}
+
+func TestComputeSilentPaymentOutputsInputShares(t *testing.T) {
+	recipient := newTestWallet(t, 2)
+	vins, secretKeys := testSpVins(3)
+	packet := testSpPsbt(t, recipient.Address(), vins)
+
+	for i, txIn := range packet.UnsignedTx.TxIn {
+		if err := ComputeSilentPaymentOutputs(packet); !errors.Is(err, ErrMissingEcdhShare) {
+			t.Fatalf("%d of 3 shares: %v", i, err)
+		}
+		if err := AddInputEcdhShare(packet, i, secretKeys[txIn.PreviousOutPoint.Index]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	packet = transferPsbt(t, packet)
+	if err := ComputeSilentPaymentOutputs(packet); err != nil {
+		t.Fatal(err)
+	}
+
+	checkSpOutputs(t, packet, expectedSpOutputs(t, recipient.Address(), vins, 20_000, 30_000))
+	if !bytes.Equal(packet.UnsignedTx.TxOut[0].PkScript[2:], bytes.Repeat([]byte{0xee}, 32)) {
+		t.Fatalf("plain output changed to %x", packet.UnsignedTx.TxOut[0].PkScript)
+	}
+
+	if err := AddInputEcdhShare(packet, 3, secretKeys[0]); err == nil {
+		t.Fatal("added a share for a missing input")
+	}
+}
+
+func TestComputeSilentPaymentOutputsGlobalShare(t *testing.T) {
+	recipient := newTestWallet(t, 2)
+	vins, secretKeys := testSpVins(2)
+	packet := testSpPsbt(t, recipient.Address(), vins)
+
+	if err := AddGlobalEcdhShare(packet, secretKeys[:1]); err == nil {
+		t.Fatal("global share with a missing key")
+	}
+	ordered := make([][32]byte, len(packet.UnsignedTx.TxIn))
+	for i, txIn := range packet.UnsignedTx.TxIn {
+		ordered[i] = secretKeys[txIn.PreviousOutPoint.Index]
+	}
+	if err := AddGlobalEcdhShare(packet, ordered); err != nil {
+		t.Fatal(err)
+	}
+	packet = transferPsbt(t, packet)
+	if err := ComputeSilentPaymentOutputs(packet); err != nil {
+		t.Fatal(err)
+	}
+	checkSpOutputs(t, packet, expectedSpOutputs(t, recipient.Address(), vins, 20_000, 30_000))
+}
+
+// Shares are only used with a valid proof
+func TestComputeSilentPaymentOutputsRejectsInvalidShares(t *testing.T) {
+	recipient := newTestWallet(t, 2)
+	vins, secretKeys := testSpVins(2)
+
+	for name, tamper := range map[string]func(*psbt.PInput){
+		"share": func(input *psbt.PInput) {
+			// a share of another key with the proof of the original one
+			share, err := CreateEcdhShare([32]byte{0x99}, recipient.PubKeyScan)
+			if err != nil {
+				t.Fatal(err)
+			}
+			input.Unknowns = setUnknown(input.Unknowns, append([]byte{PsbtInSpEcdhShare}, recipient.PubKeyScan[:]...), share.Share[:])
+		},
+		"proof": func(input *psbt.PInput) {
+			input.Unknowns = setUnknown(input.Unknowns, append([]byte{PsbtInSpDleq}, recipient.PubKeyScan[:]...), make([]byte, 64))
+		},
+	} {
+		packet := testSpPsbt(t, recipient.Address(), vins)
+		for i, txIn := range packet.UnsignedTx.TxIn {
+			if err := AddInputEcdhShare(packet, i, secretKeys[txIn.PreviousOutPoint.Index]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tamper(&packet.Inputs[1])
+		if err := ComputeSilentPaymentOutputs(packet); !errors.Is(err, ErrInvalidDLEQProof) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	packet := testSpPsbt(t, recipient.Address(), vins)
+	if err := AddInputEcdhShare(packet, 0, secretKeys[packet.UnsignedTx.TxIn[0].PreviousOutPoint.Index]); err != nil {
+		t.Fatal(err)
+	}
+	packet.Inputs[0].Unknowns = packet.Inputs[0].Unknowns[:1]
+	if err := ComputeSilentPaymentOutputs(packet); !errors.Is(err, ErrMissingDLEQProof) {
+		t.Fatalf("missing proof: %v", err)
+	}
+}
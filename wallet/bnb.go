@@ -0,0 +1,153 @@
+package wallet
+
+import (
+	"sort"
+
+	"github.com/setavenger/blindbit-lib/logging"
+)
+
+// DefaultBnBMaxTries limits the number of steps of the branch and bound search
+const DefaultBnBMaxTries = 100_000
+
+// BranchAndBoundSelector searches for an input set which funds the transaction without change.
+// The excess given to the miners has to stay below the cost of creating and later spending a change output.
+// Of all matching sets the one with the lowest waste is chosen (see Selection.Waste).
+// If no set is found within MaxTries steps the Fallback selector is used.
+//
+// This is the algorithm Bitcoin Core uses, see Murch, "An Evaluation of Coin Selection Strategies".
+type BranchAndBoundSelector struct {
+	// MaxTries defaults to DefaultBnBMaxTries
+	MaxTries int
+	// Fallback is used if no changeless solution exists, defaults to FeeRateCoinSelector
+	Fallback CoinSelector
+}
+
+func NewBranchAndBoundSelector() *BranchAndBoundSelector {
+	return &BranchAndBoundSelector{
+		MaxTries: DefaultBnBMaxTries,
+		Fallback: &FeeRateCoinSelector{},
+	}
+}
+
+func (s *BranchAndBoundSelector) SelectCoins(req *SelectionRequest) (*Selection, error) {
+	ctx, err := newSelectionContext(req)
+	if err != nil {
+		return nil, err
+	}
+
+	selection := s.search(ctx)
+	if selection != nil {
+		return selection, nil
+	}
+
+	logging.L.Debug().Msg("no changeless solution found, falling back")
+	fallback := s.Fallback
+	if fallback == nil {
+		fallback = &FeeRateCoinSelector{}
+	}
+	return fallback.SelectCoins(req)
+}
+
+// search runs the depth first search over the utxos sorted by descending effective value.
+// Every step either includes the next utxo or, if the current branch can not lead to a better solution,
+// backtracks to the last included utxo and excludes it instead.
+func (s *BranchAndBoundSelector) search(ctx *selectionContext) *Selection {
+	maxTries := s.MaxTries
+	if maxTries <= 0 {
+		maxTries = DefaultBnBMaxTries
+	}
+
+	// utxos which cost more to spend than they are worth are never part of a solution
+	var pool []*OwnedUTXO
+	var available int64
+	for _, utxo := range ctx.candidates() {
+		value := ctx.effectiveValue(utxo)
+		if value <= 0 {
+			continue
+		}
+		pool = append(pool, utxo)
+		available += value
+	}
+	sort.SliceStable(pool, func(i, j int) bool {
+		return pool[i].Amount > pool[j].Amount
+	})
+	values := make([]int64, len(pool))
+	for i, utxo := range pool {
+		values[i] = ctx.effectiveValue(utxo)
+	}
+
+	target := ctx.effectiveTarget()
+	upperBound := target + int64(ctx.costOfChange())
+	inputWaste := ctx.inputWaste()
+	// with a fee rate above the long term fee rate every additional input adds waste
+	feeRateHigh := ctx.longTermFeeRate.Less(ctx.req.FeeRate)
+
+	var (
+		current      []int
+		currentValue int64
+		currentWaste int64
+		best         []int
+		bestWaste    int64
+		found        bool
+	)
+
+	index := 0
+	for try := 0; try < maxTries; try, index = try+1, index+1 {
+		backtrack := false
+		switch {
+		case currentValue+available < target,
+			currentValue > upperBound,
+			found && feeRateHigh && currentWaste > bestWaste:
+			backtrack = true
+		case currentValue >= target:
+			waste := currentWaste + (currentValue - target)
+			if !found || waste <= bestWaste {
+				best = append(best[:0], current...)
+				bestWaste = waste
+				found = true
+			}
+			backtrack = true
+		}
+
+		if backtrack {
+			if len(current) == 0 {
+				// everything was explored
+				break
+			}
+			// add the utxos omitted after the last included one back
+			for index--; index > current[len(current)-1]; index-- {
+				available += values[index]
+			}
+			// exclude the last included utxo and continue with the next one
+			currentValue -= values[index]
+			currentWaste -= inputWaste
+			current = current[:len(current)-1]
+			continue
+		}
+
+		available -= values[index]
+		if index > 0 && (len(current) == 0 || current[len(current)-1] != index-1) && values[index] == values[index-1] {
+			// the previous utxo with the same value was excluded, including this one gives the same results
+			continue
+		}
+		current = append(current, index)
+		currentValue += values[index]
+		currentWaste += inputWaste
+	}
+
+	if !found {
+		return nil
+	}
+
+	utxos := make([]*OwnedUTXO, len(best))
+	for i, idx := range best {
+		utxos[i] = pool[idx]
+	}
+
+	// the effective values are rounded per input, check with the exact fee
+	selection, ok := ctx.newSelection(utxos, false)
+	if !ok || selection.Excess > ctx.costOfChange() {
+		return nil
+	}
+	return selection
+}
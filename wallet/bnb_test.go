@@ -0,0 +1,124 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/setavenger/blindbit-lib/types"
+)
+
+var errFallbackUsed = errors.New("fallback selector was used")
+
+// failingSelector fails the selection, to tell whether branch and bound found a solution
+type failingSelector struct{}
+
+func (failingSelector) SelectCoins(*SelectionRequest) (*Selection, error) {
+	return nil, errFallbackUsed
+}
+
+// fundTestUTXOs adds n utxos of amount to w, the senders are derived from the seeds firstSeed to firstSeed+n-1
+func fundTestUTXOs(t *testing.T, w *Wallet, firstSeed byte, n int, amount uint64) UtxoCollection {
+	t.Helper()
+	utxos := make(UtxoCollection, n)
+	for i := range utxos {
+		utxos[i] = fundTestWallet(t, w, firstSeed+byte(i), amount)
+	}
+	return utxos
+}
+
+func bnbRequest(w, recipient *Wallet, utxos UtxoCollection, amount uint64) *SelectionRequest {
+	return &SelectionRequest{
+		UTXOs:           utxos,
+		Recipients:      []Recipient{&RecipientImpl{Address: recipient.Address(), Amount: amount}},
+		FeeRate:         types.FeeRateFromSatPerVByte(2),
+		MinChangeAmount: w.DustPolicy().ChangeThreshold(),
+		ChainParams:     types.NetworkParams[w.Network],
+	}
+}
+
+// changelessAmount returns the amount the utxos pay to a single recipient after fees, minus a few sats of excess
+func changelessAmount(t *testing.T, w, recipient *Wallet, utxos ...*OwnedUTXO) uint64 {
+	t.Helper()
+	ctx, err := newSelectionContext(bnbRequest(w, recipient, utxos, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the effective target of an amount of 1 sat is the fee without inputs plus 1
+	amount := 1 - ctx.effectiveTarget()
+	for _, utxo := range utxos {
+		amount += ctx.effectiveValue(utxo)
+	}
+	return uint64(amount - 10)
+}
+
+func TestBranchAndBoundChangeless(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	utxos := fundTestUTXOs(t, w, 1, 3, 20_000)
+	utxos = append(utxos, fundTestUTXOs(t, w, 10, 2, 7_000)...)
+
+	// 20_000 + 7_000 minus the fees, no change is needed
+	amount := changelessAmount(t, w, recipient, utxos[0], utxos[3])
+	selector := &BranchAndBoundSelector{Fallback: failingSelector{}}
+	selection, err := selector.SelectCoins(bnbRequest(w, recipient, utxos, amount))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selection.UTXOs) != 2 || selection.Change != 0 {
+		t.Fatalf("%d inputs change %d", len(selection.UTXOs), selection.Change)
+	}
+	ctx, err := newSelectionContext(bnbRequest(w, recipient, utxos, amount))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selection.Excess > ctx.costOfChange() {
+		t.Fatalf("excess %d above the cost of change %d", selection.Excess, ctx.costOfChange())
+	}
+}
+
+func TestBranchAndBoundFallback(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	utxos := fundTestUTXOs(t, w, 1, 3, 20_000)
+
+	// every set of inputs leaves enough for change
+	_, err := (&BranchAndBoundSelector{Fallback: failingSelector{}}).SelectCoins(bnbRequest(w, recipient, utxos, 25_000))
+	if !errors.Is(err, errFallbackUsed) {
+		t.Fatalf("error %v, want the fallback", err)
+	}
+
+	selection, err := NewBranchAndBoundSelector().SelectCoins(bnbRequest(w, recipient, utxos, 25_000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selection.Change == 0 {
+		t.Fatal("fallback selection without change")
+	}
+}
+
+// Equal values are only tried once per position, also for the first input of a branch.
+// Otherwise every one of the large utxos would be tried as the first input before the small one.
+func TestBranchAndBoundManyEqualValues(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	utxos := fundTestUTXOs(t, w, 1, 100, 50_000)
+	small := fundTestWallet(t, w, 200, 10_000)
+	utxos = append(utxos, small)
+
+	// about one step per large utxo is needed, without skipping the equal first inputs it takes two
+	selector := &BranchAndBoundSelector{MaxTries: 150, Fallback: failingSelector{}}
+	selection, err := selector.SelectCoins(bnbRequest(w, recipient, utxos, changelessAmount(t, w, recipient, small)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selection.UTXOs) != 1 || selection.UTXOs[0] != small {
+		t.Fatalf("selected %d utxos", len(selection.UTXOs))
+	}
+
+	// several equal inputs make up the amount
+	amount := changelessAmount(t, w, recipient, utxos[:4]...)
+	selection, err = selector.SelectCoins(bnbRequest(w, recipient, utxos[:100], amount))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selection.UTXOs) != 4 || selection.Change != 0 {
+		t.Fatalf("%d inputs change %d", len(selection.UTXOs), selection.Change)
+	}
+}
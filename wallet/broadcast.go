@@ -0,0 +1,66 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/blindbit-lib/networking"
+	"github.com/setavenger/blindbit-lib/utils"
+	"github.com/setavenger/go-bip352"
+)
+
+// ErrTxidMismatch is returned if the broadcaster reports another txid than the one of the broadcast transaction
+var ErrTxidMismatch = errors.New("broadcaster returned a different txid")
+
+// BroadcastTransaction broadcasts rawTx and, only once that succeeded, marks the spent wallet utxos as StateUnconfirmedSpent.
+// Inputs which do not belong to the wallet are ignored.
+// If the wallet created the transaction it becomes pending and its change is added as StateUnconfirmed.
+// Use it with transactions built without markSpent, e.g. from SignProposal or SendToRecipients.
+// The returned txid is computed from rawTx. If the broadcaster reports another txid the wallet is updated
+// nonetheless, as the broadcast succeeded, and ErrTxidMismatch is returned.
+func (w *Wallet) BroadcastTransaction(broadcaster networking.Broadcaster, rawTx []byte) ([32]byte, error) {
+	var tx wire.MsgTx
+	err := tx.Deserialize(bytes.NewReader(rawTx))
+	if err != nil {
+		logging.L.Err(err).Msg("failed to deserialise transaction")
+		return [32]byte{}, err
+	}
+	txHash := tx.TxHash()
+	txid := utils.ConvertToFixedLength32(bip352.ReverseBytesCopy(txHash[:]))
+
+	broadcastTxid, err := broadcaster.Broadcast(rawTx)
+	if err != nil {
+		logging.L.Err(err).Msg("failed to broadcast transaction")
+		return [32]byte{}, err
+	}
+
+	var spent []*OwnedUTXO
+	for _, txIn := range tx.TxIn {
+		inputTxid := utils.ConvertToFixedLength32(bip352.ReverseBytesCopy(txIn.PreviousOutPoint.Hash[:]))
+		utxo := w.FindUTXO(inputTxid, txIn.PreviousOutPoint.Index)
+		if utxo == nil {
+			continue
+		}
+		spent = append(spent, utxo)
+	}
+	if len(spent) == 0 {
+		return txid, fmt.Errorf("transaction %x does not spend any utxo of the wallet", txid)
+	}
+
+	// transactions created by the wallet are tracked until they confirm
+	err = w.markPending(w.FindSentTransaction(txid), spent)
+	if err != nil {
+		return txid, err
+	}
+
+	if broadcastTxid != txid {
+		err = fmt.Errorf("%w: %x instead of %x", ErrTxidMismatch, broadcastTxid, txid)
+		logging.L.Err(err).Msg("")
+		return txid, err
+	}
+
+	return txid, nil
+}
@@ -0,0 +1,111 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/setavenger/blindbit-lib/networking"
+	"github.com/setavenger/blindbit-lib/types"
+)
+
+// wrongTxidBroadcaster accepts every transaction but reports a wrong txid
+type wrongTxidBroadcaster struct{}
+
+func (wrongTxidBroadcaster) Broadcast([]byte) ([32]byte, error) {
+	return [32]byte{0xff}, nil
+}
+
+// sendUnmarked creates a transaction from w to recipient without marking its inputs
+func sendUnmarked(t *testing.T, w, recipient *Wallet, amount uint64) []byte {
+	t.Helper()
+	rawTx, err := w.SendToRecipients(
+		[]Recipient{&RecipientImpl{Address: recipient.Address(), Amount: amount}},
+		w.UnspentUTXOs(), types.FeeRateFromSatPerVByte(2), w.DustPolicy().ChangeThreshold(), false, false,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rawTx
+}
+
+func TestBroadcastTransaction(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 50_000)
+	rawTx := sendUnmarked(t, w, recipient, 10_000)
+	if state := w.UTXOs[0].State; state != StateUnspent {
+		t.Fatalf("input marked before broadcasting: %s", state)
+	}
+
+	broadcaster := networking.NewMemoryBroadcaster()
+	txid, err := w.BroadcastTransaction(broadcaster, rawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := testTxid(decodeTestTx(t, rawTx)); txid != want {
+		t.Fatalf("txid %x, want %x", txid, want)
+	}
+	if _, ok := broadcaster.Transaction(txid); !ok {
+		t.Fatal("transaction was not broadcast")
+	}
+
+	sent := w.FindSentTransaction(txid)
+	if sent == nil || sent.State != TxStatePending {
+		t.Fatalf("sent transaction %+v", sent)
+	}
+	if state := w.findOutPoint(sent.Inputs[0]).State; state != StateUnconfirmedSpent {
+		t.Fatalf("input state %s", state)
+	}
+	if change := w.findOutPoint(sent.ChangeOutputs[0]); change == nil || change.State != StateUnconfirmed {
+		t.Fatalf("change %+v", change)
+	}
+}
+
+func TestBroadcastTransactionFailed(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 50_000)
+	rawTx := sendUnmarked(t, w, recipient, 10_000)
+
+	broadcaster := &networking.MemoryBroadcaster{Err: networking.ErrTxRejected}
+	_, err := w.BroadcastTransaction(broadcaster, rawTx)
+	if !errors.Is(err, networking.ErrTxRejected) {
+		t.Fatalf("error %v", err)
+	}
+	if state := w.UTXOs[0].State; state != StateUnspent {
+		t.Fatalf("input state %s after a failed broadcast", state)
+	}
+	if sent := w.SentTransactions[0]; sent.State != TxStateCreated {
+		t.Fatalf("sent transaction state %s", sent.State)
+	}
+}
+
+func TestBroadcastTransactionTxidMismatch(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 50_000)
+	rawTx := sendUnmarked(t, w, recipient, 10_000)
+
+	txid, err := w.BroadcastTransaction(wrongTxidBroadcaster{}, rawTx)
+	if !errors.Is(err, ErrTxidMismatch) {
+		t.Fatalf("error %v, want ErrTxidMismatch", err)
+	}
+	if want := testTxid(decodeTestTx(t, rawTx)); txid != want {
+		t.Fatalf("txid %x, want the local txid %x", txid, want)
+	}
+	// the transaction was broadcast, the wallet tracks it under its own txid
+	if sent := w.FindSentTransaction(txid); sent == nil || sent.State != TxStatePending {
+		t.Fatalf("sent transaction %+v", sent)
+	}
+	if state := w.UTXOs[0].State; state != StateUnconfirmedSpent {
+		t.Fatalf("input state %s", state)
+	}
+}
+
+func TestBroadcastTransactionForeignInputs(t *testing.T) {
+	w, other, recipient := newTestWallet(t, 1), newTestWallet(t, 3), newTestWallet(t, 2)
+	fundTestWallet(t, other, 1, 50_000)
+	rawTx := sendUnmarked(t, other, recipient, 10_000)
+
+	_, err := w.BroadcastTransaction(networking.NewMemoryBroadcaster(), rawTx)
+	if err == nil {
+		t.Fatal("broadcast a transaction without wallet inputs")
+	}
+}
@@ -0,0 +1,108 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+
+	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/blindbit-lib/utils"
+)
+
+// MaxCheckpoints is the number of recent checkpoints a wallet keeps.
+// Reorgs deeper than this can not be located precisely.
+const MaxCheckpoints = 100
+
+// Checkpoint is the hash of the block the wallet scanned at Height
+type Checkpoint struct {
+	Height    uint64
+	BlockHash [32]byte
+}
+
+type checkpointJSON struct {
+	Height    uint64 `json:"height"`
+	BlockHash string `json:"block_hash"`
+}
+
+func (c Checkpoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal(checkpointJSON{
+		Height:    c.Height,
+		BlockHash: hex.EncodeToString(c.BlockHash[:]),
+	})
+}
+
+func (c *Checkpoint) UnmarshalJSON(data []byte) error {
+	var aux checkpointJSON
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	blockHash, err := hex.DecodeString(aux.BlockHash)
+	if err != nil {
+		return err
+	}
+	*c = Checkpoint{
+		Height:    aux.Height,
+		BlockHash: utils.ConvertToFixedLength32(blockHash),
+	}
+	return nil
+}
+
+// Checkpoints is sorted by height in ascending order
+type Checkpoints []Checkpoint
+
+// AddCheckpoint records the block hash for height.
+// Checkpoints at the same or a greater height are replaced.
+// Only the most recent MaxCheckpoints are kept.
+func (w *Wallet) AddCheckpoint(height uint64, blockHash [32]byte) {
+	idx := sort.Search(len(w.Checkpoints), func(i int) bool {
+		return w.Checkpoints[i].Height >= height
+	})
+	w.Checkpoints = append(w.Checkpoints[:idx], Checkpoint{Height: height, BlockHash: blockHash})
+
+	if len(w.Checkpoints) > MaxCheckpoints {
+		w.Checkpoints = append(Checkpoints(nil), w.Checkpoints[len(w.Checkpoints)-MaxCheckpoints:]...)
+	}
+}
+
+// Rollback reverts the wallet to the state it had after scanning forkHeight.
+// UTXOs found above forkHeight are removed, UTXOs spent above forkHeight become unspent again.
+// Transactions confirmed above forkHeight are pending again.
+// LastScanHeight is set to forkHeight so the orphaned blocks are scanned again.
+func (w *Wallet) Rollback(forkHeight uint64) {
+	var kept UtxoCollection
+	for _, utxo := range w.UTXOs {
+		if utxo.Height > forkHeight {
+			key, err := utxo.GetKey()
+			if err == nil {
+				delete(w.UTXOMapping, key)
+			}
+			logging.L.Info().
+				Hex("txid", utxo.Txid[:]).
+				Uint32("vout", utxo.Vout).
+				Uint64("height", utxo.Height).
+				Msg("removed utxo from orphaned block")
+			continue
+		}
+
+		if utxo.State == StateSpent && utxo.SpentHeight > forkHeight {
+			utxo.State = StateUnspent
+			utxo.SpentHeight = 0
+			logging.L.Info().
+				Hex("txid", utxo.Txid[:]).
+				Uint32("vout", utxo.Vout).
+				Msg("reverted spend from orphaned block")
+		}
+		kept = append(kept, utxo)
+	}
+	w.UTXOs = kept
+	w.reopenConfirmed(forkHeight)
+
+	idx := sort.Search(len(w.Checkpoints), func(i int) bool {
+		return w.Checkpoints[i].Height > forkHeight
+	})
+	w.Checkpoints = w.Checkpoints[:idx]
+
+	if w.LastScanHeight > forkHeight {
+		w.LastScanHeight = forkHeight
+	}
+}
@@ -0,0 +1,98 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/setavenger/blindbit-lib/utils"
+	"github.com/setavenger/go-bip352"
+)
+
+var (
+	ErrUTXONotFound = errors.New("utxo not found in wallet")
+	ErrUTXOFrozen   = errors.New("utxo is frozen")
+)
+
+// WithMustInclude spends the outpoints in any case, the coin selector only adds utxos if they are not enough.
+// The outpoints have to belong to unspent, not frozen utxos of the wallet.
+func WithMustInclude(outpoints ...wire.OutPoint) SendOption {
+	return func(o *sendOptions) {
+		o.mustInclude = append(o.mustInclude, outpoints...)
+	}
+}
+
+// WithMustExclude never spends the outpoints
+func WithMustExclude(outpoints ...wire.OutPoint) SendOption {
+	return func(o *sendOptions) {
+		o.mustExclude = append(o.mustExclude, outpoints...)
+	}
+}
+
+// SetFrozen freezes or unfreezes the utxo with the given outpoint.
+// Frozen utxos are never selected to fund a transaction.
+func (w *Wallet) SetFrozen(outpoint wire.OutPoint, frozen bool) error {
+	utxo := w.findOutPoint(outpoint)
+	if utxo == nil {
+		return fmt.Errorf("%w: %s", ErrUTXONotFound, outpoint)
+	}
+	utxo.Frozen = frozen
+	return nil
+}
+
+// FrozenUTXOs returns all frozen utxos
+func (w *Wallet) FrozenUTXOs() UtxoCollection {
+	var utxos UtxoCollection
+	for _, utxo := range w.UTXOs {
+		if utxo.Frozen {
+			utxos = append(utxos, utxo)
+		}
+	}
+	return utxos
+}
+
+func (w *Wallet) findOutPoint(outpoint wire.OutPoint) *OwnedUTXO {
+	txid := utils.ConvertToFixedLength32(bip352.ReverseBytesCopy(outpoint.Hash[:]))
+	return w.FindUTXO(txid, outpoint.Index)
+}
+
+// applyCoinControl removes the excluded utxos and resolves the outpoints which must be included
+func (w *Wallet) applyCoinControl(utxos UtxoCollection, options *sendOptions) (UtxoCollection, []*OwnedUTXO, error) {
+	excluded := make(map[wire.OutPoint]struct{}, len(options.mustExclude))
+	for _, outpoint := range options.mustExclude {
+		excluded[outpoint] = struct{}{}
+	}
+
+	var mustInclude []*OwnedUTXO
+	for _, outpoint := range options.mustInclude {
+		if _, ok := excluded[outpoint]; ok {
+			return nil, nil, fmt.Errorf("outpoint %s is included and excluded", outpoint)
+		}
+		utxo := w.findOutPoint(outpoint)
+		if utxo == nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrUTXONotFound, outpoint)
+		}
+		if utxo.Frozen {
+			return nil, nil, fmt.Errorf("%w: %s", ErrUTXOFrozen, outpoint)
+		}
+		if utxo.State == StateSpent || utxo.State == StateUnconfirmedSpent {
+			return nil, nil, fmt.Errorf("utxo %s is already spent", outpoint)
+		}
+		if !containsUTXO(mustInclude, utxo) {
+			mustInclude = append(mustInclude, utxo)
+		}
+	}
+
+	if len(excluded) == 0 {
+		return utxos, mustInclude, nil
+	}
+
+	var filtered UtxoCollection
+	for _, utxo := range utxos {
+		if _, ok := excluded[utxo.OutPoint()]; ok {
+			continue
+		}
+		filtered = append(filtered, utxo)
+	}
+	return filtered, mustInclude, nil
+}
@@ -0,0 +1,144 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/setavenger/blindbit-lib/types"
+)
+
+// proposeTestTx proposes a transaction paying amount from all unspent utxos of w
+func proposeTestTx(w, recipient *Wallet, amount uint64, opts ...SendOption) (*TransactionProposal, error) {
+	return w.ProposeTransaction(
+		[]Recipient{&RecipientImpl{Address: recipient.Address(), Amount: amount}},
+		w.UnspentUTXOs(), types.FeeRateFromSatPerVByte(2), w.DustPolicy().ChangeThreshold(), opts...,
+	)
+}
+
+func TestWithMustInclude(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	first := fundTestWallet(t, w, 1, 50_000)
+	second := fundTestWallet(t, w, 2, 20_000)
+
+	// the first utxo alone would be enough
+	proposal, err := proposeTestTx(w, recipient, 10_000, WithMustInclude(second.OutPoint(), second.OutPoint()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proposal.Inputs) != 1 || proposal.Inputs[0] != second {
+		t.Fatalf("inputs %v", proposal.Inputs)
+	}
+
+	// the coin selector adds to the included utxo
+	proposal, err = proposeTestTx(w, recipient, 40_000, WithMustInclude(second.OutPoint()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proposal.Inputs) != 2 || !containsUTXO(proposal.Inputs, first) || !containsUTXO(proposal.Inputs, second) {
+		t.Fatalf("inputs %v", proposal.Inputs)
+	}
+}
+
+func TestWithMustExclude(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	first := fundTestWallet(t, w, 1, 50_000)
+	second := fundTestWallet(t, w, 2, 20_000)
+
+	proposal, err := proposeTestTx(w, recipient, 10_000, WithMustExclude(first.OutPoint()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proposal.Inputs) != 1 || proposal.Inputs[0] != second {
+		t.Fatalf("inputs %v", proposal.Inputs)
+	}
+
+	_, err = proposeTestTx(w, recipient, 30_000, WithMustExclude(first.OutPoint()))
+	if !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("error %v, want ErrInsufficientFunds", err)
+	}
+	_, err = proposeTestTx(w, recipient, 10_000, WithMustInclude(first.OutPoint()), WithMustExclude(first.OutPoint()))
+	if err == nil {
+		t.Fatal("included an excluded utxo")
+	}
+}
+
+func TestSetFrozen(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	first := fundTestWallet(t, w, 1, 50_000)
+	second := fundTestWallet(t, w, 2, 20_000)
+
+	if err := w.SetFrozen(first.OutPoint(), true); err != nil {
+		t.Fatal(err)
+	}
+	if frozen := w.FrozenUTXOs(); len(frozen) != 1 || frozen[0] != first {
+		t.Fatalf("frozen %v", frozen)
+	}
+
+	proposal, err := proposeTestTx(w, recipient, 10_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proposal.Inputs) != 1 || proposal.Inputs[0] != second {
+		t.Fatalf("spent a frozen utxo")
+	}
+	if _, err = proposeTestTx(w, recipient, 10_000, WithMustInclude(first.OutPoint())); !errors.Is(err, ErrUTXOFrozen) {
+		t.Fatalf("error %v, want ErrUTXOFrozen", err)
+	}
+
+	if err = w.SetFrozen(first.OutPoint(), false); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.FrozenUTXOs()) != 0 {
+		t.Fatal("utxo is still frozen")
+	}
+	if _, err = proposeTestTx(w, recipient, 10_000, WithMustInclude(first.OutPoint())); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCoinControlUnknownUTXO(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	spent := fundTestWallet(t, w, 1, 50_000)
+	fundTestWallet(t, w, 2, 20_000)
+	unknown := testOutPoint([32]byte{9}, 0)
+
+	if err := w.SetFrozen(unknown, true); !errors.Is(err, ErrUTXONotFound) {
+		t.Errorf("freeze: %v", err)
+	}
+	if _, err := proposeTestTx(w, recipient, 10_000, WithMustInclude(unknown)); !errors.Is(err, ErrUTXONotFound) {
+		t.Errorf("include: %v", err)
+	}
+
+	spent.State = StateUnconfirmedSpent
+	if _, err := proposeTestTx(w, recipient, 10_000, WithMustInclude(spent.OutPoint())); err == nil {
+		t.Error("included a spent utxo")
+	}
+}
+
+// UTXOs spent by a pending transaction are never selected, whichever selector is used
+func TestSelectorsSkipUnconfirmedSpent(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	pending := fundTestWallet(t, w, 1, 80_000)
+	pending.State = StateUnconfirmedSpent
+	fundTestUTXOs(t, w, 2, 3, 20_000)
+
+	for strategy := StrategyInOrder; strategy <= StrategyPrivacy; strategy++ {
+		selector, err := strategy.Selector()
+		if err != nil {
+			t.Fatal(err)
+		}
+		selection, err := selector.SelectCoins(bnbRequest(w, recipient, w.UTXOs, 30_000))
+		if err != nil {
+			t.Fatalf("%s: %v", strategy, err)
+		}
+		if containsUTXO(selection.UTXOs, pending) {
+			t.Errorf("%s selected a utxo spent by a pending transaction", strategy)
+		}
+
+		// it alone could fund the transaction
+		_, err = selector.SelectCoins(bnbRequest(w, recipient, w.UTXOs, 70_000))
+		if !errors.Is(err, ErrInsufficientFunds) {
+			t.Errorf("%s: error %v, want ErrInsufficientFunds", strategy, err)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package wallet
+
+import (
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/blindbit-lib/types"
+)
+
+// CoinSelector picks the utxos to fund a transaction
+type CoinSelector interface {
+	SelectCoins(req *SelectionRequest) (*Selection, error)
+}
+
+// SelectionRequest contains everything a CoinSelector needs to know.
+// All utxos are assumed to be taproot outputs.
+type SelectionRequest struct {
+	// UTXOs are the candidates, spent (also unconfirmed spent) and frozen utxos are skipped
+	UTXOs []*OwnedUTXO
+	// MustInclude utxos are part of every selection, the CoinSelector only adds to them
+	MustInclude []*OwnedUTXO
+	Recipients  []Recipient
+	FeeRate     types.FeeRate
+	// LongTermFeeRate is the fee rate expected when the utxos would be spent later instead.
+	// Only used for the waste metric, defaults to FeeRate.
+	LongTermFeeRate types.FeeRate
+	MinChangeAmount uint64
+	ChainParams     *chaincfg.Params
+}
+
+// Selection is the result of a coin selection
+type Selection struct {
+	UTXOs []*OwnedUTXO
+	// Change is the amount of the change output, 0 if no change output is created
+	Change uint64
+	// Fee is the absolute fee the transaction pays
+	Fee uint64
+	// Excess is the part of Fee which was added because creating change was not worth it
+	Excess uint64
+	// Waste is the waste metric of the selection in sats (see Bitcoin Core).
+	// Lower is better, the fees paid for the inputs now compared to LongTermFeeRate
+	// plus the cost of creating and later spending the change or the excess if there is no change.
+	Waste int64
+}
+
+// selectionContext precomputes the weights of a transaction for a SelectionRequest.
+// All inputs are taproot key path spends so the weight only depends on the number of inputs.
+type selectionContext struct {
+	req             *SelectionRequest
+	longTermFeeRate types.FeeRate
+
+	// target is the sum of all recipient amounts
+	target uint64
+
+	// weights with a single input, with and without a change output
+	weightOneInput       int64
+	weightOneInputChange int64
+	inputWeight          int64
+}
+
+func newSelectionContext(req *SelectionRequest) (*selectionContext, error) {
+	if req.FeeRate.IsZero() {
+		return nil, ErrInvalidFeeRate
+	}
+
+	var target uint64
+	for _, recipient := range req.Recipients {
+		if recipient.GetAmount() == 0 {
+			return nil, ErrRecipientAmountIsZero
+		}
+		target += recipient.GetAmount()
+	}
+
+	pkScripts, err := extractPkScriptsFromRecipients(req.Recipients, req.ChainParams)
+	if err != nil {
+		logging.L.Err(err).Any("recipients", req.Recipients).Msg("Error extracting pkScripts")
+		return nil, err
+	}
+
+	estimator := NewWeightEstimator()
+	for _, pkScript := range pkScripts {
+		estimator.AddOutput(pkScript)
+	}
+	estimator.AddTaprootInput()
+	weightOneInput := estimator.Weight()
+
+	withChange := estimator.Clone()
+	withChange.AddOutput(taprootPlaceholderScript)
+
+	estimator.AddTaprootInput()
+
+	ctx := &selectionContext{
+		req:                  req,
+		longTermFeeRate:      req.LongTermFeeRate,
+		target:               target,
+		weightOneInput:       weightOneInput,
+		weightOneInputChange: withChange.Weight(),
+		inputWeight:          estimator.Weight() - weightOneInput,
+	}
+	if ctx.longTermFeeRate.IsZero() {
+		ctx.longTermFeeRate = req.FeeRate
+	}
+	return ctx, nil
+}
+
+// candidates returns the utxos which can be selected
+func (c *selectionContext) candidates() []*OwnedUTXO {
+	var utxos []*OwnedUTXO
+	for _, utxo := range c.req.UTXOs {
+		if !c.req.isCandidate(utxo) {
+			continue
+		}
+		utxos = append(utxos, utxo)
+	}
+	return utxos
+}
+
+// isCandidate returns false for utxos which are spent (also by a pending transaction), frozen or already in MustInclude
+func (r *SelectionRequest) isCandidate(utxo *OwnedUTXO) bool {
+	if utxo.State == StateSpent || utxo.State == StateUnconfirmedSpent {
+		return false
+	}
+	return !utxo.Frozen && !containsUTXO(r.MustInclude, utxo)
+}
+
+// weight returns the exact weight of the transaction with nInputs inputs
+func (c *selectionContext) weight(nInputs int, withChange bool) int64 {
+	weight := c.weightOneInput
+	if withChange {
+		weight = c.weightOneInputChange
+	}
+	// the input count is a varint
+	varIntDiff := wire.VarIntSerializeSize(uint64(nInputs)) - wire.VarIntSerializeSize(1)
+	return weight + int64(nInputs-1)*c.inputWeight + int64(varIntDiff)*blockchain.WitnessScaleFactor
+}
+
+// fee returns the fee needed at the requested fee rate
+func (c *selectionContext) fee(nInputs int, withChange bool) uint64 {
+	return c.req.FeeRate.FeeForVSize(weightToVSize(c.weight(nInputs, withChange)))
+}
+
+// inputFee is the (fractional) fee of a single input at feeRate, rounded up
+func (c *selectionContext) inputFee(feeRate types.FeeRate) uint64 {
+	return feeRate.FeeForWeight(c.inputWeight)
+}
+
+// effectiveTarget is the effective value the utxos added by a CoinSelector have to cover.
+// These are the recipients and the fee of the transaction without inputs minus the effective value of MustInclude.
+func (c *selectionContext) effectiveTarget() int64 {
+	baseFee := int64(c.fee(1, false)) - int64(c.inputFee(c.req.FeeRate))
+	target := int64(c.target) + baseFee
+	for _, utxo := range c.req.MustInclude {
+		target -= c.effectiveValue(utxo)
+	}
+	return target
+}
+
+// effectiveValue is the amount of the utxo minus the fee to spend it
+func (c *selectionContext) effectiveValue(utxo *OwnedUTXO) int64 {
+	return int64(utxo.Amount) - int64(c.inputFee(c.req.FeeRate))
+}
+
+// inputWaste is the difference of spending an input now compared to the long term fee rate
+func (c *selectionContext) inputWaste() int64 {
+	return int64(c.inputFee(c.req.FeeRate)) - int64(c.inputFee(c.longTermFeeRate))
+}
+
+// changeOutputFee is the fee for adding the change output
+func (c *selectionContext) changeOutputFee() uint64 {
+	return c.req.FeeRate.FeeForVSize(weightToVSize(c.weightOneInputChange - c.weightOneInput))
+}
+
+// costOfChange is the fee of creating the change output now and spending it later
+func (c *selectionContext) costOfChange() uint64 {
+	return c.changeOutputFee() + c.inputFee(c.longTermFeeRate)
+}
+
+// newSelection evaluates utxos together with MustInclude and creates the selection.
+// A change output is added if the remainder is at least MinChangeAmount after paying for the change output.
+// Returns false if utxos do not cover the recipients and fees.
+func (c *selectionContext) newSelection(utxos []*OwnedUTXO, allowChange bool) (*Selection, bool) {
+	if len(c.req.MustInclude) > 0 {
+		utxos = append(append([]*OwnedUTXO(nil), c.req.MustInclude...), utxos...)
+	}
+
+	var sum uint64
+	for _, utxo := range utxos {
+		sum += utxo.Amount
+	}
+
+	n := len(utxos)
+	inputsWaste := int64(n) * c.inputWaste()
+
+	feeNoChange := c.fee(n, false)
+	if sum < c.target+feeNoChange {
+		return nil, false
+	}
+
+	if allowChange {
+		feeChange := c.fee(n, true)
+		if sum >= c.target+feeChange+c.req.MinChangeAmount {
+			return &Selection{
+				UTXOs:  utxos,
+				Change: sum - c.target - feeChange,
+				Fee:    feeChange,
+				Waste:  inputsWaste + int64(c.costOfChange()),
+			}, true
+		}
+	}
+
+	excess := sum - c.target - feeNoChange
+	return &Selection{
+		UTXOs:  utxos,
+		Fee:    feeNoChange + excess,
+		Excess: excess,
+		Waste:  inputsWaste + int64(excess),
+	}, true
+}
+
+// selectInOrder adds the utxos in the given order until the recipients and fees are covered.
+// First it tries to produce change of at least MinChangeAmount,
+// if that is not possible the smallest prefix covering the target without change is used.
+func (c *selectionContext) selectInOrder(utxos []*OwnedUTXO) (*Selection, error) {
+	var noChange *Selection
+	// starts without any utxo as MustInclude alone might cover the target
+	for n := 0; n <= len(utxos); n++ {
+		selection, ok := c.newSelection(utxos[:n], true)
+		if !ok {
+			continue
+		}
+		if selection.Change > 0 {
+			return selection, nil
+		}
+		if noChange == nil {
+			noChange = selection
+		}
+	}
+
+	if noChange != nil {
+		logging.L.Debug().Msg("fallback to no-change coinselector")
+		return noChange, nil
+	}
+
+	return nil, ErrInsufficientFunds
+}
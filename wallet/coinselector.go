@@ -1,48 +1,18 @@
 package wallet
 
-/*
-Simplified as we don't expect to produce transactions with more than 252 inputs/outputs.
-Witness data is also very standardised.
-*/
-
 import (
-	"errors"
 	"fmt"
-	"math"
 
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/txscript"
-	"github.com/btcsuite/btcd/wire"
 	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/blindbit-lib/types"
 	"github.com/setavenger/go-bip352"
 )
 
-// Length in bytes without witness discount
-
-// See here for explanation of vByte sizes https://bitcoinops.org/en/tools/calc-size/
-const (
-	NTxVersionLen                   float64 = 4
-	SegWitMarkerLenAndSegWitFlagLen float64 = 0.5
-	NumInputsLen                    float64 = 1 // todo is a varInt
-
-	TrOutpointTxidLen      float64 = 32
-	TrOutpointVoutLen      float64 = 4
-	TrOutpointSequenceLen  float64 = 4
-	TrEmptyRedeemScriptLen float64 = 1
-	TrInputOutpointLen     float64 = TrOutpointTxidLen + TrOutpointVoutLen + TrEmptyRedeemScriptLen + TrOutpointSequenceLen
-
-	// TrWitnessDataLen already discounted by 0.25 complete length (varInt + actual data)
-	TrWitnessDataLen float64 = 16.25
-
-	OutputValueLen float64 = 8
-
-	WitnessCountLen float64 = 1 // todo is a varInt
-
-	NLockTimeLen float64 = 4
-
-	ScriptPubKeyTaprootLen = 34
-)
+// ScriptPubKeyTaprootLen is the length of a taproot output script, silent payment outputs are always taproot
+const ScriptPubKeyTaprootLen = 34
 
 // Errors
 var (
@@ -81,10 +51,13 @@ func (r *RecipientImpl) GetPkScript() []byte {
 
 // FeeRateCoinSelector
 // Custom CoinSelector implementation. Selects according to a given fee rate. Focused on taproot-only inputs.
+// UTXOs are added in the order they are given until the target is reached.
 // Needs the OwnedUTXOs to contain at least the Amount of the UTXO.
 // The function will fail if not enough value could be added together.
 // Other data in the OwnedUTXOs is preserved.
 // At the moment it is always assumed that we receive a taproot input.
+//
+// The fields are only used by CoinSelect, SelectCoins takes everything from the SelectionRequest.
 type FeeRateCoinSelector struct {
 	OwnedUTXOs      []*OwnedUTXO
 	MinChangeAmount uint64
@@ -105,225 +78,80 @@ func NewFeeRateCoinSelector(
 		ChainParams:     chainParams,
 	}
 }
-func (s *FeeRateCoinSelector) CoinSelect(
-	feeRate uint32,
-) (
-	[]*OwnedUTXO, uint64, error,
-) {
-	// todo: merge the two functions should be possible to unify logic
-	// reduces reduncant code and complexity
-	// less places to edit in case of change
-	ownedUTXOs, change, err := s.coinSelectWithChange(feeRate)
-	if err != nil && errors.Is(err, ErrInsufficientFunds) {
-		logging.L.Debug().Msg("fallback to no-change coinselector")
-		ownedUTXOs, change, err = s.coinselectNoChange(feeRate)
-	}
-
-	return ownedUTXOs, change, err
-}
 
 // CoinSelect
 // returns the utxos to select and the change amount in order to achieve the desired fee rate.
-// NOTE: A change amount is always added.
-// todo don't require a change amount and just increase fee if difference is below a certain threshold
-func (s *FeeRateCoinSelector) coinSelectWithChange(
-	feeRate uint32,
+// feeRate used to be a number in sat/vB, convert such rates with types.FeeRateFromSatPerVByte.
+func (s *FeeRateCoinSelector) CoinSelect(
+	feeRate types.FeeRate,
 ) (
 	[]*OwnedUTXO, uint64, error,
 ) {
-	// todo should we somehow expose the resulting vBytes for later analysis?
-	// todo reduce complexity in this function
-	if feeRate < 1 {
-		return nil, 0, ErrInvalidFeeRate
-	}
-	// track vBytes of the transaction
-	var vByte float64 // todo make sure we don't face any decimal imprecision
-
-	// OVERHEAD will always be there
-	vByte += NTxVersionLen + SegWitMarkerLenAndSegWitFlagLen + NLockTimeLen
-	vByte += NumInputsLen
-
-	//
-	outputLens, err := extractPkScriptsFromRecipients(s.Recipients, s.ChainParams)
+	selection, err := s.SelectCoins(&SelectionRequest{
+		UTXOs:           s.OwnedUTXOs,
+		Recipients:      s.Recipients,
+		FeeRate:         feeRate,
+		MinChangeAmount: s.MinChangeAmount,
+		ChainParams:     s.ChainParams,
+	})
 	if err != nil {
-		logging.L.Err(err).Any("recipients", s.Recipients).Msg("Error extracting pkScripts")
 		return nil, 0, err
 	}
-
-	vByte += float64(wire.VarIntSerializeSize(uint64(len(outputLens))))
-
-	// END OVERHEAD should be 10.5 vByte here
-
-	// add outputs to vByte
-	for _, scriptPubKeyLen := range outputLens {
-		vByte += OutputValueLen + float64(wire.VarIntSerializeSize(uint64(scriptPubKeyLen))) + float64(scriptPubKeyLen)
-	}
-
-	var sumTargetAmount uint64
-	for _, recipient := range s.Recipients {
-		if recipient.GetAmount() > 0 {
-			sumTargetAmount += recipient.GetAmount()
-		} else {
-			return nil, 0, ErrRecipientAmountIsZero
-		}
-	}
-
-	var selectedInputs []*OwnedUTXO
-	var sumSelectedInputsAmounts uint64
-	//var potentialVBytes = vByte // tracks a potential increase before actually adding to the main vByte tracking
-
-	// moved up as anyways always needed
-	vByte += WitnessCountLen / 4
-	// always add change
-	// todo don't do that
-	vByte += OutputValueLen + float64(wire.VarIntSerializeSize(uint64(ScriptPubKeyTaprootLen))) + float64(ScriptPubKeyTaprootLen)
-
-	for idx := range s.OwnedUTXOs {
-		utxo := s.OwnedUTXOs[idx]
-		if utxo.State == StateSpent {
-			continue
-		}
-		// we check that the sum of selected input amounts exceeds the (target Value + fees + (min. change))
-		selectedInputs = append(selectedInputs, utxo)
-		sumSelectedInputsAmounts += utxo.Amount
-
-		// outpoint size
-		vByte += TrInputOutpointLen
-		vByte += TrWitnessDataLen
-
-		// todo also check that the fee rate is as we want it
-		if sumSelectedInputsAmounts > sumTargetAmount+NeededFeeAbsolutSats(vByte, feeRate) {
-			if sumSelectedInputsAmounts-(sumTargetAmount+NeededFeeAbsolutSats(vByte, feeRate)) < s.MinChangeAmount {
-				continue
-			}
-			// todo account that change was considered in the vByte tx size
-			return selectedInputs, sumSelectedInputsAmounts - (sumTargetAmount + NeededFeeAbsolutSats(vByte, feeRate)), err
-		}
-	}
-
-	return nil, 0, ErrInsufficientFunds
+	return selection.UTXOs, selection.Change, nil
 }
 
-func (s *FeeRateCoinSelector) coinselectNoChange(
-	feeRate uint32,
-) (
-	[]*OwnedUTXO, uint64, error,
-) {
-	// todo should we somehow expose the resulting vBytes for later analysis?
-	// todo reduce complexity in this function
-	if feeRate < 1 {
-		return nil, 0, ErrInvalidFeeRate
-	}
-	// track vBytes of the transaction
-	var vByte float64 // todo make sure we don't face any decimal imprecision
-
-	// OVERHEAD will always be there
-	vByte += NTxVersionLen + SegWitMarkerLenAndSegWitFlagLen + NLockTimeLen
-	vByte += NumInputsLen
-
-	//
-	outputLens, err := extractPkScriptsFromRecipients(s.Recipients, s.ChainParams)
+func (s *FeeRateCoinSelector) SelectCoins(req *SelectionRequest) (*Selection, error) {
+	ctx, err := newSelectionContext(req)
 	if err != nil {
-		logging.L.Err(err).Any("recipients", s.Recipients).Msg("Error extracting pkScripts")
-		return nil, 0, err
-	}
-
-	vByte += float64(wire.VarIntSerializeSize(uint64(len(outputLens))))
-
-	// END OVERHEAD should be 10.5 vByte here
-
-	// add outputs to vByte
-	for _, scriptPubKeyLen := range outputLens {
-		vByte += OutputValueLen + float64(wire.VarIntSerializeSize(uint64(scriptPubKeyLen))) + float64(scriptPubKeyLen)
+		return nil, err
 	}
-
-	var sumTargetAmount uint64
-	for _, recipient := range s.Recipients {
-		if recipient.GetAmount() > 0 {
-			sumTargetAmount += recipient.GetAmount()
-		} else {
-			return nil, 0, ErrRecipientAmountIsZero
-		}
-	}
-
-	var selectedInputs []*OwnedUTXO
-	var sumSelectedInputsAmounts uint64
-
-	// todo: add note what this quarter byte is
-	vByte += WitnessCountLen / 4
-	// always add change other function tries without
-	vByte += OutputValueLen + float64(wire.VarIntSerializeSize(uint64(ScriptPubKeyTaprootLen))) + float64(ScriptPubKeyTaprootLen)
-
-	for idx := range s.OwnedUTXOs {
-		utxo := s.OwnedUTXOs[idx]
-		if utxo.State == StateSpent {
-			continue
-		}
-
-		// we check that the sum of selected input amounts exceeds the (target Value + fees + (min. change))
-		selectedInputs = append(selectedInputs, utxo)
-		sumSelectedInputsAmounts += utxo.Amount
-
-		// outpoint size
-		vByte += TrInputOutpointLen
-		vByte += TrWitnessDataLen
-
-		// todo also check that the fee rate is as we want it
-		if sumSelectedInputsAmounts > sumTargetAmount+NeededFeeAbsolutSats(vByte, feeRate) {
-
-			// if we inlcude dust as mining fee.
-			// we end up below the minimum change amount but the utxos are still selected
-			// so we end up paying more in fees
-			// -- continue comment --
-			// if the min change amount is not hit we set to zero
-			changeAmount := sumSelectedInputsAmounts - (sumTargetAmount + NeededFeeAbsolutSats(vByte, feeRate))
-			if changeAmount < s.MinChangeAmount {
-				changeAmount = 0
-			}
-			return selectedInputs, changeAmount, err
-		}
-	}
-	return nil, 0, ErrInsufficientFunds
+	return ctx.selectInOrder(ctx.candidates())
 }
 
+// taprootPlaceholderScript stands in for outputs which are not known yet (silent payment outputs and change)
+var taprootPlaceholderScript = append([]byte{0x51, 0x20}, make([]byte, ScriptPubKeyTaprootLen-2)...)
+
+// extractPkScriptsFromRecipients returns the output scripts of the recipients.
+// Silent payment recipients get a placeholder as their output is not known before the inputs are.
 func extractPkScriptsFromRecipients(
 	recipients []Recipient,
 	chainParams *chaincfg.Params,
 ) (
-	[]int, error,
+	[][]byte, error,
 ) {
-	var pkScriptLens []int
+	var pkScripts [][]byte
 
 	for _, recipient := range recipients {
 		if bip352.IsSilentPaymentAddress(recipient.GetAddress()) {
-			// just take length for a taproot output as it always will be
-			pkScriptLens = append(pkScriptLens, ScriptPubKeyTaprootLen)
+			// just take a taproot output as it always will be
+			pkScripts = append(pkScripts, taprootPlaceholderScript)
 			continue
 		}
 		if recipient.GetPkScript() != nil && len(recipient.GetPkScript()) > 0 {
 			// skip if a pkScript is already present (for what ever reason)
-			pkScriptLens = append(pkScriptLens, len(recipient.GetPkScript()))
+			pkScripts = append(pkScripts, recipient.GetPkScript())
 			continue
 		}
 
 		// do this for all non SP addresses
 		address, err := btcutil.DecodeAddress(recipient.GetAddress(), chainParams)
 		if err != nil {
-			fmt.Printf("recipientAddress: %v\n", recipient.GetAddress())
-			fmt.Printf("Failed to decode address: %v\n", err)
-			return nil, err
+			logging.L.Err(err).Str("address", recipient.GetAddress()).Msg("failed to decode address")
+			return nil, fmt.Errorf("invalid recipient address %s: %w", recipient.GetAddress(), err)
 		}
 		scriptPubKey, err := txscript.PayToAddrScript(address)
 		if err != nil {
-			fmt.Printf("Failed to create scriptPubKey: %v\n", err)
+			logging.L.Err(err).Str("address", recipient.GetAddress()).Msg("failed to create script pub key")
 			return nil, err
 		}
-		pkScriptLens = append(pkScriptLens, len(scriptPubKey))
+		pkScripts = append(pkScripts, scriptPubKey)
 	}
 
-	return pkScriptLens, nil
+	return pkScripts, nil
 }
 
-func NeededFeeAbsolutSats(vByte float64, feeRate uint32) uint64 {
-	return uint64(math.Ceil(vByte * float64(feeRate)))
+// NeededFeeAbsolutSats returns the fee in sats for vSize vBytes at feeRate, rounded up.
+// feeRate used to be a number in sat/vB, convert such rates with types.FeeRateFromSatPerVByte.
+func NeededFeeAbsolutSats(vSize int64, feeRate types.FeeRate) uint64 {
+	return feeRate.FeeForVSize(vSize)
 }
@@ -0,0 +1,132 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/blindbit-lib/types"
+)
+
+// MinRelayFeeRate is the lowest fee rate nodes relay transactions with (Bitcoin Core default)
+var MinRelayFeeRate = types.FeeRateFromSatPerKvB(1000)
+
+var (
+	ErrNotUnconfirmed = errors.New("utxo is not unconfirmed")
+	ErrCPFPNotNeeded  = errors.New("parent transaction already pays the target fee rate")
+)
+
+// CPFPChildFee returns the fee the child has to pay, so that parent and child together
+// reach feeRate. The child always pays at least MinRelayFeeRate for its own size.
+func CPFPChildFee(parentVSize int64, parentFee uint64, childVSize int64, feeRate types.FeeRate) uint64 {
+	packageFee := NeededFeeAbsolutSats(parentVSize+childVSize, feeRate)
+	childFee := NeededFeeAbsolutSats(childVSize, MinRelayFeeRate)
+	if packageFee > parentFee && packageFee-parentFee > childFee {
+		childFee = packageFee - parentFee
+	}
+	return childFee
+}
+
+// CreateCPFP creates a child transaction spending the unconfirmed utxo back to the change address,
+// which pays enough fees for the parent and child to reach feeRate as a package (child pays for parent).
+// parentVSize and parentFee describe the transaction which created utxo.
+// If the utxo can not pay for the child, unspent confirmed utxos are added.
+//
+// The child is recorded in Wallet.SentTransactions and becomes pending like a sent transaction:
+// its inputs are marked as StateUnconfirmedSpent and its change is tracked until it confirms.
+func (w *Wallet) CreateCPFP(
+	utxo *OwnedUTXO,
+	parentVSize int64,
+	parentFee uint64,
+	feeRate types.FeeRate,
+) (
+	[]byte, error,
+) {
+	if w.IsWatchOnly() {
+		return nil, ErrWatchOnly
+	}
+	if utxo.State != StateUnconfirmed {
+		return nil, fmt.Errorf("%w: state is %s", ErrNotUnconfirmed, utxo.State)
+	}
+	if utxo.Frozen {
+		return nil, ErrUTXOFrozen
+	}
+	if feeRate.Less(MinRelayFeeRate) {
+		return nil, ErrInvalidFeeRate
+	}
+	if parentFee >= NeededFeeAbsolutSats(parentVSize, feeRate) {
+		return nil, ErrCPFPNotNeeded
+	}
+
+	chainParams, ok := types.NetworkParams[w.Network]
+	if !ok {
+		return nil, fmt.Errorf("unsupported network: %s", w.Network)
+	}
+
+	candidates := []*OwnedUTXO{utxo}
+	for _, u := range w.UTXOs {
+		if u.State != StateUnspent || u.Frozen {
+			continue
+		}
+		candidates = append(candidates, u)
+	}
+
+	// the child has a single output to the change address
+	estimator := NewWeightEstimator()
+	estimator.AddOutput(taprootPlaceholderScript)
+
+	minChange := w.DustPolicy().ChangeThreshold()
+	var selection *Selection
+	var sum uint64
+	for i, candidate := range candidates {
+		estimator.AddTaprootInput()
+		sum += candidate.Amount
+
+		childFee := CPFPChildFee(parentVSize, parentFee, estimator.VSize(), feeRate)
+		if sum < childFee+minChange {
+			continue
+		}
+		selection = &Selection{
+			UTXOs:  candidates[:i+1],
+			Change: sum - childFee,
+			Fee:    childFee,
+		}
+		break
+	}
+	if selection == nil {
+		return nil, ErrInsufficientFunds
+	}
+
+	proposal, err := w.newProposal(nil, selection, feeRate, chainParams)
+	if err != nil {
+		return nil, err
+	}
+	// the fee is checked for the package of parent and child, see VerifyPackageFeeRate
+	proposal.parent = &cpfpParent{vSize: parentVSize, fee: parentFee}
+	finalTx, err := w.signProposal(proposal)
+	if err != nil {
+		return nil, err
+	}
+	childVSize := TxVSize(finalTx)
+
+	var buf bytes.Buffer
+	err = finalTx.Serialize(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	sent := w.recordSentTransaction(finalTx, nil, selection, feeRate)
+	err = w.markPending(sent, selection.UTXOs)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.L.Debug().
+		Int("inputs", len(selection.UTXOs)).
+		Uint64("child_fee", selection.Fee).
+		Int64("package_vsize", parentVSize+childVSize).
+		Msg("created cpfp child transaction")
+
+	return buf.Bytes(), nil
+}
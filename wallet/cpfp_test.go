@@ -0,0 +1,183 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/setavenger/blindbit-lib/types"
+)
+
+func TestCPFPChildFee(t *testing.T) {
+	tests := []struct {
+		name        string
+		parentVSize int64
+		parentFee   uint64
+		childVSize  int64
+		feeRate     types.FeeRate
+		want        uint64
+	}{
+		{"pays for parent", 200, 200, 100, types.FeeRateFromSatPerVByte(10), 2800},
+		{"parent pays nothing", 200, 0, 100, types.FeeRateFromSatPerVByte(5), 1500},
+		{"parent pays for the package", 200, 5000, 100, types.FeeRateFromSatPerVByte(10), 100},
+		{"child pays min relay fee", 200, 2950, 100, types.FeeRateFromSatPerVByte(10), 100},
+	}
+	for _, tc := range tests {
+		got := CPFPChildFee(tc.parentVSize, tc.parentFee, tc.childVSize, tc.feeRate)
+		if got != tc.want {
+			t.Errorf("%s: child fee %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+// sendLowFee sends amount from w at 1 sat/vB and returns the pending transaction with its unconfirmed change
+func sendLowFee(t *testing.T, w, recipient *Wallet, amount uint64) (*SentTransaction, *OwnedUTXO) {
+	t.Helper()
+	_, err := w.SendToRecipients(
+		[]Recipient{&RecipientImpl{Address: recipient.Address(), Amount: amount}},
+		w.UnspentUTXOs(), MinRelayFeeRate, w.DustPolicy().ChangeThreshold(), true, false,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := w.SentTransactions[len(w.SentTransactions)-1]
+	change := w.findOutPoint(sent.ChangeOutputs[0])
+	if change.State != StateUnconfirmed {
+		t.Fatalf("change state %s", change.State)
+	}
+	return sent, change
+}
+
+func TestCreateCPFP(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 100_000)
+	parent, change := sendLowFee(t, w, recipient, 30_000)
+	parentVSize := TxVSize(decodeTestTx(t, parent.RawTx))
+
+	feeRate := types.FeeRateFromSatPerVByte(20)
+	raw, err := w.CreateCPFP(change, parentVSize, parent.Fee, feeRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := decodeTestTx(t, raw)
+	if len(child.TxIn) != 1 || len(child.TxOut) != 1 {
+		t.Fatalf("%d inputs %d outputs", len(child.TxIn), len(child.TxOut))
+	}
+	if child.TxIn[0].PreviousOutPoint != change.OutPoint() {
+		t.Fatalf("child spends %s", child.TxIn[0].PreviousOutPoint)
+	}
+	_, prevOuts, sum := testPrevOuts(t, w, child)
+	verifyTestTx(t, child, prevOuts)
+
+	childVSize := TxVSize(child)
+	childFee := sum - sumTestOutputs(child)
+	if want := CPFPChildFee(parentVSize, parent.Fee, childVSize, feeRate); childFee != want {
+		t.Fatalf("child fee %d, want %d", childFee, want)
+	}
+	if packageFee, needed := parent.Fee+childFee, NeededFeeAbsolutSats(parentVSize+childVSize, feeRate); packageFee < needed {
+		t.Fatalf("package pays %d, needs %d", packageFee, needed)
+	}
+	if err = VerifyPackageFeeRate(child, sum, parentVSize, parent.Fee, feeRate, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// the child goes back to the change address of the wallet
+	if found := scanTestTx(t, w, w, child); len(found) != 1 || found[0].Label == nil || found[0].Label.M != 0 {
+		t.Fatalf("found %v", found)
+	}
+	sent := w.FindSentTransaction(testTxid(child))
+	if sent == nil || sent.Fee != childFee || sent.State != TxStatePending {
+		t.Fatalf("child record %+v", sent)
+	}
+
+	// the child is pending like a sent transaction
+	if change.State != StateUnconfirmedSpent {
+		t.Fatalf("parent output state %s", change.State)
+	}
+	if len(sent.ChangeOutputs) != 1 {
+		t.Fatalf("change of the child %v", sent.ChangeOutputs)
+	}
+	if childChange := w.findOutPoint(sent.ChangeOutputs[0]); childChange == nil || childChange.State != StateUnconfirmed {
+		t.Fatalf("change of the child %+v", childChange)
+	}
+	if _, err = w.CreateCPFP(change, parentVSize, parent.Fee, feeRate); !errors.Is(err, ErrNotUnconfirmed) {
+		t.Fatalf("parent output spent twice: %v", err)
+	}
+}
+
+func TestCreateCPFPAddsConfirmedInputs(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 50_000)
+	// the change is too small to pay for the parent
+	parent, change := sendLowFee(t, w, recipient, 48_000)
+	added := fundTestWallet(t, w, 2, 60_000)
+	parentVSize := TxVSize(decodeTestTx(t, parent.RawTx))
+
+	raw, err := w.CreateCPFP(change, parentVSize, parent.Fee, types.FeeRateFromSatPerVByte(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := decodeTestTx(t, raw)
+	if len(child.TxIn) != 2 {
+		t.Fatalf("%d inputs", len(child.TxIn))
+	}
+	_, prevOuts, sum := testPrevOuts(t, w, child)
+	verifyTestTx(t, child, prevOuts)
+	if err = VerifyPackageFeeRate(child, sum, parentVSize, parent.Fee, types.FeeRateFromSatPerVByte(20), 0); err != nil {
+		t.Fatal(err)
+	}
+	if change.State != StateUnconfirmedSpent || added.State != StateUnconfirmedSpent {
+		t.Fatalf("input states %s %s", change.State, added.State)
+	}
+}
+
+func TestCreateCPFPErrors(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	confirmed := fundTestWallet(t, w, 1, 100_000)
+	parent, change := sendLowFee(t, w, recipient, 30_000)
+	parentVSize := TxVSize(decodeTestTx(t, parent.RawTx))
+
+	if _, err := w.CreateCPFP(confirmed, parentVSize, parent.Fee, types.FeeRateFromSatPerVByte(20)); !errors.Is(err, ErrNotUnconfirmed) {
+		t.Errorf("confirmed utxo: %v", err)
+	}
+	if _, err := w.CreateCPFP(change, parentVSize, parent.Fee, MinRelayFeeRate); !errors.Is(err, ErrCPFPNotNeeded) {
+		t.Errorf("parent pays the fee rate: %v", err)
+	}
+	if _, err := w.CreateCPFP(change, parentVSize, parent.Fee, types.FeeRate{}); !errors.Is(err, ErrInvalidFeeRate) {
+		t.Errorf("zero fee rate: %v", err)
+	}
+	if _, err := w.CreateCPFP(change, parentVSize, parent.Fee, types.FeeRateFromSatPerVByte(10_000)); !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("fee rate above the funds: %v", err)
+	}
+	change.Frozen = true
+	if _, err := w.CreateCPFP(change, parentVSize, parent.Fee, types.FeeRateFromSatPerVByte(20)); !errors.Is(err, ErrUTXOFrozen) {
+		t.Errorf("frozen: %v", err)
+	}
+}
+
+func TestVerifyPackageFeeRate(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 100_000)
+	parent, change := sendLowFee(t, w, recipient, 30_000)
+	parentVSize := TxVSize(decodeTestTx(t, parent.RawTx))
+
+	raw, err := w.CreateCPFP(change, parentVSize, parent.Fee, types.FeeRateFromSatPerVByte(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := decodeTestTx(t, raw)
+	_, _, sum := testPrevOuts(t, w, child)
+
+	// checked on its own the child overpays, it also pays for the parent
+	if err = VerifyFeeRate(child, sum, types.FeeRateFromSatPerVByte(20), 0); !errors.Is(err, ErrFeeTooHigh) {
+		t.Fatalf("child alone: %v", err)
+	}
+	if err = VerifyPackageFeeRate(child, sum-1, parentVSize, parent.Fee, types.FeeRateFromSatPerVByte(20), 0); !errors.Is(err, ErrFeeTooLow) {
+		t.Fatalf("one sat less: %v", err)
+	}
+	if err = VerifyPackageFeeRate(child, sum+1, parentVSize, parent.Fee, types.FeeRateFromSatPerVByte(20), 0); !errors.Is(err, ErrFeeTooHigh) {
+		t.Fatalf("one sat more: %v", err)
+	}
+	if err = VerifyPackageFeeRate(child, sum+1, parentVSize, parent.Fee, types.FeeRateFromSatPerVByte(20), 1); err != nil {
+		t.Fatalf("allowed overpay: %v", err)
+	}
+}
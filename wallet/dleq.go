@@ -0,0 +1,244 @@
+package wallet
+
+/*
+BIP374 - Discrete Log Equality Proofs
+
+A DLEQ proof shows that C = a*B for the a behind A = a*G without revealing a.
+For silent payments A is the input public key, B the scan key of the recipient and C the ECDH share.
+With the proofs a party can check the ECDH shares of all other contributors before computing outputs.
+*/
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/setavenger/go-bip352"
+)
+
+const (
+	dleqTagAux       = "BIP0374/aux"
+	dleqTagNonce     = "BIP0374/nonce"
+	dleqTagChallenge = "BIP0374/challenge"
+)
+
+var (
+	ErrInvalidDLEQProof = errors.New("invalid dleq proof")
+	ErrMissingDLEQProof = errors.New("dleq proof missing for ecdh share")
+)
+
+// GenerateDLEQProof proves that C = a*B for A = a*G.
+// r is 32 bytes of auxiliary randomness, m an optional message the proof commits to (can be nil).
+// Returns the proof as e || s.
+func GenerateDLEQProof(a [32]byte, B [33]byte, r [32]byte, m []byte) ([64]byte, error) {
+	G := dleqGenerator()
+	return generateDLEQProof(a, B, r, &G, m)
+}
+
+// generateDLEQProof is GenerateDLEQProof for any generator G, BIP374 does not fix G
+func generateDLEQProof(a [32]byte, B [33]byte, r [32]byte, G *btcec.JacobianPoint, m []byte) ([64]byte, error) {
+	var aScalar btcec.ModNScalar
+	overflow := aScalar.SetBytes(&a)
+	if overflow != 0 || aScalar.IsZero() {
+		return [64]byte{}, errors.New("invalid secret key")
+	}
+
+	BPoint, err := parseJacobian(B)
+	if err != nil {
+		return [64]byte{}, err
+	}
+
+	var A, C btcec.JacobianPoint
+	btcec.ScalarMultNonConst(&aScalar, G, &A)
+	btcec.ScalarMultNonConst(&aScalar, &BPoint, &C)
+
+	// t = a xor hash_aux(r)
+	t := bip352.TaggedHash(dleqTagAux, r[:])
+	for i := range t {
+		t[i] ^= a[i]
+	}
+
+	var nonceData bytes.Buffer
+	nonceData.Write(t[:])
+	nonceData.Write(serializeJacobian(&A))
+	nonceData.Write(serializeJacobian(&C))
+	nonceData.Write(m)
+	nonceHash := bip352.TaggedHash(dleqTagNonce, nonceData.Bytes())
+
+	var k btcec.ModNScalar
+	k.SetBytes(&nonceHash)
+	if k.IsZero() {
+		return [64]byte{}, errors.New("invalid nonce")
+	}
+
+	var R1, R2 btcec.JacobianPoint
+	btcec.ScalarMultNonConst(&k, G, &R1)
+	btcec.ScalarMultNonConst(&k, &BPoint, &R2)
+
+	e := dleqChallenge(&A, &BPoint, &C, G, &R1, &R2, m)
+
+	// s = k + e*a
+	var eScalar, s btcec.ModNScalar
+	eScalar.SetBytes(&e)
+	s.Mul2(&eScalar, &aScalar).Add(&k)
+
+	var proof [64]byte
+	copy(proof[:32], e[:])
+	s.PutBytesUnchecked(proof[32:])
+
+	err = verifyDLEQProof(
+		[33]byte(serializeJacobian(&A)), B, [33]byte(serializeJacobian(&C)), proof, G, m,
+	)
+	if err != nil {
+		return [64]byte{}, err
+	}
+
+	return proof, nil
+}
+
+// VerifyDLEQProof checks that the discrete logarithm of A to the base G equals the one of C to the base B.
+// Returns ErrInvalidDLEQProof if the proof does not hold.
+func VerifyDLEQProof(A, B, C [33]byte, proof [64]byte, m []byte) error {
+	G := dleqGenerator()
+	return verifyDLEQProof(A, B, C, proof, &G, m)
+}
+
+// verifyDLEQProof is VerifyDLEQProof for any generator G
+func verifyDLEQProof(A, B, C [33]byte, proof [64]byte, G *btcec.JacobianPoint, m []byte) error {
+	APoint, err := parseJacobian(A)
+	if err != nil {
+		return err
+	}
+	BPoint, err := parseJacobian(B)
+	if err != nil {
+		return err
+	}
+	CPoint, err := parseJacobian(C)
+	if err != nil {
+		return err
+	}
+
+	var e, s btcec.ModNScalar
+	e.SetByteSlice(proof[:32])
+	if overflow := s.SetByteSlice(proof[32:]); overflow {
+		return ErrInvalidDLEQProof
+	}
+
+	// R1 = s*G - e*A
+	var R1 btcec.JacobianPoint
+	subScalarMult(&s, G, &e, &APoint, &R1)
+	// R2 = s*B - e*C
+	var R2 btcec.JacobianPoint
+	subScalarMult(&s, &BPoint, &e, &CPoint, &R2)
+
+	if isInfinity(&R1) || isInfinity(&R2) {
+		return ErrInvalidDLEQProof
+	}
+
+	challenge := dleqChallenge(&APoint, &BPoint, &CPoint, G, &R1, &R2, m)
+	if !bytes.Equal(challenge[:], proof[:32]) {
+		return ErrInvalidDLEQProof
+	}
+
+	return nil
+}
+
+// dleqChallenge = hash_challenge(A || B || C || G || R1 || R2 || m)
+func dleqChallenge(A, B, C, G, R1, R2 *btcec.JacobianPoint, m []byte) [32]byte {
+	var data bytes.Buffer
+	for _, point := range []*btcec.JacobianPoint{A, B, C, G, R1, R2} {
+		data.Write(serializeJacobian(point))
+	}
+	data.Write(m)
+	return bip352.TaggedHash(dleqTagChallenge, data.Bytes())
+}
+
+// dleqGenerator returns the secp256k1 generator, the G used for silent payments
+func dleqGenerator() btcec.JacobianPoint {
+	var G btcec.JacobianPoint
+	var one btcec.ModNScalar
+	one.SetInt(1)
+	btcec.ScalarBaseMultNonConst(&one, &G)
+	return G
+}
+
+// subScalarMult computes result = s*P - e*Q
+func subScalarMult(s *btcec.ModNScalar, P *btcec.JacobianPoint, e *btcec.ModNScalar, Q, result *btcec.JacobianPoint) {
+	var sP, eQ btcec.JacobianPoint
+	btcec.ScalarMultNonConst(s, P, &sP)
+
+	var negE btcec.ModNScalar
+	negE.NegateVal(e)
+	btcec.ScalarMultNonConst(&negE, Q, &eQ)
+
+	btcec.AddNonConst(&sP, &eQ, result)
+}
+
+func parseJacobian(pubKey [33]byte) (btcec.JacobianPoint, error) {
+	var point btcec.JacobianPoint
+	key, err := btcec.ParsePubKey(pubKey[:])
+	if err != nil {
+		return point, err
+	}
+	key.AsJacobian(&point)
+	return point, nil
+}
+
+func serializeJacobian(point *btcec.JacobianPoint) []byte {
+	affine := *point
+	affine.ToAffine()
+	return btcec.NewPublicKey(&affine.X, &affine.Y).SerializeCompressed()
+}
+
+func isInfinity(point *btcec.JacobianPoint) bool {
+	return (point.X.IsZero() && point.Y.IsZero()) || point.Z.IsZero()
+}
+
+// dleqAuxRand returns fresh auxiliary randomness for a proof
+func dleqAuxRand() ([32]byte, error) {
+	var r [32]byte
+	_, err := rand.Read(r[:])
+	return r, err
+}
+
+// EcdhShare is the contribution of one party (or input) to the shared secret with a silent payment recipient.
+// Share = a*B_scan for the input public key PubKey = a*G, Proof shows that both use the same a.
+type EcdhShare struct {
+	PubKey     [33]byte
+	ScanPubKey [33]byte
+	Share      [33]byte
+	Proof      [64]byte
+}
+
+// CreateEcdhShare computes the ECDH share of secretKey with scanPubKey and proves it.
+// secretKey has to be negated already if it belongs to a taproot output with an odd key (see bip352.SenderCreateOutputs).
+// The shares of all inputs summed up and multiplied with the input_hash give the shared secret.
+func CreateEcdhShare(secretKey [32]byte, scanPubKey [33]byte) (*EcdhShare, error) {
+	share, err := ecdhShare(scanPubKey, secretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := dleqAuxRand()
+	if err != nil {
+		return nil, err
+	}
+
+	proof, err := GenerateDLEQProof(secretKey, scanPubKey, r, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EcdhShare{
+		PubKey:     *bip352.PubKeyFromSecKey(&secretKey),
+		ScanPubKey: scanPubKey,
+		Share:      share,
+		Proof:      proof,
+	}, nil
+}
+
+// Verify checks the DLEQ proof of the share
+func (s *EcdhShare) Verify() error {
+	return VerifyDLEQProof(s.PubKey, s.ScanPubKey, s.Share, s.Proof, nil)
+}
@@ -0,0 +1,180 @@
+package wallet
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// testdata/bip374 holds the vectors in the layout of the BIP374 test vector files
+// test_vectors_generate_proof.csv and test_vectors_verify_proof.csv, points at infinity are "INFINITY".
+
+func readDLEQVectors(t *testing.T, name string) []map[string]string {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", "bip374", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) < 2 {
+		t.Fatalf("%s has no vectors", name)
+	}
+
+	vectors := make([]map[string]string, len(records)-1)
+	for i, record := range records[1:] {
+		vectors[i] = make(map[string]string, len(record))
+		for j, column := range records[0] {
+			vectors[i][column] = record[j]
+		}
+	}
+	return vectors
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// decodePoint returns false for the point at infinity, which has no 33 byte encoding
+func decodePoint(t *testing.T, s string) ([33]byte, bool) {
+	t.Helper()
+	if s == "INFINITY" {
+		return [33]byte{}, false
+	}
+	return [33]byte(decodeHex(t, s)), true
+}
+
+func decodeGenerator(t *testing.T, s string) *btcec.JacobianPoint {
+	t.Helper()
+	G, ok := decodePoint(t, s)
+	if !ok {
+		t.Fatal("generator at infinity")
+	}
+	point, err := parseJacobian(G)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &point
+}
+
+// decodeMessage returns nil for an empty message, the proof then commits to no message
+func decodeMessage(t *testing.T, s string) []byte {
+	t.Helper()
+	if s == "" {
+		return nil
+	}
+	return decodeHex(t, s)
+}
+
+func TestGenerateDLEQProofVectors(t *testing.T) {
+	for _, v := range readDLEQVectors(t, "test_vectors_generate_proof.csv") {
+		t.Run(v["index"]+" "+v["comment"], func(t *testing.T) {
+			G := decodeGenerator(t, v["point_G"])
+			a := [32]byte(decodeHex(t, v["scalar_a"]))
+			r := [32]byte(decodeHex(t, v["auxrand_r"]))
+			m := decodeMessage(t, v["message"])
+
+			B, ok := decodePoint(t, v["point_B"])
+			if !ok {
+				if v["result_proof"] != "INVALID" {
+					t.Fatal("vector with B at infinity has to be invalid")
+				}
+				return
+			}
+
+			proof, err := generateDLEQProof(a, B, r, G, m)
+			if v["result_proof"] == "INVALID" {
+				if err == nil {
+					t.Fatalf("expected an error, got proof %x", proof)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hex.EncodeToString(proof[:]) != v["result_proof"] {
+				t.Fatalf("got proof %x want %s", proof, v["result_proof"])
+			}
+		})
+	}
+}
+
+func TestVerifyDLEQProofVectors(t *testing.T) {
+	for _, v := range readDLEQVectors(t, "test_vectors_verify_proof.csv") {
+		t.Run(v["index"]+" "+v["comment"], func(t *testing.T) {
+			G := decodeGenerator(t, v["point_G"])
+			proof := [64]byte(decodeHex(t, v["proof"]))
+			m := decodeMessage(t, v["message"])
+
+			A, okA := decodePoint(t, v["point_A"])
+			B, okB := decodePoint(t, v["point_B"])
+			C, okC := decodePoint(t, v["point_C"])
+			if !okA || !okB || !okC {
+				if v["result_success"] != "FALSE" {
+					t.Fatal("vector with a point at infinity has to fail")
+				}
+				return
+			}
+
+			err := verifyDLEQProof(A, B, C, proof, G, m)
+			switch v["result_success"] {
+			case "TRUE":
+				if err != nil {
+					t.Fatal(err)
+				}
+			case "FALSE":
+				if err == nil {
+					t.Fatal("invalid proof verified")
+				}
+			default:
+				t.Fatalf("unknown result %s", v["result_success"])
+			}
+		})
+	}
+}
+
+func TestEcdhShare(t *testing.T) {
+	secretKey := [32]byte{1, 2, 3}
+	scanSecret := [32]byte{4, 5, 6}
+	_, scanPubKey := btcec.PrivKeyFromBytes(scanSecret[:])
+
+	share, err := CreateEcdhShare(secretKey, [33]byte(scanPubKey.SerializeCompressed()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = share.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a*B_scan == b_scan*A
+	_, pubKey := btcec.PrivKeyFromBytes(secretKey[:])
+	var scanScalar btcec.ModNScalar
+	scanScalar.SetBytes(&scanSecret)
+	var A, expected btcec.JacobianPoint
+	pubKey.AsJacobian(&A)
+	btcec.ScalarMultNonConst(&scanScalar, &A, &expected)
+	if hex.EncodeToString(serializeJacobian(&expected)) != hex.EncodeToString(share.Share[:]) {
+		t.Fatalf("share %x is not the ecdh of the keys", share.Share)
+	}
+
+	// the proof does not hold for another share
+	forged := *share
+	forged.Share = forged.PubKey
+	if err = forged.Verify(); !errors.Is(err, ErrInvalidDLEQProof) {
+		t.Fatalf("forged share: got %v want %v", err, ErrInvalidDLEQProof)
+	}
+}
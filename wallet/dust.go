@@ -0,0 +1,150 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/setavenger/blindbit-lib/types"
+)
+
+// DefaultDustRelayFeeRate is the default -dustrelayfee of Bitcoin Core
+var DefaultDustRelayFeeRate = types.FeeRateFromSatPerKvB(3000)
+
+// Sizes of the input spending an output, as assumed by Bitcoin Core for the dust threshold
+const (
+	// outpoint, script length, signature and public key in the scriptSig, sequence
+	dustInputSize = 32 + 4 + 1 + 107 + 4
+	// the signature and public key are moved to the witness
+	dustWitnessInputSize = 32 + 4 + 1 + 107/blockchain.WitnessScaleFactor + 4
+)
+
+var ErrDust = errors.New("amount is below the dust threshold")
+
+// DustError is returned for outputs which would be dust, it wraps ErrDust
+type DustError struct {
+	Address   string
+	Amount    uint64
+	Threshold uint64
+}
+
+func (e *DustError) Error() string {
+	return fmt.Sprintf("%s: %s receives %d needs at least %d", ErrDust, e.Address, e.Amount, e.Threshold)
+}
+
+func (e *DustError) Unwrap() error {
+	return ErrDust
+}
+
+// OutputType is the script type of an output
+type OutputType int8
+
+const (
+	OutputP2PKH OutputType = iota + 1
+	OutputP2SH
+	OutputP2WPKH
+	OutputP2WSH
+	OutputP2TR
+)
+
+var outputTypeNames = [...]string{"p2pkh", "p2sh", "p2wpkh", "p2wsh", "p2tr"}
+
+// outputTypeScriptLens are the lengths of the scriptPubKeys
+var outputTypeScriptLens = [...]int{25, 23, 22, 34, ScriptPubKeyTaprootLen}
+
+func (t OutputType) String() string {
+	if t < OutputP2PKH || int(t) > len(outputTypeNames) {
+		return fmt.Sprintf("unknown(%d)", t)
+	}
+	return outputTypeNames[t-1]
+}
+
+// isWitness returns true for segwit outputs, their inputs are cheaper to spend
+func (t OutputType) isWitness() bool {
+	return t == OutputP2WPKH || t == OutputP2WSH || t == OutputP2TR
+}
+
+// DustPolicy decides which outputs are too small to be relayed.
+// An output is dust if it is worth less than the fee for creating and later spending it at RelayFeeRate.
+// With DefaultDustRelayFeeRate this gives the thresholds of Bitcoin Core:
+// P2PKH 546, P2SH 540, P2WPKH 294, P2WSH 330 and P2TR 330 sats.
+type DustPolicy struct {
+	// RelayFeeRate defaults to DefaultDustRelayFeeRate
+	RelayFeeRate types.FeeRate
+}
+
+func (p DustPolicy) relayFeeRate() types.FeeRate {
+	if p.RelayFeeRate.IsZero() {
+		return DefaultDustRelayFeeRate
+	}
+	return p.RelayFeeRate
+}
+
+// TypeThreshold returns the smallest amount an output of type t needs to not be dust
+func (p DustPolicy) TypeThreshold(t OutputType) uint64 {
+	if t < OutputP2PKH || int(t) > len(outputTypeScriptLens) {
+		return 0
+	}
+	return p.threshold(outputTypeScriptLens[t-1], t.isWitness())
+}
+
+// Threshold returns the smallest amount an output with pkScript needs to not be dust.
+// Works for any script, unspendable outputs (OP_RETURN) are never dust.
+func (p DustPolicy) Threshold(pkScript []byte) uint64 {
+	if txscript.IsUnspendable(pkScript) {
+		return 0
+	}
+	return p.threshold(len(pkScript), txscript.IsWitnessProgram(pkScript))
+}
+
+// ChangeThreshold is the threshold for change outputs, which are always taproot.
+// Change below it is added to the fee.
+func (p DustPolicy) ChangeThreshold() uint64 {
+	return p.TypeThreshold(OutputP2TR)
+}
+
+// IsDust returns true if an output of amount to pkScript would be dust
+func (p DustPolicy) IsDust(amount uint64, pkScript []byte) bool {
+	return amount < p.Threshold(pkScript)
+}
+
+// CheckRecipients fails with a DustError for the first recipient receiving dust.
+// Silent payment recipients are checked as taproot outputs.
+func (p DustPolicy) CheckRecipients(recipients []Recipient, chainParams *chaincfg.Params) error {
+	pkScripts, err := extractPkScriptsFromRecipients(recipients, chainParams)
+	if err != nil {
+		return err
+	}
+	for i, recipient := range recipients {
+		if recipient.GetAmount() == 0 {
+			return ErrRecipientAmountIsZero
+		}
+		threshold := p.Threshold(pkScripts[i])
+		if recipient.GetAmount() < threshold {
+			return &DustError{
+				Address:   recipient.GetAddress(),
+				Amount:    recipient.GetAmount(),
+				Threshold: threshold,
+			}
+		}
+	}
+	return nil
+}
+
+func (p DustPolicy) threshold(scriptLen int, witness bool) uint64 {
+	size := 8 + wire.VarIntSerializeSize(uint64(scriptLen)) + scriptLen
+	if witness {
+		size += dustWitnessInputSize
+	} else {
+		size += dustInputSize
+	}
+	return p.relayFeeRate().FeeForVSize(int64(size))
+}
+
+// DustPolicy returns the dust policy of the wallet, see Wallet.DustRelayFeeRate
+func (w *Wallet) DustPolicy() DustPolicy {
+	return DustPolicy{RelayFeeRate: w.DustRelayFeeRate}
+}
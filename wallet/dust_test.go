@@ -0,0 +1,115 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/setavenger/blindbit-lib/types"
+)
+
+// The default thresholds are the ones of Bitcoin Core
+func TestDustPolicyTypeThreshold(t *testing.T) {
+	var policy DustPolicy
+	for outputType, want := range map[OutputType]uint64{
+		OutputP2PKH:  546,
+		OutputP2SH:   540,
+		OutputP2WPKH: 294,
+		OutputP2WSH:  330,
+		OutputP2TR:   330,
+	} {
+		if threshold := policy.TypeThreshold(outputType); threshold != want {
+			t.Errorf("%s: %d, want %d", outputType, threshold, want)
+		}
+	}
+	if threshold := policy.TypeThreshold(0); threshold != 0 {
+		t.Errorf("unknown type: %d", threshold)
+	}
+	if policy.ChangeThreshold() != 330 {
+		t.Errorf("change threshold %d", policy.ChangeThreshold())
+	}
+
+	// the thresholds scale with the relay fee rate
+	if threshold := (DustPolicy{RelayFeeRate: types.FeeRateFromSatPerVByte(1)}).TypeThreshold(OutputP2TR); threshold != 110 {
+		t.Errorf("p2tr at 1 sat/vB: %d", threshold)
+	}
+}
+
+func TestDustPolicyThreshold(t *testing.T) {
+	var policy DustPolicy
+	p2wpkh := append([]byte{0x00, 0x14}, make([]byte, 20)...)
+	if threshold := policy.Threshold(p2wpkh); threshold != 294 {
+		t.Fatalf("p2wpkh script: %d", threshold)
+	}
+	if !policy.IsDust(293, p2wpkh) || policy.IsDust(294, p2wpkh) {
+		t.Fatal("dust check does not match the threshold")
+	}
+
+	opReturn, err := txscript.NullDataScript([]byte("blindbit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.IsDust(0, opReturn) {
+		t.Fatal("op_return output is dust")
+	}
+}
+
+func TestDustPolicyCheckRecipients(t *testing.T) {
+	var policy DustPolicy
+	recipient := newTestWallet(t, 2)
+	address, err := btcutil.NewAddressWitnessPubKeyHash(make([]byte, 20), &chaincfg.SigNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = policy.CheckRecipients([]Recipient{
+		&RecipientImpl{Address: recipient.Address(), Amount: 330},
+		&RecipientImpl{Address: address.EncodeAddress(), Amount: 294},
+	}, &chaincfg.SigNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// silent payment recipients are taproot outputs
+	err = policy.CheckRecipients([]Recipient{
+		&RecipientImpl{Address: address.EncodeAddress(), Amount: 294},
+		&RecipientImpl{Address: recipient.Address(), Amount: 329},
+	}, &chaincfg.SigNetParams)
+	var dustErr *DustError
+	if !errors.As(err, &dustErr) || !errors.Is(err, ErrDust) {
+		t.Fatalf("error %v, want DustError", err)
+	}
+	if dustErr.Address != recipient.Address() || dustErr.Amount != 329 || dustErr.Threshold != 330 {
+		t.Fatalf("dust error %+v", dustErr)
+	}
+
+	err = policy.CheckRecipients([]Recipient{&RecipientImpl{Address: recipient.Address()}}, &chaincfg.SigNetParams)
+	if !errors.Is(err, ErrRecipientAmountIsZero) {
+		t.Fatalf("zero amount: %v", err)
+	}
+}
+
+func TestSendRejectsDust(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 50_000)
+
+	_, err := w.ProposeTransaction(
+		[]Recipient{&RecipientImpl{Address: recipient.Address(), Amount: 300}},
+		w.UnspentUTXOs(), types.FeeRateFromSatPerVByte(2), w.DustPolicy().ChangeThreshold(),
+	)
+	if !errors.Is(err, ErrDust) {
+		t.Fatalf("error %v, want ErrDust", err)
+	}
+
+	// a lower relay fee rate accepts it
+	w.DustRelayFeeRate = types.FeeRateFromSatPerVByte(1)
+	_, err = w.ProposeTransaction(
+		[]Recipient{&RecipientImpl{Address: recipient.Address(), Amount: 300}},
+		w.UnspentUTXOs(), types.FeeRateFromSatPerVByte(2), w.DustPolicy().ChangeThreshold(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,203 @@
+package wallet
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/rs/zerolog"
+	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/blindbit-lib/types"
+	"github.com/setavenger/go-bip352"
+)
+
+func TestMain(m *testing.M) {
+	logging.SetLogLevel(zerolog.Disabled)
+	os.Exit(m.Run())
+}
+
+// newTestWallet creates a signet wallet with keys derived from seed
+func newTestWallet(t *testing.T, seed byte) *Wallet {
+	t.Helper()
+	w, err := NewWallet([32]byte{seed, 1}, [32]byte{seed, 2}, types.NetworkSignet, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// fundTestWallet pays amount to the address of w from a taproot input of a sender derived from seed,
+// scans the output like the scanner would and adds it to the wallet confirmed at height 100.
+func fundTestWallet(t *testing.T, w *Wallet, seed byte, amount uint64) *OwnedUTXO {
+	t.Helper()
+	return fundTestWalletAddress(t, w, w.Address(), seed, amount)
+}
+
+// fundTestWalletAddress is fundTestWallet for a labelled address of w
+func fundTestWalletAddress(t *testing.T, w *Wallet, address string, seed byte, amount uint64) *OwnedUTXO {
+	t.Helper()
+	senderSecret := [32]byte{seed, 0xaa}
+	senderPubKey := bip352.PubKeyFromSecKey(&senderSecret)
+	vin := &bip352.Vin{
+		Txid:         [32]byte{seed},
+		Vout:         0,
+		SecretKey:    &senderSecret,
+		Taproot:      true,
+		ScriptPubKey: append([]byte{txscript.OP_1, txscript.OP_DATA_32}, senderPubKey[1:]...),
+	}
+
+	recipients := []*bip352.Recipient{{SilentPaymentAddress: address, Amount: amount}}
+	err := bip352.SenderCreateOutputs(recipients, []*bip352.Vin{vin}, w.Network == types.NetworkMainnet, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scanVin := &bip352.Vin{Txid: vin.Txid, Vout: vin.Vout, ScriptPubKey: vin.ScriptPubKey, Taproot: true}
+	found := scanTestOutputs(t, w, []*bip352.Vin{scanVin}, [][32]byte{recipients[0].Output})
+	if len(found) != 1 {
+		t.Fatalf("wallet found %d outputs", len(found))
+	}
+
+	utxo := &OwnedUTXO{
+		Txid:         [32]byte{seed, 0xbb},
+		Vout:         1,
+		Height:       100,
+		Amount:       amount,
+		PrivKeyTweak: found[0].SecKeyTweak,
+		PubKey:       found[0].Output,
+		Timestamp:    1700000000 + uint64(seed),
+		State:        StateUnspent,
+		Label:        found[0].Label,
+	}
+	_, err = w.AddUTXOs(utxo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return utxo
+}
+
+// scanTestOutputs scans the taproot outputs of a transaction with the inputs vins for w
+func scanTestOutputs(t *testing.T, w *Wallet, vins []*bip352.Vin, outputs [][32]byte) []*bip352.FoundOutput {
+	t.Helper()
+	pubKeys := make([][33]byte, len(vins))
+	for i, vin := range vins {
+		pubKeys[i][0] = 0x02
+		copy(pubKeys[i][1:], vin.ScriptPubKey[2:])
+	}
+	publicKeySum, err := bip352.SumPublicKeys(pubKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputHash, err := bip352.ComputeInputHash(vins, publicKeySum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels, err := w.ScanLabels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := bip352.ReceiverScanTransaction(
+		w.SecretKeyScan.ToArray(), w.PubKeySpend.ToArrayPtr(), labels, outputs, publicKeySum, inputHash,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+// scanTestTx scans tx, spending utxos of from, for w
+func scanTestTx(t *testing.T, w, from *Wallet, tx *wire.MsgTx) []*bip352.FoundOutput {
+	t.Helper()
+	vins, _, _ := testPrevOuts(t, from, tx)
+	var outputs [][32]byte
+	for _, txOut := range tx.TxOut {
+		if bip352.IsP2TR(txOut.PkScript) {
+			outputs = append(outputs, [32]byte(txOut.PkScript[2:]))
+		}
+	}
+	return scanTestOutputs(t, w, vins, outputs)
+}
+
+func decodeTestTx(t *testing.T, raw []byte) *wire.MsgTx {
+	t.Helper()
+	var tx wire.MsgTx
+	err := tx.Deserialize(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tx
+}
+
+// testPrevOuts looks up the inputs of tx in w, they all have to belong to w.
+// Returns the inputs as vins, the spent outputs and the input sum.
+func testPrevOuts(t *testing.T, w *Wallet, tx *wire.MsgTx) ([]*bip352.Vin, map[wire.OutPoint]*wire.TxOut, uint64) {
+	t.Helper()
+	vins := make([]*bip352.Vin, len(tx.TxIn))
+	prevOuts := make(map[wire.OutPoint]*wire.TxOut, len(tx.TxIn))
+	var sum uint64
+	for i, txIn := range tx.TxIn {
+		utxo := w.findOutPoint(txIn.PreviousOutPoint)
+		if utxo == nil {
+			t.Fatalf("input %s is not in the wallet", txIn.PreviousOutPoint)
+		}
+		vin := ConvertOwnedUTXOIntoVin(utxo)
+		vins[i] = &vin
+		prevOuts[txIn.PreviousOutPoint] = wire.NewTxOut(int64(utxo.Amount), vin.ScriptPubKey)
+		sum += utxo.Amount
+	}
+	return vins, prevOuts, sum
+}
+
+// verifyTestTx runs the scripts of all inputs of tx
+func verifyTestTx(t *testing.T, tx *wire.MsgTx, prevOuts map[wire.OutPoint]*wire.TxOut) {
+	t.Helper()
+	fetcher := txscript.NewMultiPrevOutFetcher(prevOuts)
+	sigHashes := txscript.NewTxSigHashes(tx, fetcher)
+	for i, txIn := range tx.TxIn {
+		prevOut := prevOuts[txIn.PreviousOutPoint]
+		engine, err := txscript.NewEngine(
+			prevOut.PkScript, tx, i, txscript.StandardVerifyFlags, nil, sigHashes, prevOut.Value, fetcher,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = engine.Execute(); err != nil {
+			t.Fatalf("input %d: %v", i, err)
+		}
+	}
+}
+
+func sumTestOutputs(tx *wire.MsgTx) uint64 {
+	var sum uint64
+	for _, txOut := range tx.TxOut {
+		sum += uint64(txOut.Value)
+	}
+	return sum
+}
+
+// testTxid returns the txid of tx in the byte order of OwnedUTXO.Txid
+func testTxid(tx *wire.MsgTx) [32]byte {
+	txHash := tx.TxHash()
+	return [32]byte(bip352.ReverseBytesCopy(txHash[:]))
+}
+
+// testOutPoint builds an outpoint from a txid in the byte order of OwnedUTXO.Txid
+func testOutPoint(txid [32]byte, vout uint32) wire.OutPoint {
+	return wire.OutPoint{Hash: chainhash.Hash(bip352.ReverseBytesCopy(txid[:])), Index: vout}
+}
+
+// balance sums the utxos of w in the given states
+func balance(w *Wallet, states ...UTXOState) uint64 {
+	var sum uint64
+	for _, utxo := range w.UTXOs {
+		for _, state := range states {
+			if utxo.State == state {
+				sum += utxo.Amount
+			}
+		}
+	}
+	return sum
+}
@@ -0,0 +1,48 @@
+package wallet
+
+import (
+	"github.com/setavenger/blindbit-lib/types"
+	"github.com/setavenger/go-bip352"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// MnemonicEntropyBits results in a 24 word mnemonic
+const MnemonicEntropyBits = 256
+
+// NewMnemonic generates a new random 24 word BIP39 mnemonic
+func NewMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(MnemonicEntropyBits)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// NewWalletFromMnemonic creates or restores a wallet from a BIP39 mnemonic and an optional passphrase.
+// The keys are derived along the BIP352 derivation paths:
+//
+//	scan:  m/352'/coin_type'/0'/1'/0
+//	spend: m/352'/coin_type'/0'/0'/0
+//
+// coin_type is 0 for mainnet and 1 for all test networks.
+// birthHeight should be the height the first funds could have been received at, scanning starts from there.
+func NewWalletFromMnemonic(
+	mnemonic, passphrase string,
+	network types.Network,
+	birthHeight uint64,
+) (*Wallet, error) {
+	scanSecret, spendSecret, err := bip352.KeysFromMnemonic(
+		mnemonic, passphrase, network == types.NetworkMainnet,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := NewWallet(scanSecret, spendSecret, network, birthHeight)
+	if err != nil {
+		return nil, err
+	}
+	w.Mnemonic = mnemonic
+
+	return w, nil
+}
@@ -0,0 +1,129 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/setavenger/blindbit-lib/types"
+)
+
+// The expected keys were derived with an independent BIP32 implementation along
+// m/352'/coin_type'/0'/1'/0 (scan) and m/352'/coin_type'/0'/0'/0 (spend),
+// the mnemonics are the BIP39 test vectors.
+var mnemonicVectors = []struct {
+	name        string
+	mnemonic    string
+	passphrase  string
+	network     types.Network
+	scanSecret  string
+	spendSecret string
+	scanPub     string
+	spendPub    string
+}{
+	{
+		name:        "mainnet",
+		mnemonic:    "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		network:     types.NetworkMainnet,
+		scanSecret:  "78e7fd7d2b7a2c1456709d147021a122d2dccaafeada040cc1002083e2833b09",
+		spendSecret: "c88567742d5019d7ccc81f6e82cef8ef01997a6a3761cc9166036b580549539b",
+		scanPub:     "024139b0f81042e243a90478e43990c6a27be0e3346f0c71adbbcdd511beaea1e3",
+		spendPub:    "02fa210b3c4a60b80dd1616f48ae53bbdf0db744b3f9083385108f81be0acb58c6",
+	},
+	{
+		name:        "testnet",
+		mnemonic:    "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		network:     types.NetworkTestnet,
+		scanSecret:  "38658693c017c46fd6b8bb94b8766c123cd5baf6026338305b6f59f82b36f9c0",
+		spendSecret: "9fd37137e760930c7208fa905e991c78c522689d237a220b2820c3ddb4c745a8",
+		scanPub:     "03439fc230182b46ff22032ca7ae2dff32958c9a0d177c7b7096df0d4b7141eb21",
+		spendPub:    "02833085c9a716d36b467552c00d6aa8bd42e39adbe98b05bc203110177192f702",
+	},
+	{
+		name:        "signet uses the testnet coin type",
+		mnemonic:    "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		network:     types.NetworkSignet,
+		scanSecret:  "38658693c017c46fd6b8bb94b8766c123cd5baf6026338305b6f59f82b36f9c0",
+		spendSecret: "9fd37137e760930c7208fa905e991c78c522689d237a220b2820c3ddb4c745a8",
+		scanPub:     "03439fc230182b46ff22032ca7ae2dff32958c9a0d177c7b7096df0d4b7141eb21",
+		spendPub:    "02833085c9a716d36b467552c00d6aa8bd42e39adbe98b05bc203110177192f702",
+	},
+	{
+		name:        "mainnet with passphrase",
+		mnemonic:    "legal winner thank year wave sausage worth useful legal winner thank yellow",
+		passphrase:  "TREZOR",
+		network:     types.NetworkMainnet,
+		scanSecret:  "df7ddb38ce472de83a4dfc66a0188746bfa6d08ad277549f8f5b437c4c9ce51c",
+		spendSecret: "1071bc8a24e1d4a17f92ad34741fa94ba5bda8110ad11d194be67f2c5b604a7b",
+		scanPub:     "02bf08b13c866cc912a73a124aaecc0910aae3fcec55f04ffd2c3c4c125587ec26",
+		spendPub:    "0301a5f4d85c9681dd04003d141440c260605d33bf91fbebac4a9e3e6f854c0d16",
+	},
+	{
+		name:        "testnet with passphrase",
+		mnemonic:    "legal winner thank year wave sausage worth useful legal winner thank yellow",
+		passphrase:  "TREZOR",
+		network:     types.NetworkTestnet,
+		scanSecret:  "1b15f983a8925cfd474799415f1df9731ed77c0a24c411285c135067edab90d4",
+		spendSecret: "cd950f105284b58c2de005ece0aba836249358c487c915d536b264254dad1f9a",
+		scanPub:     "0221149888805ee77ed92a6229bed539f5c4b1eb31cda2292d35473220a206ac40",
+		spendPub:    "03be8c56f48767668cb36545bcc74f2cae391f143577006d91c9f6e19edd20314c",
+	},
+}
+
+func TestNewWalletFromMnemonic(t *testing.T) {
+	for _, v := range mnemonicVectors {
+		t.Run(v.name, func(t *testing.T) {
+			w, err := NewWalletFromMnemonic(v.mnemonic, v.passphrase, v.network, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w.Mnemonic != v.mnemonic {
+				t.Errorf("mnemonic not stored")
+			}
+			if w.Network != v.network {
+				t.Errorf("network: got %s want %s", w.Network, v.network)
+			}
+			checkHex(t, "scan secret", w.SecretKeyScan[:], v.scanSecret)
+			checkHex(t, "spend secret", w.SecretKeySpend[:], v.spendSecret)
+			checkHex(t, "scan pub", w.PubKeyScan[:], v.scanPub)
+			checkHex(t, "spend pub", w.PubKeySpend[:], v.spendPub)
+		})
+	}
+}
+
+func TestNewWalletFromMnemonicInvalid(t *testing.T) {
+	invalid := []struct {
+		name     string
+		mnemonic string
+	}{
+		{"bad checksum", "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon"},
+		{"unknown word", "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon bitcoinz"},
+		{"wrong length", "abandon abandon abandon abandon abandon about"},
+		{"empty", ""},
+	}
+	for _, v := range invalid {
+		t.Run(v.name, func(t *testing.T) {
+			_, err := NewWalletFromMnemonic(v.mnemonic, "", types.NetworkMainnet, 0)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestNewMnemonic(t *testing.T) {
+	mnemonic, err := NewMnemonic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewWalletFromMnemonic(mnemonic, "", types.NetworkSignet, 0)
+	if err != nil {
+		t.Fatalf("generated mnemonic %q is invalid: %v", mnemonic, err)
+	}
+}
+
+func checkHex(t *testing.T, name string, got []byte, want string) {
+	t.Helper()
+	if hex.EncodeToString(got) != want {
+		t.Errorf("%s: got %x want %s", name, got, want)
+	}
+}
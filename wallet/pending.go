@@ -0,0 +1,404 @@
+package wallet
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/go-bip352"
+)
+
+// TxState is the state of a SentTransaction
+type TxState int8
+
+const (
+	// TxStateCreated transactions were signed but their inputs were not marked as spent
+	TxStateCreated TxState = iota + 1
+	// TxStatePending transactions were handed out for broadcasting and wait for a confirmation
+	TxStatePending
+	TxStateConfirmed
+	// TxStateReplaced transactions were replaced with BumpFee
+	TxStateReplaced
+	// TxStateAbandoned transactions conflict with a confirmed transaction or expired
+	TxStateAbandoned
+)
+
+// DefaultPendingExpiry matches the time Bitcoin Core keeps a transaction in its mempool
+const DefaultPendingExpiry = 14 * 24 * time.Hour
+
+var txStateNames = [...]string{"created", "pending", "confirmed", "replaced", "abandoned"}
+
+func (s TxState) String() string {
+	if s < TxStateCreated || int(s) > len(txStateNames) {
+		return fmt.Sprintf("unknown(%d)", s)
+	}
+	return txStateNames[s-1]
+}
+
+func (s TxState) MarshalJSON() ([]byte, error) {
+	return []byte("\"" + s.String() + "\""), nil
+}
+
+func (s *TxState) UnmarshalJSON(data []byte) error {
+	name := strings.ReplaceAll(string(data), "\"", "")
+	for i, stateName := range txStateNames {
+		if stateName == name {
+			*s = TxState(i + 1)
+			return nil
+		}
+	}
+	return fmt.Errorf("err: %s is not a valid transaction state", string(data))
+}
+
+// ReconcileResult lists the transactions whose state was changed by Wallet.ReconcilePending
+type ReconcileResult struct {
+	Confirmed []*SentTransaction
+	Abandoned []*SentTransaction
+}
+
+// PendingTransactions returns the transactions waiting for a confirmation
+func (w *Wallet) PendingTransactions() []*SentTransaction {
+	var pending []*SentTransaction
+	for _, sent := range w.SentTransactions {
+		if sent.State == TxStatePending {
+			pending = append(pending, sent)
+		}
+	}
+	return pending
+}
+
+// ReconcilePending updates the pending transactions with what the scanner found.
+//   - Change found in a block: the transaction confirmed, its change becomes StateUnspent.
+//   - Inputs spent without the change being found: a conflicting transaction spent them, the transaction is abandoned.
+//     Transactions without change can not be told apart from a conflicting one, they confirm once all inputs are spent.
+//   - Older than expiry: the transaction is abandoned. expiry <= 0 disables this.
+//
+// Inputs of abandoned transactions are spendable again and their unconfirmed change is removed.
+// Pending transactions spending that change are abandoned as well.
+func (w *Wallet) ReconcilePending(expiry time.Duration) *ReconcileResult {
+	result := &ReconcileResult{}
+	now := time.Now()
+
+	for _, sent := range w.SentTransactions {
+		// abandoning a transaction can abandon later ones, the state is checked on every iteration
+		if sent.State != TxStatePending {
+			continue
+		}
+
+		var known, spent int
+		var height uint64
+		for _, outpoint := range sent.Inputs {
+			utxo := w.findOutPoint(outpoint)
+			if utxo == nil {
+				continue
+			}
+			known++
+			if utxo.State == StateSpent {
+				spent++
+				height = max(height, utxo.SpentHeight)
+			}
+		}
+
+		switch changeHeight := w.changeHeight(sent); {
+		case changeHeight != 0:
+			w.confirmPending(sent, changeHeight)
+			result.Confirmed = append(result.Confirmed, sent)
+		case len(sent.ChangeOutputs) == 0 && known > 0 && spent == known:
+			w.confirmPending(sent, height)
+			result.Confirmed = append(result.Confirmed, sent)
+		case spent > 0:
+			logging.L.Info().Hex("txid", sent.Txid[:]).Msg("pending transaction conflicts with a confirmed transaction")
+			result.Abandoned = append(result.Abandoned, w.abandonPending(sent)...)
+		case expiry > 0 && now.Sub(time.Unix(int64(sent.CreatedAt), 0)) > expiry:
+			logging.L.Info().Hex("txid", sent.Txid[:]).Msg("pending transaction expired")
+			result.Abandoned = append(result.Abandoned, w.abandonPending(sent)...)
+		}
+	}
+
+	return result
+}
+
+// markPending marks the inputs as StateUnconfirmedSpent and adds the outputs back to the wallet as StateUnconfirmed.
+// The change can then be tracked until ReconcilePending confirms it.
+// Only created or abandoned transactions become pending,
+// for anything else, e.g. if sent is nil as recording the transaction failed, this is just markSpent.
+func (w *Wallet) markPending(sent *SentTransaction, inputs []*OwnedUTXO) error {
+	err := w.markSpent(inputs)
+	if err != nil {
+		return err
+	}
+	if sent == nil || (sent.State != TxStateCreated && sent.State != TxStateAbandoned) {
+		return nil
+	}
+
+	tx, err := sent.Tx()
+	if err != nil {
+		logging.L.Err(err).Msg("failed to deserialise sent transaction")
+		return err
+	}
+
+	change, err := w.findOwnOutputs(tx, inputs)
+	if err != nil {
+		logging.L.Err(err).Hex("txid", sent.Txid[:]).Msg("failed to find change outputs")
+		return err
+	}
+	_, err = w.AddUTXOs(change...)
+	if err != nil {
+		return err
+	}
+
+	sent.ChangeOutputs = make([]wire.OutPoint, len(change))
+	for i, utxo := range change {
+		sent.ChangeOutputs[i] = utxo.OutPoint()
+	}
+	sent.State = TxStatePending
+	return nil
+}
+
+// findOwnOutputs scans the outputs of tx for the wallet, usually this finds the change.
+// The input_hash can only be computed if all inputs are known,
+// returns nothing if tx spends inputs which are not part of inputs.
+func (w *Wallet) findOwnOutputs(tx *wire.MsgTx, inputs []*OwnedUTXO) ([]*OwnedUTXO, error) {
+	if len(inputs) != len(tx.TxIn) {
+		logging.L.Debug().Int("inputs", len(tx.TxIn)).Int("known", len(inputs)).Msg("transaction has foreign inputs")
+		return nil, nil
+	}
+
+	vins := make([]*bip352.Vin, len(inputs))
+	pubKeys := make([][33]byte, len(inputs))
+	for i, utxo := range inputs {
+		vin := ConvertOwnedUTXOIntoVin(utxo)
+		vins[i] = &vin
+		// taproot keys are always even
+		pubKeys[i][0] = 0x02
+		copy(pubKeys[i][1:], utxo.PubKey[:])
+	}
+
+	publicKeySum, err := bip352.SumPublicKeys(pubKeys)
+	if err != nil {
+		return nil, err
+	}
+	inputHash, err := bip352.ComputeInputHash(vins, publicKeySum)
+	if err != nil {
+		return nil, err
+	}
+
+	labels, err := w.ScanLabels()
+	if err != nil {
+		return nil, err
+	}
+
+	// x-only output -> vout
+	vouts := make(map[[32]byte]uint32, len(tx.TxOut))
+	var outputs [][32]byte
+	for vout, txOut := range tx.TxOut {
+		if !bip352.IsP2TR(txOut.PkScript) {
+			continue
+		}
+		output := [32]byte(txOut.PkScript[2:])
+		vouts[output] = uint32(vout)
+		outputs = append(outputs, output)
+	}
+	if len(outputs) == 0 {
+		return nil, nil
+	}
+
+	spendPubKey := w.PubKeySpend.ToArray()
+	foundOutputs, err := bip352.ReceiverScanTransaction(
+		w.SecretKeyScan.ToArray(),
+		&spendPubKey,
+		labels,
+		outputs,
+		publicKeySum,
+		inputHash,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	txHash := tx.TxHash()
+	txid := [32]byte(bip352.ReverseBytesCopy(txHash[:]))
+	now := uint64(time.Now().Unix())
+
+	found := make([]*OwnedUTXO, len(foundOutputs))
+	for i, foundOutput := range foundOutputs {
+		vout := vouts[foundOutput.Output]
+		var label *bip352.Label
+		if foundOutput.Label != nil {
+			labelCopy := *foundOutput.Label
+			label = &labelCopy
+		}
+		found[i] = &OwnedUTXO{
+			Txid:         txid,
+			Vout:         vout,
+			Amount:       uint64(tx.TxOut[vout].Value),
+			PrivKeyTweak: foundOutput.SecKeyTweak,
+			PubKey:       foundOutput.Output,
+			Timestamp:    now,
+			State:        StateUnconfirmed,
+			Label:        label,
+		}
+	}
+	return found, nil
+}
+
+// confirmUTXO updates the known utxo with the outpoint of found if it was not confirmed before, see AddUTXOs
+func (w *Wallet) confirmUTXO(found *OwnedUTXO) {
+	if found.Height == 0 {
+		return
+	}
+	utxo := w.FindUTXO(found.Txid, found.Vout)
+	if utxo == nil || utxo == found || utxo.Height != 0 {
+		return
+	}
+	utxo.Height = found.Height
+	utxo.Timestamp = found.Timestamp
+	if utxo.State == StateUnconfirmed {
+		utxo.State = found.State
+	}
+}
+
+// changeHeight returns the height the scanner found the change of sent at, 0 if it was not found in a block yet
+func (w *Wallet) changeHeight(sent *SentTransaction) uint64 {
+	for _, outpoint := range sent.ChangeOutputs {
+		utxo := w.findOutPoint(outpoint)
+		if utxo != nil && utxo.Height != 0 {
+			return utxo.Height
+		}
+	}
+	return 0
+}
+
+// confirmPending marks sent as confirmed at height, its unconfirmed change becomes StateUnspent
+func (w *Wallet) confirmPending(sent *SentTransaction, height uint64) {
+	sent.State = TxStateConfirmed
+	sent.Height = height
+
+	for _, outpoint := range sent.ChangeOutputs {
+		utxo := w.findOutPoint(outpoint)
+		if utxo == nil {
+			continue
+		}
+		if utxo.Height == 0 {
+			utxo.Height = height
+		}
+		if utxo.State == StateUnconfirmed {
+			utxo.State = StateUnspent
+		}
+	}
+
+	logging.L.Info().Hex("txid", sent.Txid[:]).Uint64("height", height).Msg("pending transaction confirmed")
+}
+
+// abandonPending abandons sent and makes its inputs spendable again.
+// Returns all abandoned transactions, including the ones spending the change of sent.
+func (w *Wallet) abandonPending(sent *SentTransaction) []*SentTransaction {
+	abandoned := append([]*SentTransaction{sent}, w.retirePending(sent, TxStateAbandoned)...)
+
+	for _, outpoint := range sent.Inputs {
+		utxo := w.findOutPoint(outpoint)
+		if utxo == nil || utxo.State != StateUnconfirmedSpent || w.pendingSpends(outpoint) {
+			continue
+		}
+		if w.isPendingChange(outpoint) {
+			utxo.State = StateUnconfirmed
+		} else {
+			utxo.State = StateUnspent
+		}
+	}
+
+	return abandoned
+}
+
+// retirePending sets the state of a pending transaction which will never confirm and removes its unconfirmed change.
+// Pending transactions spending that change are abandoned, they are returned.
+func (w *Wallet) retirePending(sent *SentTransaction, state TxState) []*SentTransaction {
+	sent.State = state
+
+	var abandoned []*SentTransaction
+	for _, other := range w.SentTransactions {
+		if other.State != TxStatePending || !spendsAny(other, sent.ChangeOutputs) {
+			continue
+		}
+		abandoned = append(abandoned, w.abandonPending(other)...)
+	}
+
+	removed := make(map[wire.OutPoint]struct{}, len(sent.ChangeOutputs))
+	for _, outpoint := range sent.ChangeOutputs {
+		removed[outpoint] = struct{}{}
+	}
+
+	var kept UtxoCollection
+	for _, utxo := range w.UTXOs {
+		// change found by the scanner confirmed nonetheless
+		if _, ok := removed[utxo.OutPoint()]; !ok || utxo.Height != 0 {
+			kept = append(kept, utxo)
+			continue
+		}
+		key, err := utxo.GetKey()
+		if err == nil {
+			delete(w.UTXOMapping, key)
+		}
+		logging.L.Debug().Hex("txid", utxo.Txid[:]).Uint32("vout", utxo.Vout).Msg("removed unconfirmed change")
+	}
+	w.UTXOs = kept
+
+	return abandoned
+}
+
+// reopenConfirmed sets transactions confirmed above forkHeight back to pending, see Wallet.Rollback
+func (w *Wallet) reopenConfirmed(forkHeight uint64) {
+	for _, sent := range w.SentTransactions {
+		if sent.State != TxStateConfirmed || sent.Height <= forkHeight {
+			continue
+		}
+		sent.State = TxStatePending
+		sent.Height = 0
+		for _, outpoint := range sent.Inputs {
+			utxo := w.findOutPoint(outpoint)
+			if utxo != nil && utxo.State == StateUnspent {
+				utxo.State = StateUnconfirmedSpent
+			}
+		}
+		logging.L.Info().Hex("txid", sent.Txid[:]).Msg("confirmed transaction was orphaned")
+	}
+}
+
+// pendingSpends returns true if a pending transaction spends outpoint
+func (w *Wallet) pendingSpends(outpoint wire.OutPoint) bool {
+	for _, sent := range w.SentTransactions {
+		if sent.State == TxStatePending && spendsAny(sent, []wire.OutPoint{outpoint}) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPendingChange returns true if outpoint is the change of a pending transaction
+func (w *Wallet) isPendingChange(outpoint wire.OutPoint) bool {
+	for _, sent := range w.SentTransactions {
+		if sent.State != TxStatePending {
+			continue
+		}
+		for _, change := range sent.ChangeOutputs {
+			if change == outpoint {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// spendsAny returns true if sent spends one of the outpoints
+func spendsAny(sent *SentTransaction, outpoints []wire.OutPoint) bool {
+	for _, input := range sent.Inputs {
+		for _, outpoint := range outpoints {
+			if input == outpoint {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,270 @@
+package wallet
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/setavenger/blindbit-lib/types"
+)
+
+// sendPending sends amount from w to recipient and marks the transaction as pending
+func sendPending(t *testing.T, w, recipient *Wallet, amount uint64) *SentTransaction {
+	t.Helper()
+	_, err := w.SendToRecipients(
+		[]Recipient{&RecipientImpl{Address: recipient.Address(), Amount: amount}},
+		w.UnspentUTXOs(), types.FeeRateFromSatPerVByte(2), w.DustPolicy().ChangeThreshold(), true, false,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := w.SentTransactions[len(w.SentTransactions)-1]
+	if sent.State != TxStatePending {
+		t.Fatalf("state %s, want pending", sent.State)
+	}
+	return sent
+}
+
+// spendInputs marks the outpoints as spent at height like the scanner does
+func spendInputs(t *testing.T, w *Wallet, outpoints []wire.OutPoint, height uint64) {
+	t.Helper()
+	for _, outpoint := range outpoints {
+		utxo := w.findOutPoint(outpoint)
+		if utxo == nil {
+			t.Fatalf("unknown input %s", outpoint)
+		}
+		utxo.State = StateSpent
+		utxo.SpentHeight = height
+	}
+}
+
+// findChange adds the change of sent again as the scanner does when it finds it in a block at height
+func findChange(t *testing.T, w *Wallet, sent *SentTransaction, height uint64) {
+	t.Helper()
+	for _, outpoint := range sent.ChangeOutputs {
+		found := *w.findOutPoint(outpoint)
+		found.Height = height
+		found.State = StateUnspent
+		_, err := w.AddUTXOs(&found)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMarkPending(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 50_000)
+	fundTestWallet(t, w, 2, 70_000)
+
+	sent := sendPending(t, w, recipient, 60_000)
+	if len(sent.Inputs) == 0 || len(sent.ChangeOutputs) != 1 {
+		t.Fatalf("inputs %d change %d", len(sent.Inputs), len(sent.ChangeOutputs))
+	}
+	for _, outpoint := range sent.Inputs {
+		if state := w.findOutPoint(outpoint).State; state != StateUnconfirmedSpent {
+			t.Errorf("input state %s", state)
+		}
+	}
+	change := w.findOutPoint(sent.ChangeOutputs[0])
+	if change == nil || change.State != StateUnconfirmed || change.Height != 0 {
+		t.Fatalf("change %+v", change)
+	}
+	if got := w.PendingTransactions(); len(got) != 1 || got[0] != sent {
+		t.Fatalf("pending transactions %v", got)
+	}
+
+	// nothing happened on chain yet
+	result := w.ReconcilePending(DefaultPendingExpiry)
+	if len(result.Confirmed) != 0 || len(result.Abandoned) != 0 || sent.State != TxStatePending {
+		t.Fatalf("reconciled without a block: %+v", result)
+	}
+}
+
+func TestReconcilePendingConfirmed(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 50_000)
+	fundTestWallet(t, w, 2, 70_000)
+	sent := sendPending(t, w, recipient, 60_000)
+	changeAmount := w.findOutPoint(sent.ChangeOutputs[0]).Amount
+
+	// the scanner finds the change and the spends in block 101
+	findChange(t, w, sent, 101)
+	spendInputs(t, w, sent.Inputs, 101)
+
+	result := w.ReconcilePending(DefaultPendingExpiry)
+	if len(result.Confirmed) != 1 || result.Confirmed[0] != sent {
+		t.Fatalf("confirmed %v", result.Confirmed)
+	}
+	if sent.State != TxStateConfirmed || sent.Height != 101 {
+		t.Fatalf("state %s height %d", sent.State, sent.Height)
+	}
+	change := w.findOutPoint(sent.ChangeOutputs[0])
+	if change.State != StateUnspent || change.Height != 101 {
+		t.Fatalf("change state %s height %d", change.State, change.Height)
+	}
+	if got := balance(w, StateUnspent); got != changeAmount {
+		t.Fatalf("balance %d, want the change %d", got, changeAmount)
+	}
+}
+
+func TestReconcilePendingConflict(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 50_000)
+	fundTestWallet(t, w, 2, 70_000)
+	sent := sendPending(t, w, recipient, 60_000)
+	change := sent.ChangeOutputs[0]
+
+	// all inputs are spent by another transaction, the change never shows up
+	spendInputs(t, w, sent.Inputs, 101)
+
+	result := w.ReconcilePending(DefaultPendingExpiry)
+	if len(result.Confirmed) != 0 {
+		t.Fatal("conflicting transaction was confirmed")
+	}
+	if len(result.Abandoned) != 1 || sent.State != TxStateAbandoned {
+		t.Fatalf("abandoned %v state %s", result.Abandoned, sent.State)
+	}
+	if w.findOutPoint(change) != nil {
+		t.Fatal("change of the conflicted transaction is still in the wallet")
+	}
+	if got := balance(w, StateUnspent, StateUnconfirmed); got != 0 {
+		t.Fatalf("balance %d after the inputs were spent elsewhere", got)
+	}
+}
+
+func TestReconcilePendingPartialConflict(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 50_000)
+	fundTestWallet(t, w, 2, 70_000)
+	sent := sendPending(t, w, recipient, 100_000)
+	if len(sent.Inputs) != 2 {
+		t.Fatalf("inputs %d", len(sent.Inputs))
+	}
+
+	spendInputs(t, w, sent.Inputs[:1], 101)
+
+	result := w.ReconcilePending(DefaultPendingExpiry)
+	if len(result.Abandoned) != 1 || sent.State != TxStateAbandoned {
+		t.Fatalf("abandoned %v state %s", result.Abandoned, sent.State)
+	}
+	// the other input can be spent again
+	if state := w.findOutPoint(sent.Inputs[1]).State; state != StateUnspent {
+		t.Fatalf("unspent input state %s", state)
+	}
+	if len(w.UTXOs) != 2 {
+		t.Fatalf("%d utxos, the change was not removed", len(w.UTXOs))
+	}
+}
+
+func TestReconcilePendingWithoutChange(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 50_000)
+
+	_, err := w.Sweep(recipient.Address(), w.UnspentUTXOs(), types.FeeRateFromSatPerVByte(2), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := w.SentTransactions[0]
+	if sent.State != TxStatePending || len(sent.ChangeOutputs) != 0 {
+		t.Fatalf("state %s change %d", sent.State, len(sent.ChangeOutputs))
+	}
+
+	spendInputs(t, w, sent.Inputs, 105)
+	result := w.ReconcilePending(DefaultPendingExpiry)
+	if len(result.Confirmed) != 1 || sent.State != TxStateConfirmed || sent.Height != 105 {
+		t.Fatalf("confirmed %v state %s height %d", result.Confirmed, sent.State, sent.Height)
+	}
+}
+
+func TestReconcilePendingExpired(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 50_000)
+	fundTestWallet(t, w, 2, 70_000)
+	sent := sendPending(t, w, recipient, 60_000)
+
+	sent.CreatedAt -= uint64((DefaultPendingExpiry + time.Hour).Seconds())
+
+	// expiry <= 0 never expires
+	if result := w.ReconcilePending(0); len(result.Abandoned) != 0 {
+		t.Fatal("abandoned without expiry")
+	}
+
+	result := w.ReconcilePending(DefaultPendingExpiry)
+	if len(result.Abandoned) != 1 || sent.State != TxStateAbandoned {
+		t.Fatalf("abandoned %v state %s", result.Abandoned, sent.State)
+	}
+	if got := balance(w, StateUnspent); got != 120_000 {
+		t.Fatalf("balance %d after abandoning", got)
+	}
+	if got := balance(w, StateUnconfirmed, StateUnconfirmedSpent); got != 0 {
+		t.Fatalf("unconfirmed balance %d after abandoning", got)
+	}
+}
+
+func TestReconcilePendingAbandonsChildren(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 50_000)
+	parent := sendPending(t, w, recipient, 10_000)
+
+	// spend the unconfirmed change of parent
+	change := w.findOutPoint(parent.ChangeOutputs[0])
+	_, err := w.SendToRecipients(
+		[]Recipient{&RecipientImpl{Address: recipient.Address(), Amount: 10_000}},
+		UtxoCollection{change}, types.FeeRateFromSatPerVByte(2), w.DustPolicy().ChangeThreshold(), true, true,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := w.SentTransactions[len(w.SentTransactions)-1]
+	if child.State != TxStatePending {
+		t.Fatalf("child state %s", child.State)
+	}
+
+	parent.CreatedAt -= uint64((DefaultPendingExpiry + time.Hour).Seconds())
+	result := w.ReconcilePending(DefaultPendingExpiry)
+	if len(result.Abandoned) != 2 || child.State != TxStateAbandoned {
+		t.Fatalf("abandoned %d child state %s", len(result.Abandoned), child.State)
+	}
+	if len(w.UTXOs) != 1 || w.UTXOs[0].State != StateUnspent {
+		t.Fatalf("utxos after abandoning %v", w.UTXOs)
+	}
+}
+
+func TestRollbackReopensConfirmed(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 50_000)
+	sent := sendPending(t, w, recipient, 10_000)
+	findChange(t, w, sent, 101)
+	spendInputs(t, w, sent.Inputs, 101)
+	w.ReconcilePending(DefaultPendingExpiry)
+
+	w.Rollback(100)
+	if sent.State != TxStatePending || sent.Height != 0 {
+		t.Fatalf("state %s height %d after rollback", sent.State, sent.Height)
+	}
+	if state := w.findOutPoint(sent.Inputs[0]).State; state != StateUnconfirmedSpent {
+		t.Fatalf("input state %s after rollback", state)
+	}
+}
+
+func TestTxStateJSON(t *testing.T) {
+	for state := TxStateCreated; state <= TxStateAbandoned; state++ {
+		data, err := json.Marshal(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded TxState
+		if err = json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded != state {
+			t.Fatalf("%s decoded as %s", data, decoded)
+		}
+	}
+	var decoded TxState
+	if err := json.Unmarshal([]byte(`"unknown"`), &decoded); err == nil {
+		t.Fatal("decoded an unknown state")
+	}
+}
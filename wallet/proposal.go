@@ -0,0 +1,247 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/blindbit-lib/types"
+	"github.com/setavenger/go-bip352"
+)
+
+var ErrProposalSigned = errors.New("proposal was already signed")
+
+// TransactionProposal is a transaction which is fully built but not signed yet.
+// It contains everything a user should confirm before the transaction is signed with Wallet.SignProposal.
+type TransactionProposal struct {
+	Inputs []*OwnedUTXO
+	// Outputs in transaction order, silent payment outputs have their final script
+	Outputs []ProposalOutput
+	// Change is the amount of the change output, 0 if there is none
+	Change uint64
+	Fee    uint64
+	// VSize of the signed transaction, exact as taproot signatures have a fixed size
+	VSize int64
+	// FeeRate is the requested fee rate
+	FeeRate types.FeeRate
+	// EffectiveFeeRate is Fee / VSize, higher than FeeRate if the excess of a changeless transaction goes to the miners
+	EffectiveFeeRate types.FeeRate
+	// Waste of the coin selection, see Selection.Waste
+	Waste int64
+
+	recipients []Recipient
+	excess     uint64
+	packet     *psbt.Packet
+	signed     bool
+	// parent is set for CPFP children, FeeRate is then the fee rate of the package
+	parent *cpfpParent
+}
+
+// cpfpParent is the unconfirmed parent a child transaction pays for
+type cpfpParent struct {
+	vSize int64
+	fee   uint64
+}
+
+// ProposalOutput is an output of a TransactionProposal
+type ProposalOutput struct {
+	// Address is the silent payment address for silent payment outputs, empty if the script has no address
+	Address  string
+	Amount   uint64
+	PkScript []byte
+	// IsChange is true for the change output to the wallet's change label
+	IsChange bool
+}
+
+// ProposeTransaction selects coins and builds the transaction paying the recipients, but does not sign it.
+// The proposal can be shown to the user and turned into the final transaction with SignProposal.
+// Utxo states are not changed.
+func (w *Wallet) ProposeTransaction(
+	recipients []Recipient,
+	utxos UtxoCollection,
+	feeRate types.FeeRate,
+	minChangeAmount uint64,
+	opts ...SendOption,
+) (
+	*TransactionProposal, error,
+) {
+	// the ECDH shares for the silent payment outputs need the secret keys of the inputs
+	if w.IsWatchOnly() {
+		return nil, ErrWatchOnly
+	}
+
+	chainParams, ok := types.NetworkParams[w.Network]
+	if !ok {
+		return nil, fmt.Errorf("unsupported network: %s", w.Network)
+	}
+
+	selection, err := w.selectCoins(recipients, utxos, feeRate, minChangeAmount, newSendOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	return w.newProposal(recipients, selection, feeRate, chainParams)
+}
+
+// SignProposal signs the proposal and returns the serialised transaction.
+// The transaction is recorded in Wallet.SentTransactions, utxo states are not changed.
+func (w *Wallet) SignProposal(proposal *TransactionProposal) ([]byte, error) {
+	finalTx, err := w.signProposal(proposal)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = finalTx.Serialize(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	w.recordSentTransaction(finalTx, proposal.recipients, proposal.selection(), proposal.FeeRate)
+
+	return buf.Bytes(), nil
+}
+
+// newProposal builds the transaction for the selection and computes the silent payment outputs
+func (w *Wallet) newProposal(
+	recipients []Recipient,
+	selection *Selection,
+	feeRate types.FeeRate,
+	chainParams *chaincfg.Params,
+) (
+	*TransactionProposal, error,
+) {
+	packet, err := w.buildPsbt(recipients, selection.UTXOs, selection.Change, chainParams)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.AddEcdhShares(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ComputeSilentPaymentOutputs(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	estimator := NewWeightEstimator()
+	outputs := make([]ProposalOutput, len(packet.UnsignedTx.TxOut))
+	for i, txOut := range packet.UnsignedTx.TxOut {
+		estimator.AddOutput(txOut.PkScript)
+		outputs[i], err = proposalOutput(txOut, &packet.Outputs[i], chainParams)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for range packet.UnsignedTx.TxIn {
+		estimator.AddTaprootInput()
+	}
+	vSize := estimator.VSize()
+
+	return &TransactionProposal{
+		Inputs:           selection.UTXOs,
+		Outputs:          outputs,
+		Change:           selection.Change,
+		Fee:              selection.Fee,
+		VSize:            vSize,
+		FeeRate:          feeRate,
+		EffectiveFeeRate: types.FeeRateFromSatPerKvB(selection.Fee * 1000 / uint64(vSize)),
+		Waste:            selection.Waste,
+		recipients:       recipients,
+		excess:           selection.Excess,
+		packet:           packet,
+	}, nil
+}
+
+func proposalOutput(txOut *wire.TxOut, pOutput *psbt.POutput, chainParams *chaincfg.Params) (ProposalOutput, error) {
+	output := ProposalOutput{
+		Amount:   uint64(txOut.Value),
+		PkScript: txOut.PkScript,
+	}
+
+	spInfo, err := OutputSpInfo(pOutput)
+	if err != nil {
+		return output, err
+	}
+	if spInfo == nil {
+		_, addresses, _, err := txscript.ExtractPkScriptAddrs(txOut.PkScript, chainParams)
+		if err == nil && len(addresses) == 1 {
+			output.Address = addresses[0].EncodeAddress()
+		}
+		return output, nil
+	}
+
+	output.Address, err = bip352.CreateAddress(
+		&spInfo.ScanPubKey, &spInfo.SpendPubKey, chainParams.Name == chaincfg.MainNetParams.Name, 0,
+	)
+	if err != nil {
+		return output, err
+	}
+	// only the change output is labeled by the wallet
+	_, output.IsChange, err = OutputSpLabel(pOutput)
+	return output, err
+}
+
+// signProposal signs and extracts the transaction, the fee is checked against the requested fee rate
+func (w *Wallet) signProposal(proposal *TransactionProposal) (*wire.MsgTx, error) {
+	if proposal.signed {
+		return nil, ErrProposalSigned
+	}
+
+	var sumAllInputs uint64
+	for _, utxo := range proposal.Inputs {
+		sumAllInputs += utxo.Amount
+	}
+
+	err := w.SignPsbt(proposal.packet)
+	if err != nil {
+		return nil, err
+	}
+
+	err = psbt.MaybeFinalizeAll(proposal.packet)
+	if err != nil {
+		logging.L.Err(err).Msg("failed to finalize psbt")
+		return nil, err
+	}
+
+	finalTx, err := psbt.Extract(proposal.packet)
+	if err != nil {
+		logging.L.Err(err).Msg("failed to extract transaction from psbt")
+		return nil, err
+	}
+
+	// without change the excess goes to the miners
+	if proposal.parent != nil {
+		err = VerifyPackageFeeRate(
+			finalTx, sumAllInputs, proposal.parent.vSize, proposal.parent.fee, proposal.FeeRate, proposal.excess,
+		)
+	} else {
+		err = VerifyFeeRate(finalTx, sumAllInputs, proposal.FeeRate, proposal.excess)
+	}
+	if err != nil {
+		logging.L.Err(err).Msg("fee rate check failed")
+		return nil, err
+	}
+
+	// only now, so a proposal which failed to sign can be signed again
+	proposal.signed = true
+	return finalTx, nil
+}
+
+// selection returns the coin selection the proposal was built from
+func (p *TransactionProposal) selection() *Selection {
+	return &Selection{
+		UTXOs:  p.Inputs,
+		Change: p.Change,
+		Fee:    p.Fee,
+		Excess: p.excess,
+		Waste:  p.Waste,
+	}
+}
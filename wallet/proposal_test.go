@@ -0,0 +1,170 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/setavenger/blindbit-lib/types"
+)
+
+func TestProposeTransaction(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 50_000)
+	fundTestWallet(t, w, 2, 70_000)
+	address, err := btcutil.NewAddressWitnessPubKeyHash(make([]byte, 20), &chaincfg.SigNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proposal, err := w.ProposeTransaction([]Recipient{
+		&RecipientImpl{Address: recipient.Address(), Amount: 60_000},
+		&RecipientImpl{Address: address.EncodeAddress(), Amount: 5_000},
+	}, w.UnspentUTXOs(), types.FeeRateFromSatPerVByte(2), w.DustPolicy().ChangeThreshold())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(proposal.Inputs) != 2 || len(proposal.Outputs) != 3 || proposal.Change == 0 {
+		t.Fatalf("%d inputs %d outputs change %d", len(proposal.Inputs), len(proposal.Outputs), proposal.Change)
+	}
+	var outputSum uint64
+	var changes int
+	for _, output := range proposal.Outputs {
+		outputSum += output.Amount
+		switch {
+		case output.IsChange:
+			changes++
+			if output.Amount != proposal.Change || output.Address != w.LabelSlice()[0].Address {
+				t.Errorf("change output %+v", output)
+			}
+		case output.Amount == 60_000:
+			if output.Address != recipient.Address() || len(output.PkScript) != ScriptPubKeyTaprootLen {
+				t.Errorf("silent payment output %+v", output)
+			}
+		case output.Amount == 5_000:
+			if output.Address != address.EncodeAddress() {
+				t.Errorf("p2wpkh output %+v", output)
+			}
+		default:
+			t.Errorf("unexpected output %+v", output)
+		}
+	}
+	if changes != 1 {
+		t.Fatalf("%d change outputs", changes)
+	}
+	if outputSum+proposal.Fee != 120_000 {
+		t.Fatalf("outputs %d fee %d", outputSum, proposal.Fee)
+	}
+	if proposal.EffectiveFeeRate.Less(proposal.FeeRate) {
+		t.Fatalf("effective fee rate %s below %s", proposal.EffectiveFeeRate, proposal.FeeRate)
+	}
+
+	// nothing changed in the wallet yet
+	if len(w.SentTransactions) != 0 || balance(w, StateUnspent) != 120_000 {
+		t.Fatal("proposing changed the wallet")
+	}
+
+	raw, err := w.SignProposal(proposal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := decodeTestTx(t, raw)
+	_, prevOuts, _ := testPrevOuts(t, w, tx)
+	verifyTestTx(t, tx, prevOuts)
+	if TxVSize(tx) != proposal.VSize {
+		t.Fatalf("signed %d vB, proposed %d vB", TxVSize(tx), proposal.VSize)
+	}
+	for i, txOut := range tx.TxOut {
+		if !bytes.Equal(txOut.PkScript, proposal.Outputs[i].PkScript) || uint64(txOut.Value) != proposal.Outputs[i].Amount {
+			t.Fatalf("output %d differs from the proposal", i)
+		}
+	}
+	if sent := w.FindSentTransaction(testTxid(tx)); sent == nil || sent.Fee != proposal.Fee {
+		t.Fatalf("sent transaction %+v", sent)
+	}
+	if balance(w, StateUnspent) != 120_000 {
+		t.Fatal("signing changed the utxo states")
+	}
+
+	if _, err = w.SignProposal(proposal); !errors.Is(err, ErrProposalSigned) {
+		t.Fatalf("signed twice: %v", err)
+	}
+}
+
+func TestProposeTransactionErrors(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 50_000)
+	recipients := []Recipient{&RecipientImpl{Address: recipient.Address(), Amount: 60_000}}
+
+	if _, err := w.ProposeTransaction(recipients, w.UnspentUTXOs(), types.FeeRateFromSatPerVByte(2), 0); !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("insufficient funds: %v", err)
+	}
+	if _, err := w.ProposeTransaction(recipients, w.UnspentUTXOs(), types.FeeRate{}, 0); !errors.Is(err, ErrInvalidFeeRate) {
+		t.Errorf("zero fee rate: %v", err)
+	}
+	watchOnly := newTestWatchOnlyWallet(t, 1)
+	if _, err := watchOnly.ProposeTransaction(recipients, w.UnspentUTXOs(), types.FeeRateFromSatPerVByte(2), 0); !errors.Is(err, ErrWatchOnly) {
+		t.Errorf("watch-only: %v", err)
+	}
+}
+
+var errTestSigner = errors.New("signer unavailable")
+
+// failingSigner fails to sign until fail is false
+type failingSigner struct {
+	*KeySigner
+	fail bool
+}
+
+func (s *failingSigner) SignTaproot(tweak [32]byte, sigHash [32]byte) ([64]byte, error) {
+	if s.fail {
+		return [64]byte{}, errTestSigner
+	}
+	return s.KeySigner.SignTaproot(tweak, sigHash)
+}
+
+// A proposal which failed to sign can be signed again
+func TestSignProposalRetry(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 50_000)
+	signer := &failingSigner{KeySigner: NewKeySigner(w.SecretKeySpend.ToArray()), fail: true}
+	if err := w.SetSigner(signer); err != nil {
+		t.Fatal(err)
+	}
+
+	proposal, err := proposeTestTx(w, recipient, 30_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.SignProposal(proposal); !errors.Is(err, errTestSigner) {
+		t.Fatalf("error %v, want the signer error", err)
+	}
+	if len(w.SentTransactions) != 0 {
+		t.Fatal("failed transaction was recorded")
+	}
+
+	signer.fail = false
+	raw, err := w.SignProposal(proposal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := decodeTestTx(t, raw)
+	_, prevOuts, _ := testPrevOuts(t, w, tx)
+	verifyTestTx(t, tx, prevOuts)
+}
+
+func TestProposeTransactionInvalidAddress(t *testing.T) {
+	w := newTestWallet(t, 1)
+	fundTestWallet(t, w, 1, 50_000)
+	_, err := w.ProposeTransaction(
+		[]Recipient{&RecipientImpl{Address: "tb1qinvalid", Amount: 10_000}},
+		w.UnspentUTXOs(), types.FeeRateFromSatPerVByte(2), w.DustPolicy().ChangeThreshold(),
+	)
+	if err == nil || !strings.Contains(err.Error(), "tb1qinvalid") {
+		t.Fatalf("error %v", err)
+	}
+}
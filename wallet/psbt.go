@@ -0,0 +1,352 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/setavenger/blindbit-lib/types"
+	"github.com/setavenger/go-bip352"
+)
+
+// PsbtInSpTweak is the PSBT input key type (BIP376) for the silent payment tweak of the spent output.
+// The secret key of the output is the spend secret key plus the tweak.
+const PsbtInSpTweak byte = 0x1f
+
+var (
+	ErrMissingSpTweak = errors.New("psbt input has no silent payment tweak")
+	ErrNoInputSigned  = errors.New("none of the psbt inputs belong to the key")
+)
+
+// SetInputSpTweak stores the silent payment tweak in the input
+func SetInputSpTweak(input *psbt.PInput, tweak [32]byte) {
+	input.Unknowns = setUnknown(input.Unknowns, []byte{PsbtInSpTweak}, tweak[:])
+}
+
+// InputSpTweak returns the silent payment tweak stored in the input
+func InputSpTweak(input *psbt.PInput) ([32]byte, error) {
+	value := getUnknown(input.Unknowns, []byte{PsbtInSpTweak})
+	if value == nil {
+		return [32]byte{}, ErrMissingSpTweak
+	}
+	if len(value) != 32 {
+		return [32]byte{}, fmt.Errorf("invalid silent payment tweak length %d", len(value))
+	}
+	return [32]byte(value), nil
+}
+
+// setUnknown sets the value for key, an existing entry with the same key is replaced
+func setUnknown(unknowns []*psbt.Unknown, key, value []byte) []*psbt.Unknown {
+	value = append([]byte(nil), value...)
+	for _, unknown := range unknowns {
+		if bytes.Equal(unknown.Key, key) {
+			unknown.Value = value
+			return unknowns
+		}
+	}
+	return append(unknowns, &psbt.Unknown{
+		Key:   append([]byte(nil), key...),
+		Value: value,
+	})
+}
+
+// getUnknown returns nil if key is not present
+func getUnknown(unknowns []*psbt.Unknown, key []byte) []byte {
+	for _, unknown := range unknowns {
+		if bytes.Equal(unknown.Key, key) {
+			return unknown.Value
+		}
+	}
+	return nil
+}
+
+// SignTweakedPsbt signs all inputs of the packet which carry a silent payment tweak (see SetInputSpTweak)
+// with spendSecret plus the tweak, see SignPsbtWithSigner.
+func SignTweakedPsbt(packet *psbt.Packet, spendSecret [32]byte) error {
+	return SignPsbtWithSigner(packet, NewKeySigner(spendSecret))
+}
+
+// SignPsbtWithSigner signs all inputs of the packet which carry a silent payment tweak (see SetInputSpTweak) with signer.
+// Inputs without a tweak or with a key not matching the spent output
+// belong to someone else and are skipped. Fails with ErrNoInputSigned if no input could be signed.
+// Every input needs a WitnessUtxo, as taproot signatures commit to all spent outputs.
+// Fails with ErrSpOutputsNotComputed if silent payment outputs are still missing their scripts.
+// The signed inputs are finalised, once all inputs are signed the transaction can be taken from the packet with psbt.Extract.
+func SignPsbtWithSigner(packet *psbt.Packet, signer Signer) error {
+	if len(packet.Inputs) != len(packet.UnsignedTx.TxIn) {
+		return fmt.Errorf("mismatch with txIns (%d) and psbt inputs (%d)", len(packet.UnsignedTx.TxIn), len(packet.Inputs))
+	}
+
+	for _, txOut := range packet.UnsignedTx.TxOut {
+		if len(txOut.PkScript) == 0 {
+			return ErrSpOutputsNotComputed
+		}
+	}
+
+	prevOuts := make(map[wire.OutPoint]*wire.TxOut, len(packet.Inputs))
+	for i, input := range packet.Inputs {
+		if input.WitnessUtxo == nil {
+			return fmt.Errorf("psbt input %d has no witness utxo", i)
+		}
+		prevOuts[packet.UnsignedTx.TxIn[i].PreviousOutPoint] = input.WitnessUtxo
+	}
+
+	spendPubKey, err := signer.SpendPubKey()
+	if err != nil {
+		return err
+	}
+
+	fetcher := txscript.NewMultiPrevOutFetcher(prevOuts)
+	sigHashes := txscript.NewTxSigHashes(packet.UnsignedTx, fetcher)
+
+	var signed int
+	for i := range packet.Inputs {
+		input := &packet.Inputs[i]
+
+		tweak, ok, err := inputTweak(input, spendPubKey)
+		if err != nil {
+			return fmt.Errorf("input %d: %w", i, err)
+		}
+		if !ok {
+			continue
+		}
+
+		signatureHash, err := txscript.CalcTaprootSignatureHash(
+			sigHashes, txscript.SigHashDefault, packet.UnsignedTx, i, fetcher,
+		)
+		if err != nil {
+			return err
+		}
+
+		signature, err := signer.SignTaproot(tweak, [32]byte(signatureHash))
+		if err != nil {
+			return fmt.Errorf("input %d: %w", i, err)
+		}
+
+		var witnessBytes bytes.Buffer
+		err = psbt.WriteTxWitness(&witnessBytes, [][]byte{signature[:]})
+		if err != nil {
+			return err
+		}
+		input.SighashType = txscript.SigHashDefault
+		input.FinalScriptWitness = witnessBytes.Bytes()
+		signed++
+	}
+
+	if signed == 0 {
+		return ErrNoInputSigned
+	}
+
+	return nil
+}
+
+// inputTweak returns the silent payment tweak of the input.
+// Returns false if the input has no tweak or spendPubKey plus the tweak does not match the spent output.
+func inputTweak(input *psbt.PInput, spendPubKey [33]byte) ([32]byte, bool, error) {
+	tweak, err := InputSpTweak(input)
+	if errors.Is(err, ErrMissingSpTweak) {
+		return [32]byte{}, false, nil
+	}
+	if err != nil {
+		return [32]byte{}, false, err
+	}
+	if input.WitnessUtxo == nil || !bip352.IsP2TR(input.WitnessUtxo.PkScript) {
+		return [32]byte{}, false, ErrUnsupportedInput
+	}
+
+	pubKey, err := bip352.AddPublicKeys(&spendPubKey, bip352.PubKeyFromSecKey(&tweak))
+	if err != nil {
+		return [32]byte{}, false, err
+	}
+	if !bytes.Equal(pubKey[1:], input.WitnessUtxo.PkScript[2:]) {
+		return [32]byte{}, false, nil
+	}
+	return tweak, true, nil
+}
+
+// CreatePsbt selects coins and creates a BIP375 PSBT paying the recipients.
+// Silent payment outputs are computed later with ComputeSilentPaymentOutputs,
+// after every input contributed its ECDH share (see Wallet.AddEcdhShares).
+// Works for watch-only wallets.
+func (w *Wallet) CreatePsbt(
+	recipients []Recipient,
+	utxos UtxoCollection,
+	feeRate types.FeeRate,
+	minChangeAmount uint64,
+	opts ...SendOption,
+) (
+	*psbt.Packet, error,
+) {
+	chainParams, ok := types.NetworkParams[w.Network]
+	if !ok {
+		return nil, fmt.Errorf("unsupported network: %s", w.Network)
+	}
+
+	selection, err := w.selectCoins(recipients, utxos, feeRate, minChangeAmount, newSendOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	selectedUTXOs, changeAmount := selection.UTXOs, selection.Change
+
+	return w.buildPsbt(recipients, selectedUTXOs, changeAmount, chainParams)
+}
+
+// buildPsbt creates the PSBT for already selected utxos.
+// A change output to the change label is added if changeAmount > 0.
+func (w *Wallet) buildPsbt(
+	recipients []Recipient,
+	selectedUTXOs []*OwnedUTXO,
+	changeAmount uint64,
+	chainParams *chaincfg.Params,
+) (
+	*psbt.Packet, error,
+) {
+	vins := make([]*bip352.Vin, len(selectedUTXOs))
+	for i, utxo := range selectedUTXOs {
+		vin := ConvertOwnedUTXOIntoVin(utxo)
+		vins[i] = &vin
+	}
+
+	if changeAmount > 0 {
+		// change exists, and it should be greater than the MinChangeAmount
+		recipients = append(recipients, &RecipientImpl{
+			Address: w.ChangeAddress(),
+			Amount:  changeAmount,
+		})
+	}
+
+	packet, err := CreateSilentPaymentPsbt(recipients, vins, chainParams)
+	if err != nil {
+		return nil, err
+	}
+
+	err = addInputSpendData(packet, selectedUTXOs)
+	if err != nil {
+		return nil, err
+	}
+
+	if changeAmount > 0 {
+		err = w.labelChangeOutputs(packet)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return packet, nil
+}
+
+// addInputSpendData adds the WitnessUtxo and the silent payment tweak to every input of the packet
+func addInputSpendData(packet *psbt.Packet, utxos []*OwnedUTXO) error {
+	utxoMap := make(map[string]*OwnedUTXO, len(utxos))
+	for _, utxo := range utxos {
+		utxoMap[fmt.Sprintf("%x:%d", utxo.Txid, utxo.Vout)] = utxo
+	}
+
+	if len(packet.Inputs) != len(packet.UnsignedTx.TxIn) {
+		packet.Inputs = make([]psbt.PInput, len(packet.UnsignedTx.TxIn))
+	}
+	for i, txIn := range packet.UnsignedTx.TxIn {
+		outpoint := txIn.PreviousOutPoint
+		key := fmt.Sprintf("%x:%d", bip352.ReverseBytesCopy(outpoint.Hash[:]), outpoint.Index)
+		utxo, ok := utxoMap[key]
+		if !ok {
+			return fmt.Errorf("no utxo found for input %s", outpoint)
+		}
+
+		vin := ConvertOwnedUTXOIntoVin(utxo)
+		packet.Inputs[i].WitnessUtxo = wire.NewTxOut(int64(vin.Amount), vin.ScriptPubKey)
+		SetInputSpTweak(&packet.Inputs[i], utxo.PrivKeyTweak)
+	}
+
+	return nil
+}
+
+// labelChangeOutputs adds PSBT_OUT_SP_V0_LABEL to all outputs paying to the change address
+func (w *Wallet) labelChangeOutputs(packet *psbt.Packet) error {
+	_, changeSpendKey, err := bip352.DecodeSilentPaymentAddressToKeys(
+		w.ChangeAddress(), w.Network == types.NetworkMainnet,
+	)
+	if err != nil {
+		return err
+	}
+
+	for i := range packet.Outputs {
+		info, err := OutputSpInfo(&packet.Outputs[i])
+		if err != nil {
+			return err
+		}
+		if info == nil || info.ScanPubKey != w.PubKeyScan || info.SpendPubKey != changeSpendKey {
+			continue
+		}
+		// the change label is always m = 0
+		SetOutputSpLabel(&packet.Outputs[i], 0)
+	}
+	return nil
+}
+
+// AddEcdhShares adds the ECDH shares for all inputs of the packet which belong to the wallet.
+// Inputs belong to the wallet if their silent payment tweak plus the spend key match the spent output.
+// If the wallet owns all inputs a single global share is added, otherwise one share per input.
+// The shares are computed by the Signer of the wallet.
+func (w *Wallet) AddEcdhShares(packet *psbt.Packet) error {
+	signer := w.Signer()
+	if signer == nil {
+		return ErrWatchOnly
+	}
+
+	var tweaks [][32]byte
+	var ownInputs []int
+	for i := range packet.Inputs {
+		tweak, ok, err := inputTweak(&packet.Inputs[i], w.PubKeySpend.ToArray())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		tweaks = append(tweaks, tweak)
+		ownInputs = append(ownInputs, i)
+	}
+
+	if len(ownInputs) == 0 {
+		return ErrNoInputSigned
+	}
+
+	scanKeys, err := outputScanKeys(packet)
+	if err != nil {
+		return err
+	}
+
+	for _, scanKey := range scanKeys {
+		if len(ownInputs) == len(packet.Inputs) {
+			share, err := signer.EcdhShare(tweaks, scanKey)
+			if err != nil {
+				return err
+			}
+			setGlobalEcdhShare(packet, share)
+			continue
+		}
+
+		for i, index := range ownInputs {
+			share, err := signer.EcdhShare([][32]byte{tweaks[i]}, scanKey)
+			if err != nil {
+				return err
+			}
+			setInputEcdhShare(&packet.Inputs[index], share)
+		}
+	}
+	return nil
+}
+
+// SignPsbt signs all inputs of the packet which belong to the wallet with the Signer of the wallet, see SignPsbtWithSigner
+func (w *Wallet) SignPsbt(packet *psbt.Packet) error {
+	signer := w.Signer()
+	if signer == nil {
+		return ErrWatchOnly
+	}
+	return SignPsbtWithSigner(packet, signer)
+}
@@ -0,0 +1,192 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/blindbit-lib/types"
+	"github.com/setavenger/blindbit-lib/utils"
+	"github.com/setavenger/go-bip352"
+)
+
+// RBFSequence is the input sequence the wallet uses, it signals replaceability according to BIP125
+const RBFSequence = wire.MaxTxInSequenceNum - 2
+
+// IncrementalRelayFeeRate is the minimum fee rate a replacement has to pay for its own size
+// on top of the fee of the replaced transaction (BIP125 rule 4, Bitcoin Core default)
+var IncrementalRelayFeeRate = types.FeeRateFromSatPerKvB(1000)
+
+var (
+	ErrSentTransactionNotFound = errors.New("transaction was not created by this wallet")
+	ErrTransactionReplaced     = errors.New("transaction was already replaced")
+	ErrNotReplaceable          = errors.New("transaction does not signal replaceability")
+	ErrFeeRateNotHigher        = errors.New("new fee rate has to be higher than the fee rate of the original transaction")
+)
+
+// SignalsRBF returns true if any input of tx signals replaceability (BIP125)
+func SignalsRBF(tx *wire.MsgTx) bool {
+	for _, txIn := range tx.TxIn {
+		if txIn.Sequence < wire.MaxTxInSequenceNum-1 {
+			return true
+		}
+	}
+	return false
+}
+
+// BumpFee creates a replacement for the transaction txid which was sent by the wallet (see Wallet.SentTransactions).
+// The replacement spends all inputs of the original, so only one of them can confirm,
+// and pays the same recipients at newFeeRate.
+// Silent payment outputs depend on the inputs and are derived again.
+// The additional fee is taken from the change, if that is not enough unspent utxos are added.
+// The replacement has to pay more fees than the original plus its own size at IncrementalRelayFeeRate (BIP125),
+// if newFeeRate is not enough for that the fee rate is raised to the minimum.
+// Fails with ErrInsufficientFunds if the wallet can not pay the fees.
+//
+// Added inputs get the state of the original inputs. The original is marked as replaced and the replacement is recorded.
+// If the original was pending its change is removed and the replacement becomes pending.
+func (w *Wallet) BumpFee(txid [32]byte, newFeeRate types.FeeRate) ([]byte, error) {
+	if w.IsWatchOnly() {
+		return nil, ErrWatchOnly
+	}
+
+	chainParams, ok := types.NetworkParams[w.Network]
+	if !ok {
+		return nil, fmt.Errorf("unsupported network: %s", w.Network)
+	}
+
+	sent := w.FindSentTransaction(txid)
+	if sent == nil {
+		return nil, ErrSentTransactionNotFound
+	}
+	if sent.ReplacedBy != [32]byte{} {
+		return nil, fmt.Errorf("%w by %x", ErrTransactionReplaced, sent.ReplacedBy)
+	}
+
+	original, err := sent.Tx()
+	if err != nil {
+		logging.L.Err(err).Msg("failed to deserialise sent transaction")
+		return nil, err
+	}
+	if !SignalsRBF(original) {
+		return nil, ErrNotReplaceable
+	}
+
+	// the replacement has to conflict with the original, so all original inputs are spent again
+	var inputs []*OwnedUTXO
+	var inputSum uint64
+	for _, txIn := range original.TxIn {
+		inputTxid := utils.ConvertToFixedLength32(bip352.ReverseBytesCopy(txIn.PreviousOutPoint.Hash[:]))
+		utxo := w.FindUTXO(inputTxid, txIn.PreviousOutPoint.Index)
+		if utxo == nil {
+			return nil, fmt.Errorf("input %s of the original transaction is not in the wallet", txIn.PreviousOutPoint)
+		}
+		inputs = append(inputs, utxo)
+		inputSum += utxo.Amount
+	}
+
+	var outputSum uint64
+	for _, txOut := range original.TxOut {
+		outputSum += uint64(txOut.Value)
+	}
+	if outputSum > inputSum {
+		return nil, fmt.Errorf("outputs (%d) exceed inputs (%d)", outputSum, inputSum)
+	}
+	originalFee := inputSum - outputSum
+	if NeededFeeAbsolutSats(TxVSize(original), newFeeRate) <= originalFee {
+		return nil, ErrFeeRateNotHigher
+	}
+
+	// only confirmed utxos can be added (BIP125 rule 2), outputs of the original can not be spent by its replacement
+	var candidates []*OwnedUTXO
+	for _, utxo := range w.UTXOs {
+		if utxo.State != StateUnspent || utxo.Txid == txid {
+			continue
+		}
+		candidates = append(candidates, utxo)
+	}
+
+	recipients := make([]Recipient, len(sent.Recipients))
+	for i, recipient := range sent.Recipients {
+		recipients[i] = recipient
+	}
+
+	// the fee rate is raised if newFeeRate does not cover the minimum fee of a replacement
+	feeRate := newFeeRate
+	var selection *Selection
+	for {
+		ctx, err := newSelectionContext(&SelectionRequest{
+			UTXOs:           candidates,
+			MustInclude:     inputs,
+			Recipients:      recipients,
+			FeeRate:         feeRate,
+			MinChangeAmount: w.DustPolicy().ChangeThreshold(),
+			ChainParams:     chainParams,
+		})
+		if err != nil {
+			return nil, err
+		}
+		selection, err = ctx.selectInOrder(ctx.candidates())
+		if err != nil {
+			logging.L.Err(err).Msg("failed to select coins for replacement")
+			return nil, err
+		}
+
+		replacementVSize := weightToVSize(ctx.weight(len(selection.UTXOs), selection.Change > 0))
+		if selection.Fee >= originalFee+NeededFeeAbsolutSats(replacementVSize, IncrementalRelayFeeRate) {
+			break
+		}
+		// terminates, the rate grows with every round and the selections have a finite number of sizes
+		feeRate = minReplacementFeeRate(originalFee, replacementVSize)
+		logging.L.Debug().
+			Stringer("fee_rate", feeRate).
+			Msg("raised fee rate to pay the minimum replacement fee")
+	}
+
+	finalTx, err := w.signSelection(recipients, selection, feeRate, chainParams)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = finalTx.Serialize(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, utxo := range selection.UTXOs[len(inputs):] {
+		utxo.State = inputs[0].State
+	}
+
+	replacement := w.recordSentTransaction(finalTx, recipients, selection, feeRate)
+	if replacement != nil {
+		sent.ReplacedBy = replacement.Txid
+	}
+	if sent.State == TxStatePending {
+		// the change of the original will never confirm, the replacement is tracked instead
+		w.retirePending(sent, TxStateReplaced)
+		err = w.markPending(replacement, selection.UTXOs)
+		if err != nil {
+			return nil, err
+		}
+	} else if replacement != nil {
+		sent.State = TxStateReplaced
+	}
+
+	logging.L.Debug().
+		Hex("original", txid[:]).
+		Uint64("original_fee", originalFee).
+		Uint64("fee", selection.Fee).
+		Msg("created replacement transaction")
+
+	return buf.Bytes(), nil
+}
+
+// minReplacementFeeRate returns the lowest fee rate at which a replacement of vSize pays
+// originalFee plus its own size at IncrementalRelayFeeRate
+func minReplacementFeeRate(originalFee uint64, vSize int64) types.FeeRate {
+	satPerKvB := (originalFee*1000 + uint64(vSize) - 1) / uint64(vSize)
+	return types.FeeRateFromSatPerKvB(satPerKvB + IncrementalRelayFeeRate.SatPerKvB())
+}
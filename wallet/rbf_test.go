@@ -0,0 +1,165 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/setavenger/blindbit-lib/types"
+)
+
+func TestSignalsRBF(t *testing.T) {
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+	if SignalsRBF(tx) {
+		t.Fatal("final sequence signals rbf")
+	}
+	tx.TxIn[0].Sequence = wire.MaxTxInSequenceNum - 1
+	if SignalsRBF(tx) {
+		t.Fatal("sequence for lock time signals rbf")
+	}
+	tx.TxIn[0].Sequence = RBFSequence
+	if !SignalsRBF(tx) {
+		t.Fatal("rbf sequence does not signal rbf")
+	}
+}
+
+func TestBumpFee(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 100_000)
+	original := sendPending(t, w, recipient, 30_000)
+	originalTx := decodeTestTx(t, original.RawTx)
+
+	feeRate := types.FeeRateFromSatPerVByte(10)
+	raw, err := w.BumpFee(original.Txid, feeRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := decodeTestTx(t, raw)
+	if len(tx.TxIn) != 1 || tx.TxIn[0].PreviousOutPoint != originalTx.TxIn[0].PreviousOutPoint {
+		t.Fatal("replacement does not conflict with the original")
+	}
+	_, prevOuts, sum := testPrevOuts(t, w, tx)
+	verifyTestTx(t, tx, prevOuts)
+	if err = VerifyFeeRate(tx, sum, feeRate, 0); err != nil {
+		t.Fatal(err)
+	}
+	fee := sum - sumTestOutputs(tx)
+	if minFee := original.Fee + NeededFeeAbsolutSats(TxVSize(tx), IncrementalRelayFeeRate); fee < minFee {
+		t.Fatalf("fee %d below %d", fee, minFee)
+	}
+
+	if found := scanTestTx(t, recipient, w, tx); len(found) != 1 {
+		t.Fatalf("recipient found %d outputs", len(found))
+	}
+
+	replacement := w.FindSentTransaction(testTxid(tx))
+	if replacement == nil || replacement.State != TxStatePending || replacement.Fee != fee {
+		t.Fatalf("replacement %+v", replacement)
+	}
+	if original.State != TxStateReplaced || original.ReplacedBy != replacement.Txid {
+		t.Fatalf("original state %s replaced by %x", original.State, original.ReplacedBy)
+	}
+	// only the change of the replacement is tracked
+	for _, outpoint := range original.ChangeOutputs {
+		if w.findOutPoint(outpoint) != nil {
+			t.Fatalf("change %s of the original is kept", outpoint)
+		}
+	}
+	if len(replacement.ChangeOutputs) != 1 || w.findOutPoint(replacement.ChangeOutputs[0]) == nil {
+		t.Fatalf("change of the replacement %v", replacement.ChangeOutputs)
+	}
+
+	if _, err = w.BumpFee(original.Txid, types.FeeRateFromSatPerVByte(20)); !errors.Is(err, ErrTransactionReplaced) {
+		t.Fatalf("bumped the replaced transaction: %v", err)
+	}
+	// the replacement can be replaced again
+	if _, err = w.BumpFee(replacement.Txid, types.FeeRateFromSatPerVByte(20)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBumpFeeAddsInputs(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 50_000)
+	original := sendPending(t, w, recipient, 49_000)
+	added := fundTestWallet(t, w, 2, 60_000)
+
+	raw, err := w.BumpFee(original.Txid, types.FeeRateFromSatPerVByte(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := decodeTestTx(t, raw)
+	if len(tx.TxIn) != 2 {
+		t.Fatalf("%d inputs", len(tx.TxIn))
+	}
+	_, prevOuts, _ := testPrevOuts(t, w, tx)
+	verifyTestTx(t, tx, prevOuts)
+	// the added input is spent by the pending replacement
+	if added.State != StateUnconfirmedSpent {
+		t.Fatalf("added input state %s", added.State)
+	}
+}
+
+// A fee rate which does not pay the minimum replacement fee is raised
+func TestBumpFeeRaisesToMinimumFee(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 100_000)
+	original := sendPending(t, w, recipient, 30_000)
+
+	// above the 2 sat/vB of the original but below the incremental relay fee on top
+	feeRate := types.FeeRateFromSatPerVByte(2.5)
+	raw, err := w.BumpFee(original.Txid, feeRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := decodeTestTx(t, raw)
+	_, prevOuts, sum := testPrevOuts(t, w, tx)
+	verifyTestTx(t, tx, prevOuts)
+	fee := sum - sumTestOutputs(tx)
+	if minFee := original.Fee + NeededFeeAbsolutSats(TxVSize(tx), IncrementalRelayFeeRate); fee < minFee {
+		t.Fatalf("fee %d below %d", fee, minFee)
+	}
+	replacement := w.FindSentTransaction(testTxid(tx))
+	if replacement == nil || !feeRate.Less(replacement.FeeRate) {
+		t.Fatalf("fee rate was not raised: %+v", replacement)
+	}
+	if err = VerifyFeeRate(tx, sum, replacement.FeeRate, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBumpFeeErrors(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 100_000)
+	sent := sendPending(t, w, recipient, 30_000)
+
+	if _, err := w.BumpFee([32]byte{1}, types.FeeRateFromSatPerVByte(10)); !errors.Is(err, ErrSentTransactionNotFound) {
+		t.Errorf("unknown txid: %v", err)
+	}
+	if _, err := w.BumpFee(sent.Txid, types.FeeRateFromSatPerVByte(2)); !errors.Is(err, ErrFeeRateNotHigher) {
+		t.Errorf("same fee rate: %v", err)
+	}
+	if _, err := w.BumpFee(sent.Txid, types.FeeRateFromSatPerVByte(1000)); !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("insufficient funds: %v", err)
+	}
+
+	watchOnly := newTestWatchOnlyWallet(t, 1)
+	if _, err := watchOnly.BumpFee(sent.Txid, types.FeeRateFromSatPerVByte(10)); !errors.Is(err, ErrWatchOnly) {
+		t.Errorf("watch-only: %v", err)
+	}
+
+	tx := decodeTestTx(t, sent.RawTx)
+	for _, txIn := range tx.TxIn {
+		txIn.Sequence = wire.MaxTxInSequenceNum
+	}
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	sent.RawTx = buf.Bytes()
+	if _, err := w.BumpFee(sent.Txid, types.FeeRateFromSatPerVByte(10)); !errors.Is(err, ErrNotReplaceable) {
+		t.Errorf("final sequence: %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package wallet
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+
+	"github.com/setavenger/blindbit-lib/logging"
+)
+
+// remoteSignerService is the name the signer is served under, see ServeSigner
+const remoteSignerService = "Signer"
+
+// RemoteSignRequest is the request of RemoteSigner.SignTaproot
+type RemoteSignRequest struct {
+	Tweak   [32]byte
+	SigHash [32]byte
+}
+
+// RemoteEcdhRequest is the request of RemoteSigner.EcdhShare
+type RemoteEcdhRequest struct {
+	Tweaks     [][32]byte
+	ScanPubKey [33]byte
+}
+
+// RemoteSigner is a Signer forwarding all requests to a signer process, which serves its signer with ServeSigner.
+// Meant for local sockets, e.g. a unix socket only the wallet and the signer process can access.
+// The connection is neither authenticated nor encrypted.
+type RemoteSigner struct {
+	client *rpc.Client
+}
+
+// DialRemoteSigner connects to the signer process, network and address as for net.Dial.
+// Example: DialRemoteSigner("unix", "/run/blindbit/signer.sock")
+func DialRemoteSigner(network, address string) (*RemoteSigner, error) {
+	client, err := rpc.Dial(network, address)
+	if err != nil {
+		logging.L.Err(err).Str("address", address).Msg("failed to connect to remote signer")
+		return nil, err
+	}
+	return &RemoteSigner{client: client}, nil
+}
+
+// Close closes the connection to the signer process
+func (s *RemoteSigner) Close() error {
+	return s.client.Close()
+}
+
+func (s *RemoteSigner) SpendPubKey() ([33]byte, error) {
+	var spendPubKey [33]byte
+	err := s.client.Call(remoteSignerService+".SpendPubKey", struct{}{}, &spendPubKey)
+	if err != nil {
+		return [33]byte{}, err
+	}
+	return spendPubKey, nil
+}
+
+func (s *RemoteSigner) SignTaproot(tweak [32]byte, sigHash [32]byte) ([64]byte, error) {
+	var signature [64]byte
+	err := s.client.Call(remoteSignerService+".SignTaproot", &RemoteSignRequest{
+		Tweak:   tweak,
+		SigHash: sigHash,
+	}, &signature)
+	if err != nil {
+		return [64]byte{}, err
+	}
+	return signature, nil
+}
+
+func (s *RemoteSigner) EcdhShare(tweaks [][32]byte, scanPubKey [33]byte) (*EcdhShare, error) {
+	var share EcdhShare
+	err := s.client.Call(remoteSignerService+".EcdhShare", &RemoteEcdhRequest{
+		Tweaks:     tweaks,
+		ScanPubKey: scanPubKey,
+	}, &share)
+	if err != nil {
+		return nil, err
+	}
+	// the DLEQ proof is verified against the inputs by ComputeSilentPaymentOutputs
+	return &share, nil
+}
+
+// ServeSigner answers the requests of RemoteSigners connecting to listener with signer.
+// Blocks until the listener is closed.
+//
+// Example for the signer process:
+//
+//	listener, err := net.Listen("unix", "/run/blindbit/signer.sock")
+//	...
+//	err = wallet.ServeSigner(listener, wallet.NewKeySigner(spendSecret))
+func ServeSigner(listener net.Listener, signer Signer) error {
+	if signer == nil {
+		return errors.New("no signer given")
+	}
+
+	server := rpc.NewServer()
+	err := server.RegisterName(remoteSignerService, &signerService{signer: signer})
+	if err != nil {
+		return err
+	}
+
+	server.Accept(listener)
+	return nil
+}
+
+// signerService exposes a Signer with the method signatures net/rpc requires
+type signerService struct {
+	signer Signer
+}
+
+func (s *signerService) SpendPubKey(_ struct{}, reply *[33]byte) error {
+	spendPubKey, err := s.signer.SpendPubKey()
+	if err != nil {
+		return err
+	}
+	*reply = spendPubKey
+	return nil
+}
+
+func (s *signerService) SignTaproot(req *RemoteSignRequest, reply *[64]byte) error {
+	signature, err := s.signer.SignTaproot(req.Tweak, req.SigHash)
+	if err != nil {
+		logging.L.Err(err).Msg("failed to sign")
+		return err
+	}
+	*reply = signature
+	return nil
+}
+
+func (s *signerService) EcdhShare(req *RemoteEcdhRequest, reply *EcdhShare) error {
+	share, err := s.signer.EcdhShare(req.Tweaks, req.ScanPubKey)
+	if err != nil {
+		logging.L.Err(err).Msg("failed to compute ecdh share")
+		return err
+	}
+	*reply = *share
+	return nil
+}
@@ -3,28 +3,23 @@ package wallet
 import (
 	"bytes"
 	"fmt"
-	"log"
 
-	"github.com/btcsuite/btcd/btcec/v2"
-	"github.com/btcsuite/btcd/btcec/v2/schnorr"
 	"github.com/btcsuite/btcd/btcutil"
-	"github.com/btcsuite/btcd/btcutil/psbt"
-	"github.com/btcsuite/btcd/btcutil/txsort"
 	"github.com/btcsuite/btcd/chaincfg"
-	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/setavenger/blindbit-lib/logging"
 	"github.com/setavenger/blindbit-lib/types"
-	"github.com/setavenger/blindbit-lib/utils"
 	"github.com/setavenger/go-bip352"
 )
 
-// SendToRecipients sends Bitcoin to the given recipients
+// SendToRecipients sends Bitcoin to the given recipients from the unspent utxos of wallet.
+// feeRate used to be a number in sat/vB, convert such rates with types.FeeRateFromSatPerVByte.
 func SendToRecipients(
 	wallet *Wallet,
 	recipients []Recipient,
-	feeRate uint32,
+	feeRate types.FeeRate,
+	opts ...SendOption,
 ) (
 	[]byte,
 	error,
@@ -48,157 +43,104 @@ func SendToRecipients(
 	return wallet.SendToRecipients(
 		selectorRecipients,
 		utxos,
-		int64(feeRate),
-		546,   // Minimum change amount
+		feeRate,
+		wallet.DustPolicy().ChangeThreshold(),
 		false, // Don't mark as spent
 		false, // Don't use unconfirmed spent
+		opts...,
 	)
 }
 
+// SendToRecipients funds, signs and records a transaction paying recipients from utxos at feeRate.
+// If markSpent is set the inputs are marked as spent by the pending transaction.
+// feeRate used to be a number in sat/vB, convert such rates with types.FeeRateFromSatPerVByte.
 func (w *Wallet) SendToRecipients(
 	recipients []Recipient,
 	utxos UtxoCollection,
-	feeRate int64,
+	feeRate types.FeeRate,
 	minChangeAmount uint64,
 	markSpent, useSpentUnconfirmed bool,
+	opts ...SendOption,
 ) (
 	txBytes []byte,
 	err error,
 ) {
-	// Get chain parameters
-	var chainParams *chaincfg.Params
-	switch w.Network {
-	case types.NetworkMainnet:
-		chainParams = &chaincfg.MainNetParams
-	case types.NetworkTestnet:
-		chainParams = &chaincfg.TestNet3Params
-	case types.NetworkSignet:
-		chainParams = &chaincfg.SigNetParams
-	default:
-		return nil, fmt.Errorf("unsupported network: %s", w.Network)
+	if w.IsWatchOnly() {
+		return nil, ErrWatchOnly
 	}
 
-	selector := NewFeeRateCoinSelector(utxos, minChangeAmount, recipients, chainParams)
-
-	selectedUTXOs, changeAmount, err := selector.CoinSelect(uint32(feeRate))
+	proposal, err := w.ProposeTransaction(recipients, utxos, feeRate, minChangeAmount, opts...)
 	if err != nil {
-		logging.L.Err(err).Msg("failed to do coin select")
 		return nil, err
 	}
 
-	// todo: remove debug line
-	fmt.Println("change:", changeAmount)
-
-	// vins is the final selection of coins, which can then be used to derive silentPayment Outputs
-	var vins = make([]*bip352.Vin, len(selectedUTXOs))
-	for i, utxo := range selectedUTXOs {
-		vin := ConvertOwnedUTXOIntoVin(utxo)
-		err := bip352.AddPrivateKeys(vin.SecretKey, w.SecretKeySpend.ToArrayPtr())
-		if err != nil {
-			return nil, err
-		}
-		vins[i] = &vin
-	}
-
-	// now we need the difference between the inputs and outputs so that we can assign a value for change
-	var sumAllInputs uint64
-	for _, vin := range vins {
-		sumAllInputs += vin.Amount
-	}
-
-	if changeAmount > 0 {
-		// change exists, and it should be greater than the MinChangeAmount
-		recipients = append(recipients, &RecipientImpl{
-			Address: w.ChangeAddress(),
-			Amount:  changeAmount,
-		})
-	}
-
-	// extract the ScriptPubKeys of the SP recipients with the selected txInputs
-	recipients, err = ParseRecipients(recipients, vins, chainParams)
+	finalTx, err := w.signProposal(proposal)
 	if err != nil {
 		return nil, err
 	}
 
-	err = sanityCheckRecipientsForSending(recipients)
-	if err != nil {
-		return nil, err
-	}
-
-	packet, err := CreateUnsignedPsbt(recipients, vins)
+	var buf bytes.Buffer
+	err = finalTx.Serialize(&buf)
 	if err != nil {
 		return nil, err
 	}
 
-	err = SignPsbt(packet, vins)
-	if err != nil {
-		return nil, err
-	}
+	sent := w.recordSentTransaction(finalTx, recipients, proposal.selection(), feeRate)
 
-	err = psbt.MaybeFinalizeAll(packet)
-	if err != nil {
-		panic(err) // todo remove panic
-	}
-
-	finalTx, err := psbt.Extract(packet)
-	if err != nil {
-		panic(err) // todo remove panic
-	}
-
-	var sumAllOutputs uint64
-	for _, recipient := range recipients {
-		sumAllOutputs += recipient.GetAmount()
+	if markSpent {
+		// now that everything worked mark as spent if desired, the change is tracked until it confirms
+		err = w.markPending(sent, proposal.Inputs)
+		if err != nil {
+			return nil, err
+		}
 	}
-	// vSize := mempool.GetTxVirtualSize(btcutil.NewTx(finalTx))
-	// actualFee := sumAllInputs - sumAllOutputs
-	// actualFeeRate := float64(actualFee) / float64(vSize)
 
-	// errorTerm := 0.25 // todo make variable
-	// if actualFeeRate > float64(feeRate)+errorTerm {
-	// 	err = fmt.Errorf("actual fee rate deviates to strong from desired fee rate: %f > %d", actualFeeRate, feeRate)
-	// 	return nil, err
-	// }
-	//
-	// if actualFeeRate < float64(feeRate)-errorTerm {
-	// 	err = fmt.Errorf("actual fee rate deviates to strong from desired fee rate: %f < %d", actualFeeRate, feeRate)
-	// 	return nil, err
-	// }
-
-	var buf bytes.Buffer
-	err = finalTx.Serialize(&buf)
-	if err != nil {
-		return nil, err
-	}
+	return buf.Bytes(), err
+}
 
-	if markSpent {
-		var found int
-		// now that everything worked mark as spent if desired
-		for _, vin := range vins {
-			vinOutpoint, err := utils.SerialiseToOutpoint(vin.Txid, vin.Vout)
+// markSpent marks the wallet utxos with the outpoints of spent as StateUnconfirmedSpent
+func (w *Wallet) markSpent(spent []*OwnedUTXO) error {
+	var found int
+	for _, spentUTXO := range spent {
+		vinOutpoint, err := spentUTXO.SerialiseToOutpoint()
+		if err != nil {
+			logging.L.Err(err).Msg("failed serialise vin outpoint")
+			return err
+		}
+		for _, utxo := range w.UTXOs {
+			utxoOutpoint, err := utxo.SerialiseToOutpoint()
 			if err != nil {
-				logging.L.Err(err).Msg("failed serialise vin outpoint")
-				return nil, err
+				logging.L.Err(err).Msg("failed serialise wallet utxo outpoint")
+				return err
 			}
-			for _, utxo := range w.UTXOs {
-				utxoOutpoint, err := utxo.SerialiseToOutpoint()
-				if err != nil {
-					logging.L.Err(err).Msg("failed serialise wallet utxo outpoint")
-					return nil, err
-				}
-				if bytes.Equal(vinOutpoint[:], utxoOutpoint[:]) {
-					utxo.State = StateUnconfirmedSpent
-					found++
-					logging.L.Debug().Hex("outpoint", utxoOutpoint[:]).Msg("internally marked as unconfirmed spent")
-				}
+			if bytes.Equal(vinOutpoint[:], utxoOutpoint[:]) {
+				utxo.State = StateUnconfirmedSpent
+				found++
+				logging.L.Debug().Hex("outpoint", utxoOutpoint[:]).Msg("internally marked as unconfirmed spent")
 			}
 		}
-		if found != len(vins) {
-			err = fmt.Errorf("we could not mark enough utxos as spent. marked %d, needed %d", found, len(vins))
-			return nil, err
-		}
 	}
+	if found != len(spent) {
+		return fmt.Errorf("we could not mark enough utxos as spent. marked %d, needed %d", found, len(spent))
+	}
+	return nil
+}
 
-	return buf.Bytes(), err
+// signSelection builds the transaction for the selection, computes the silent payment outputs and signs it.
+// The fee of the final transaction is checked against feeRate.
+func (w *Wallet) signSelection(
+	recipients []Recipient,
+	selection *Selection,
+	feeRate types.FeeRate,
+	chainParams *chaincfg.Params,
+) (
+	*wire.MsgTx, error,
+) {
+	proposal, err := w.newProposal(recipients, selection, feeRate, chainParams)
+	if err != nil {
+		return nil, err
+	}
+	return w.signProposal(proposal)
 }
 
 // Taken from blindbitd
@@ -247,12 +189,12 @@ func ParseRecipients(
 		if !isSP {
 			address, err := btcutil.DecodeAddress(recipient.GetAddress(), chainParams)
 			if err != nil {
-				log.Printf("Failed to decode address: %v", err)
-				return nil, err
+				logging.L.Err(err).Str("address", recipient.GetAddress()).Msg("failed to decode address")
+				return nil, fmt.Errorf("invalid recipient address %s: %w", recipient.GetAddress(), err)
 			}
 			scriptPubKey, err := txscript.PayToAddrScript(address)
 			if err != nil {
-				log.Printf("Failed to create scriptPubKey: %v", err)
+				logging.L.Err(err).Str("address", recipient.GetAddress()).Msg("failed to create script pub key")
 				return nil, err
 			}
 			newRecipient := &RecipientImpl{
@@ -290,143 +232,6 @@ func ParseRecipients(
 	return newRecipients, nil
 }
 
-// sanityCheckRecipientsForSending
-// checks whether any of the Recipients lacks the necessary information to construct the transaction.
-// required for every recipient: Recipient.PkScript and Recipient.Amount
-func sanityCheckRecipientsForSending(recipients []Recipient) error {
-	for _, recipient := range recipients {
-		if (recipient.GetPkScript() == nil || len(recipient.GetPkScript()) == 0) || recipient.GetAmount() == 0 {
-			// if we choose a lot of logging in this module/program we could log the incomplete recipient here
-			return fmt.Errorf("incomplete recipient %s", recipient.GetAddress())
-		}
-	}
-	return nil
-}
-
-func CreateUnsignedPsbt(recipients []Recipient, vins []*bip352.Vin) (*psbt.Packet, error) {
-	var txOutputs []*wire.TxOut
-	for _, recipient := range recipients {
-		txOutputs = append(txOutputs, wire.NewTxOut(int64(recipient.GetAmount()), recipient.GetPkScript()))
-	}
-
-	var txInputs []*wire.TxIn
-	for _, vin := range vins {
-		hash, err := chainhash.NewHash(bip352.ReverseBytesCopy(vin.Txid[:]))
-		if err != nil {
-			return nil, err
-		}
-		prevOut := wire.NewOutPoint(hash, vin.Vout)
-		txInputs = append(txInputs, wire.NewTxIn(prevOut, nil, nil))
-	}
-
-	unsignedTx := &wire.MsgTx{
-		Version: 2,
-		TxIn:    txInputs,
-		TxOut:   txOutputs,
-	}
-
-	packet := &psbt.Packet{
-		UnsignedTx: txsort.Sort(unsignedTx),
-	}
-
-	return packet, nil
-}
-
-// SignPsbt
-// fails if inputs in packet have a different order than vins
-func SignPsbt(packet *psbt.Packet, vins []*bip352.Vin) error {
-	if len(packet.UnsignedTx.TxIn) != len(vins) {
-		return fmt.Errorf("mismatch with txIns (%d) and vins (%d)", len(packet.UnsignedTx.TxIn), len(vins))
-	}
-
-	prevOutsForFetcher := make(map[wire.OutPoint]*wire.TxOut, len(vins))
-
-	// simple map to find correct vin for prevOutsForFetcher
-	vinMap := make(map[string]bip352.Vin, len(vins))
-	for _, v := range vins {
-		vinMap[fmt.Sprintf("%x:%d", v.Txid, v.Vout)] = *v
-	}
-
-	for i := 0; i < len(vins); i++ {
-		outpoint := packet.UnsignedTx.TxIn[i].PreviousOutPoint
-		key := fmt.Sprintf("%x:%d", bip352.ReverseBytesCopy(outpoint.Hash[:]), outpoint.Index)
-		vin, ok := vinMap[key]
-		if !ok {
-			err := fmt.Errorf("a vin was not found in the map, should not happen. upstream error in psbt and vin selection and or construction")
-			return err
-		}
-		prevOutsForFetcher[outpoint] = wire.NewTxOut(int64(vin.Amount), vin.ScriptPubKey)
-	}
-
-	multiFetcher := txscript.NewMultiPrevOutFetcher(prevOutsForFetcher)
-
-	sigHashes := txscript.NewTxSigHashes(packet.UnsignedTx, multiFetcher)
-
-	var pInputs []psbt.PInput
-
-	for iOuter, input := range packet.UnsignedTx.TxIn {
-		signatureHash, err := txscript.CalcTaprootSignatureHash(
-			sigHashes, txscript.SigHashDefault, packet.UnsignedTx, iOuter, multiFetcher,
-		)
-		if err != nil {
-			panic(err)
-		}
-
-		pInput, err := matchAndSign(input, signatureHash, vins)
-		if err != nil {
-			panic(err)
-		}
-
-		pInputs = append(pInputs, pInput)
-
-	}
-
-	packet.Inputs = pInputs
-
-	return nil
-}
-
-func matchAndSign(
-	input *wire.TxIn,
-	signatureHash []byte,
-	vins []*bip352.Vin,
-) (
-	psbt.PInput,
-	error,
-) {
-	var psbtInput psbt.PInput
-
-	for _, vin := range vins {
-		if bytes.Equal(input.PreviousOutPoint.Hash[:], bip352.ReverseBytesCopy(vin.Txid[:])) &&
-			input.PreviousOutPoint.Index == vin.Vout {
-			privKey, pk := btcec.PrivKeyFromBytes(vin.SecretKey[:])
-
-			if pk.Y().Bit(0) == 1 {
-				newBytes := privKey.Key.Negate().Bytes()
-				privKey, _ = btcec.PrivKeyFromBytes(newBytes[:])
-			}
-			signature, err := schnorr.Sign(privKey, signatureHash)
-			if err != nil {
-				panic(err)
-			}
-
-			var witnessBytes bytes.Buffer
-			err = psbt.WriteTxWitness(&witnessBytes, [][]byte{signature.Serialize()})
-			if err != nil {
-				panic(err)
-			}
-
-			return psbt.PInput{
-				WitnessUtxo:        wire.NewTxOut(int64(vin.Amount), vin.ScriptPubKey),
-				SighashType:        txscript.SigHashDefault,
-				FinalScriptWitness: witnessBytes.Bytes(),
-			}, err
-		}
-	}
-
-	return psbtInput, fmt.Errorf("no matching vin found for txInput")
-
-}
 func ConvertSPRecipient(recipient *bip352.Recipient) *RecipientImpl {
 	return &RecipientImpl{
 		Address:  recipient.SilentPaymentAddress,
@@ -435,13 +240,16 @@ func ConvertSPRecipient(recipient *bip352.Recipient) *RecipientImpl {
 	}
 }
 
+// ConvertOwnedUTXOIntoVin
+// the SecretKey of the vin is a copy of the utxo tweak, so the spend key can be added without modifying the utxo (see Signer)
 func ConvertOwnedUTXOIntoVin(utxo *OwnedUTXO) bip352.Vin {
+	secretKey := utxo.PrivKeyTweak
 	vin := bip352.Vin{
 		Txid:         utxo.Txid,
 		Vout:         utxo.Vout,
 		Amount:       utxo.Amount,
 		ScriptPubKey: append([]byte{0x51, 0x20}, utxo.PubKey[:]...),
-		SecretKey:    &utxo.PrivKeyTweak,
+		SecretKey:    &secretKey,
 		Taproot:      true,
 	}
 	return vin
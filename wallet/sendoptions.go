@@ -0,0 +1,112 @@
+package wallet
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/blindbit-lib/types"
+)
+
+// SendOption configures how a transaction is built
+type SendOption func(*sendOptions)
+
+type sendOptions struct {
+	coinSelector    CoinSelector
+	longTermFeeRate types.FeeRate
+	mustInclude     []wire.OutPoint
+	mustExclude     []wire.OutPoint
+	// err is returned when the options are used, for options that can not be applied
+	err error
+}
+
+func newSendOptions(opts []SendOption) *sendOptions {
+	options := &sendOptions{
+		coinSelector: &FeeRateCoinSelector{},
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// WithCoinSelector sets the CoinSelector, defaults to FeeRateCoinSelector
+func WithCoinSelector(selector CoinSelector) SendOption {
+	return func(o *sendOptions) {
+		o.coinSelector = selector
+	}
+}
+
+// WithStrategy uses the CoinSelector of one of the built-in strategies
+func WithStrategy(strategy CoinSelectionStrategy) SendOption {
+	return func(o *sendOptions) {
+		o.coinSelector, o.err = strategy.Selector()
+	}
+}
+
+// WithLongTermFeeRate sets the fee rate the waste of a selection is measured against.
+// Defaults to the fee rate of the transaction.
+func WithLongTermFeeRate(feeRate types.FeeRate) SendOption {
+	return func(o *sendOptions) {
+		o.longTermFeeRate = feeRate
+	}
+}
+
+// selectCoins runs the configured CoinSelector
+func (w *Wallet) selectCoins(
+	recipients []Recipient,
+	utxos UtxoCollection,
+	feeRate types.FeeRate,
+	minChangeAmount uint64,
+	options *sendOptions,
+) (
+	*Selection, error,
+) {
+	if options.err != nil {
+		return nil, options.err
+	}
+
+	chainParams, ok := types.NetworkParams[w.Network]
+	if !ok {
+		return nil, fmt.Errorf("unsupported network: %s", w.Network)
+	}
+	if feeRate.IsZero() {
+		return nil, ErrInvalidFeeRate
+	}
+
+	dustPolicy := w.DustPolicy()
+	err := dustPolicy.CheckRecipients(recipients, chainParams)
+	if err != nil {
+		return nil, err
+	}
+	// change below the dust threshold is added to the fee
+	minChangeAmount = max(minChangeAmount, dustPolicy.ChangeThreshold())
+
+	utxos, mustInclude, err := w.applyCoinControl(utxos, options)
+	if err != nil {
+		return nil, err
+	}
+
+	selection, err := options.coinSelector.SelectCoins(&SelectionRequest{
+		UTXOs:           utxos,
+		MustInclude:     mustInclude,
+		Recipients:      recipients,
+		FeeRate:         feeRate,
+		LongTermFeeRate: options.longTermFeeRate,
+		MinChangeAmount: minChangeAmount,
+		ChainParams:     chainParams,
+	})
+	if err != nil {
+		logging.L.Err(err).Msg("failed to do coin select")
+		return nil, err
+	}
+
+	logging.L.Debug().
+		Int("inputs", len(selection.UTXOs)).
+		Uint64("change", selection.Change).
+		Uint64("fee", selection.Fee).
+		Int64("waste", selection.Waste).
+		Msg("coins selected")
+
+	return selection, nil
+}
@@ -0,0 +1,247 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/blindbit-lib/types"
+	"github.com/setavenger/blindbit-lib/utils"
+	"github.com/setavenger/go-bip352"
+)
+
+// SentTransaction is a transaction created by the wallet.
+// The recipients are kept, silent payment outputs have to be derived again if the inputs change (see BumpFee).
+type SentTransaction struct {
+	Txid  [32]byte // same byte order as OwnedUTXO.Txid
+	RawTx []byte
+	// Recipients without the change output
+	Recipients []*RecipientImpl
+	// FeeRate the transaction was built with
+	FeeRate types.FeeRate
+	Fee     uint64
+	// ReplacedBy is the txid of the replacement, zero if the transaction was not replaced
+	ReplacedBy [32]byte
+
+	// State is advanced by Wallet.ReconcilePending once the transaction is pending.
+	// Zero for transactions recorded before states were introduced.
+	State     TxState
+	CreatedAt uint64 // unix timestamp
+	Height    uint64 // height of the block the transaction confirmed in, 0 if not confirmed
+	Inputs    []wire.OutPoint
+	// ChangeOutputs are the outputs back to the wallet, known once the transaction is pending
+	ChangeOutputs []wire.OutPoint
+}
+
+type sentRecipientJSON struct {
+	Address  string `json:"address"`
+	Amount   uint64 `json:"amount"`
+	PkScript string `json:"pk_script,omitempty"`
+}
+
+type sentTransactionJSON struct {
+	Txid       string              `json:"txid"`
+	RawTx      string              `json:"raw_tx"`
+	Recipients []sentRecipientJSON `json:"recipients"`
+	FeeRate    types.FeeRate       `json:"fee_rate"` // sat/kvB
+	Fee        uint64              `json:"fee"`
+	ReplacedBy string              `json:"replaced_by,omitempty"`
+
+	State         TxState  `json:"state,omitempty"`
+	CreatedAt     uint64   `json:"created_at,omitempty"`
+	Height        uint64   `json:"height,omitempty"`
+	Inputs        []string `json:"inputs,omitempty"`         // txid:vout
+	ChangeOutputs []string `json:"change_outputs,omitempty"` // txid:vout
+}
+
+func (s SentTransaction) MarshalJSON() ([]byte, error) {
+	recipients := make([]sentRecipientJSON, len(s.Recipients))
+	for i, recipient := range s.Recipients {
+		recipients[i] = sentRecipientJSON{
+			Address:  recipient.Address,
+			Amount:   recipient.Amount,
+			PkScript: hex.EncodeToString(recipient.PkScript),
+		}
+	}
+
+	var replacedBy string
+	if s.ReplacedBy != [32]byte{} {
+		replacedBy = hex.EncodeToString(s.ReplacedBy[:])
+	}
+
+	return json.Marshal(sentTransactionJSON{
+		Txid:       hex.EncodeToString(s.Txid[:]),
+		RawTx:      hex.EncodeToString(s.RawTx),
+		Recipients: recipients,
+		FeeRate:    s.FeeRate,
+		Fee:        s.Fee,
+		ReplacedBy: replacedBy,
+
+		State:         s.State,
+		CreatedAt:     s.CreatedAt,
+		Height:        s.Height,
+		Inputs:        outPointStrings(s.Inputs),
+		ChangeOutputs: outPointStrings(s.ChangeOutputs),
+	})
+}
+
+func (s *SentTransaction) UnmarshalJSON(data []byte) error {
+	var aux sentTransactionJSON
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	txid, err := hex.DecodeString(aux.Txid)
+	if err != nil {
+		return err
+	}
+	rawTx, err := hex.DecodeString(aux.RawTx)
+	if err != nil {
+		return err
+	}
+	var replacedBy [32]byte
+	if aux.ReplacedBy != "" {
+		replacedByBytes, err := hex.DecodeString(aux.ReplacedBy)
+		if err != nil {
+			return err
+		}
+		replacedBy = utils.ConvertToFixedLength32(replacedByBytes)
+	}
+
+	inputs, err := parseOutPoints(aux.Inputs)
+	if err != nil {
+		return err
+	}
+	changeOutputs, err := parseOutPoints(aux.ChangeOutputs)
+	if err != nil {
+		return err
+	}
+
+	recipients := make([]*RecipientImpl, len(aux.Recipients))
+	for i, recipient := range aux.Recipients {
+		pkScript, err := hex.DecodeString(recipient.PkScript)
+		if err != nil {
+			return err
+		}
+		recipients[i] = &RecipientImpl{
+			Address:  recipient.Address,
+			Amount:   recipient.Amount,
+			PkScript: pkScript,
+		}
+	}
+
+	*s = SentTransaction{
+		Txid:       utils.ConvertToFixedLength32(txid),
+		RawTx:      rawTx,
+		Recipients: recipients,
+		FeeRate:    aux.FeeRate,
+		Fee:        aux.Fee,
+		ReplacedBy: replacedBy,
+
+		State:         aux.State,
+		CreatedAt:     aux.CreatedAt,
+		Height:        aux.Height,
+		Inputs:        inputs,
+		ChangeOutputs: changeOutputs,
+	}
+	return nil
+}
+
+func outPointStrings(outpoints []wire.OutPoint) []string {
+	if len(outpoints) == 0 {
+		return nil
+	}
+	out := make([]string, len(outpoints))
+	for i, outpoint := range outpoints {
+		out[i] = outpoint.String()
+	}
+	return out
+}
+
+func parseOutPoints(values []string) ([]wire.OutPoint, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	out := make([]wire.OutPoint, len(values))
+	for i, value := range values {
+		outpoint, err := wire.NewOutPointFromString(value)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = *outpoint
+	}
+	return out, nil
+}
+
+// Tx deserialises the raw transaction
+func (s *SentTransaction) Tx() (*wire.MsgTx, error) {
+	tx := wire.NewMsgTx(2)
+	err := tx.Deserialize(bytes.NewReader(s.RawTx))
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// FindSentTransaction returns nil if the wallet did not create a transaction with txid
+func (w *Wallet) FindSentTransaction(txid [32]byte) *SentTransaction {
+	for _, sent := range w.SentTransactions {
+		if sent.Txid == txid {
+			return sent
+		}
+	}
+	return nil
+}
+
+// recordSentTransaction adds the transaction to Wallet.SentTransactions as TxStateCreated.
+// An existing record of the same transaction is reset.
+func (w *Wallet) recordSentTransaction(
+	tx *wire.MsgTx,
+	recipients []Recipient,
+	selection *Selection,
+	feeRate types.FeeRate,
+) *SentTransaction {
+	var buf bytes.Buffer
+	err := tx.Serialize(&buf)
+	if err != nil {
+		// serialising worked before, if it fails now we just don't keep the record
+		logging.L.Err(err).Msg("failed to serialise sent transaction")
+		return nil
+	}
+
+	sentRecipients := make([]*RecipientImpl, len(recipients))
+	for i, recipient := range recipients {
+		sentRecipients[i] = &RecipientImpl{
+			Address:  recipient.GetAddress(),
+			Amount:   recipient.GetAmount(),
+			PkScript: recipient.GetPkScript(),
+		}
+	}
+
+	inputs := make([]wire.OutPoint, len(tx.TxIn))
+	for i, txIn := range tx.TxIn {
+		inputs[i] = txIn.PreviousOutPoint
+	}
+
+	txHash := tx.TxHash()
+	sent := &SentTransaction{
+		Txid:       utils.ConvertToFixedLength32(bip352.ReverseBytesCopy(txHash[:])),
+		RawTx:      buf.Bytes(),
+		Recipients: sentRecipients,
+		FeeRate:    feeRate,
+		Fee:        selection.Fee,
+		State:      TxStateCreated,
+		CreatedAt:  uint64(time.Now().Unix()),
+		Inputs:     inputs,
+	}
+	if existing := w.FindSentTransaction(sent.Txid); existing != nil {
+		// the same transaction was created again, e.g. after it was abandoned
+		*existing = *sent
+		return existing
+	}
+	w.SentTransactions = append(w.SentTransactions, sent)
+	return sent
+}
@@ -0,0 +1,120 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/setavenger/blindbit-lib/types"
+	"github.com/setavenger/go-bip352"
+)
+
+var ErrSignerKeyMismatch = errors.New("signer holds a different spend key than the wallet")
+
+// Signer holds the secret spend key and signs for the outputs of the wallet,
+// the secret key of a silent payment output is the spend key plus the tweak found while scanning.
+// KeySigner keeps the spend key in memory, RemoteSigner forwards the requests to a separate process (see ServeSigner),
+// so the process building transactions never has to load the spend key.
+//
+// A Signer signs whatever it is asked to, restricting what gets signed is up to the signer process.
+type Signer interface {
+	// SpendPubKey returns the public key of the spend key held by the signer
+	SpendPubKey() ([33]byte, error)
+	// SignTaproot creates a BIP340 signature of sigHash with the spend key plus tweak.
+	// The secret key is negated if needed, as taproot keys are always even.
+	SignTaproot(tweak [32]byte, sigHash [32]byte) ([64]byte, error)
+	// EcdhShare computes the ECDH share of the summed secret keys of all tweaks with scanPubKey including its DLEQ proof.
+	// See CreateEcdhShare, a single tweak gives the share of one input.
+	EcdhShare(tweaks [][32]byte, scanPubKey [33]byte) (*EcdhShare, error)
+}
+
+// KeySigner is the in-process Signer holding the secret spend key
+type KeySigner struct {
+	spendSecret [32]byte
+}
+
+func NewKeySigner(spendSecret [32]byte) *KeySigner {
+	return &KeySigner{spendSecret: spendSecret}
+}
+
+func (s *KeySigner) SpendPubKey() ([33]byte, error) {
+	return *bip352.PubKeyFromSecKey(&s.spendSecret), nil
+}
+
+func (s *KeySigner) SignTaproot(tweak [32]byte, sigHash [32]byte) ([64]byte, error) {
+	secretKey, err := s.tweakedSecretKey(tweak)
+	if err != nil {
+		return [64]byte{}, err
+	}
+
+	privKey, pk := btcec.PrivKeyFromBytes(secretKey[:])
+	if pk.Y().Bit(0) == 1 {
+		newBytes := privKey.Key.Negate().Bytes()
+		privKey, _ = btcec.PrivKeyFromBytes(newBytes[:])
+	}
+
+	signature, err := schnorr.Sign(privKey, sigHash[:])
+	if err != nil {
+		return [64]byte{}, err
+	}
+	return [64]byte(signature.Serialize()), nil
+}
+
+func (s *KeySigner) EcdhShare(tweaks [][32]byte, scanPubKey [33]byte) (*EcdhShare, error) {
+	if len(tweaks) == 0 {
+		return nil, errors.New("no tweaks given")
+	}
+
+	secretKeys := make([][32]byte, len(tweaks))
+	for i, tweak := range tweaks {
+		secretKey, err := s.tweakedSecretKey(tweak)
+		if err != nil {
+			return nil, err
+		}
+		secretKeys[i] = evenSecretKey(secretKey)
+	}
+	return CreateEcdhShare(bip352.RecursiveAddPrivateKeys(secretKeys), scanPubKey)
+}
+
+// tweakedSecretKey computes spendSecret + tweak
+func (s *KeySigner) tweakedSecretKey(tweak [32]byte) ([32]byte, error) {
+	err := bip352.AddPrivateKeys(&tweak, &s.spendSecret)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return tweak, nil
+}
+
+// SetSigner lets the wallet sign with signer instead of SecretKeySpend,
+// e.g. a watch-only wallet with a RemoteSigner. A nil signer resets to SecretKeySpend.
+// Fails with ErrSignerKeyMismatch if the signer holds a different spend key.
+func (w *Wallet) SetSigner(signer Signer) error {
+	if signer == nil {
+		w.signer = nil
+		return nil
+	}
+
+	spendPubKey, err := signer.SpendPubKey()
+	if err != nil {
+		return fmt.Errorf("failed to get signer spend key: %w", err)
+	}
+	if spendPubKey != w.PubKeySpend.ToArray() {
+		return ErrSignerKeyMismatch
+	}
+
+	w.signer = signer
+	return nil
+}
+
+// Signer returns the signer set with SetSigner or a KeySigner for SecretKeySpend.
+// Returns nil if the wallet can not sign.
+func (w *Wallet) Signer() Signer {
+	if w.signer != nil {
+		return w.signer
+	}
+	if w.SecretKeySpend == (types.SecretKey{}) {
+		return nil
+	}
+	return NewKeySigner(w.SecretKeySpend.ToArray())
+}
@@ -0,0 +1,156 @@
+package wallet
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/setavenger/blindbit-lib/types"
+	"github.com/setavenger/go-bip352"
+)
+
+// serveTestSigner serves signer on a unix socket and returns a RemoteSigner connected to it
+func serveTestSigner(t *testing.T, signer Signer) *RemoteSigner {
+	t.Helper()
+	address := filepath.Join(t.TempDir(), "signer.sock")
+	listener, err := net.Listen("unix", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		_ = ServeSigner(listener, signer)
+	}()
+
+	remote, err := DialRemoteSigner("unix", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = remote.Close()
+	})
+	return remote
+}
+
+// testSigners returns the in-process and a remote signer for the spend key of w
+func testSigners(t *testing.T, w *Wallet) map[string]Signer {
+	t.Helper()
+	signer := NewKeySigner(w.SecretKeySpend.ToArray())
+	return map[string]Signer{"key": signer, "remote": serveTestSigner(t, signer)}
+}
+
+func TestSignerSignTaproot(t *testing.T) {
+	w := newTestWallet(t, 1)
+	utxos := fundTestUTXOs(t, w, 1, 4, 10_000)
+	sigHash := [32]byte{1, 2, 3}
+
+	for name, signer := range testSigners(t, w) {
+		spendPubKey, err := signer.SpendPubKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if spendPubKey != w.PubKeySpend.ToArray() {
+			t.Fatalf("%s: spend key %x", name, spendPubKey)
+		}
+
+		// the tweaked keys can have either parity, the signature has to verify for all outputs
+		for _, utxo := range utxos {
+			signature, err := signer.SignTaproot(utxo.PrivKeyTweak, sigHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pubKey, err := schnorr.ParsePubKey(utxo.PubKey[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := schnorr.ParseSignature(signature[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !parsed.Verify(sigHash[:], pubKey) {
+				t.Fatalf("%s: invalid signature for %x", name, utxo.PubKey)
+			}
+		}
+	}
+}
+
+func TestSignerEcdhShare(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	utxos := fundTestUTXOs(t, w, 1, 3, 10_000)
+
+	tweaks := make([][32]byte, len(utxos))
+	pubKeys := make([][33]byte, len(utxos))
+	for i, utxo := range utxos {
+		tweaks[i] = utxo.PrivKeyTweak
+		pubKeys[i] = [33]byte{0x02}
+		copy(pubKeys[i][1:], utxo.PubKey[:])
+	}
+	pubKeySum, err := bip352.SumPublicKeys(pubKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, signer := range testSigners(t, w) {
+		share, err := signer.EcdhShare(tweaks, recipient.PubKeyScan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		share.PubKey = *pubKeySum
+		if err = share.Verify(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if _, err = signer.EcdhShare(nil, recipient.PubKeyScan); err == nil {
+			t.Fatalf("%s: share without tweaks", name)
+		}
+	}
+}
+
+func TestSetSigner(t *testing.T) {
+	watchOnly, recipient := newTestWatchOnlyWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, watchOnly, 1, 50_000)
+	if watchOnly.Signer() != nil {
+		t.Fatal("watch-only wallet has a signer")
+	}
+
+	other := newTestWallet(t, 3)
+	if err := watchOnly.SetSigner(serveTestSigner(t, NewKeySigner(other.SecretKeySpend.ToArray()))); !errors.Is(err, ErrSignerKeyMismatch) {
+		t.Fatalf("error %v, want ErrSignerKeyMismatch", err)
+	}
+	if !watchOnly.IsWatchOnly() {
+		t.Fatal("mismatching signer was set")
+	}
+
+	// the watch-only wallet sends with the spend key held by another process
+	full := newTestWallet(t, 1)
+	if err := watchOnly.SetSigner(serveTestSigner(t, NewKeySigner(full.SecretKeySpend.ToArray()))); err != nil {
+		t.Fatal(err)
+	}
+	if watchOnly.IsWatchOnly() {
+		t.Fatal("wallet with signer is watch-only")
+	}
+	raw, err := watchOnly.SendToRecipients(
+		[]Recipient{&RecipientImpl{Address: recipient.Address(), Amount: 30_000}},
+		watchOnly.UnspentUTXOs(), types.FeeRateFromSatPerVByte(2), watchOnly.DustPolicy().ChangeThreshold(), false, false,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := decodeTestTx(t, raw)
+	_, prevOuts, _ := testPrevOuts(t, watchOnly, tx)
+	verifyTestTx(t, tx, prevOuts)
+	if found := scanTestTx(t, recipient, watchOnly, tx); len(found) != 1 {
+		t.Fatalf("recipient found %d outputs", len(found))
+	}
+
+	if err = watchOnly.SetSigner(nil); err != nil {
+		t.Fatal(err)
+	}
+	if !watchOnly.IsWatchOnly() {
+		t.Fatal("signer was not reset")
+	}
+}
@@ -0,0 +1,269 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sort"
+
+	"github.com/setavenger/blindbit-lib/logging"
+)
+
+// CoinSelectionStrategy selects one of the built-in CoinSelectors, e.g. to be chosen by the user
+type CoinSelectionStrategy int8
+
+const (
+	// StrategyInOrder adds utxos in the order of the wallet (FeeRateCoinSelector)
+	StrategyInOrder CoinSelectionStrategy = iota + 1
+	StrategyBranchAndBound
+	StrategyLargestFirst
+	StrategyOldestFirst
+	StrategyKnapsack
+	StrategyPrivacy
+)
+
+var ErrNoSingleLabelSelection = errors.New("no utxos of a single label can fund the transaction")
+
+var strategyNames = [...]string{"in_order", "branch_and_bound", "largest_first", "oldest_first", "knapsack", "privacy"}
+
+func (s CoinSelectionStrategy) String() string {
+	if s < StrategyInOrder || int(s) > len(strategyNames) {
+		return fmt.Sprintf("unknown(%d)", s)
+	}
+	return strategyNames[s-1]
+}
+
+// ParseCoinSelectionStrategy is the inverse of CoinSelectionStrategy.String
+func ParseCoinSelectionStrategy(name string) (CoinSelectionStrategy, error) {
+	for i, strategyName := range strategyNames {
+		if strategyName == name {
+			return CoinSelectionStrategy(i + 1), nil
+		}
+	}
+	return 0, fmt.Errorf("err: %s is not a valid coin selection strategy", name)
+}
+
+// Selector returns a new CoinSelector implementing the strategy
+func (s CoinSelectionStrategy) Selector() (CoinSelector, error) {
+	switch s {
+	case StrategyInOrder:
+		return &FeeRateCoinSelector{}, nil
+	case StrategyBranchAndBound:
+		return NewBranchAndBoundSelector(), nil
+	case StrategyLargestFirst:
+		return &LargestFirstSelector{}, nil
+	case StrategyOldestFirst:
+		return &OldestFirstSelector{}, nil
+	case StrategyKnapsack:
+		return &KnapsackSelector{}, nil
+	case StrategyPrivacy:
+		return &PrivacySelector{}, nil
+	default:
+		return nil, fmt.Errorf("unknown coin selection strategy %s", s)
+	}
+}
+
+// LargestFirstSelector adds the utxos with the highest amounts first.
+// Results in few inputs, but leaves small utxos behind.
+type LargestFirstSelector struct{}
+
+func (s *LargestFirstSelector) SelectCoins(req *SelectionRequest) (*Selection, error) {
+	ctx, err := newSelectionContext(req)
+	if err != nil {
+		return nil, err
+	}
+
+	utxos := ctx.candidates()
+	sort.SliceStable(utxos, func(i, j int) bool {
+		return utxos[i].Amount > utxos[j].Amount
+	})
+	return ctx.selectInOrder(utxos)
+}
+
+// OldestFirstSelector adds the utxos with the lowest OwnedUTXO.Timestamp first (FIFO).
+// UTXOs without timestamp are ordered by their height.
+type OldestFirstSelector struct{}
+
+func (s *OldestFirstSelector) SelectCoins(req *SelectionRequest) (*Selection, error) {
+	ctx, err := newSelectionContext(req)
+	if err != nil {
+		return nil, err
+	}
+
+	utxos := ctx.candidates()
+	sort.SliceStable(utxos, func(i, j int) bool {
+		if utxos[i].Timestamp != utxos[j].Timestamp {
+			return utxos[i].Timestamp < utxos[j].Timestamp
+		}
+		return utxos[i].Height < utxos[j].Height
+	})
+	return ctx.selectInOrder(utxos)
+}
+
+// DefaultKnapsackIterations is the number of random passes of the KnapsackSelector
+const DefaultKnapsackIterations = 1000
+
+// KnapsackSelector randomly includes utxos over several passes
+// and keeps the subset which overshoots the target the least.
+// Once with the target for a transaction with change and once without,
+// the resulting selection with the lower waste is returned.
+type KnapsackSelector struct {
+	// Iterations defaults to DefaultKnapsackIterations
+	Iterations int
+	// Rand is the source of randomness, defaults to the global source
+	Rand *rand.Rand
+}
+
+func (s *KnapsackSelector) SelectCoins(req *SelectionRequest) (*Selection, error) {
+	ctx, err := newSelectionContext(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var pool []*OwnedUTXO
+	var values []int64
+	for _, utxo := range ctx.candidates() {
+		value := ctx.effectiveValue(utxo)
+		if value <= 0 {
+			continue
+		}
+		pool = append(pool, utxo)
+		values = append(values, value)
+	}
+
+	targetNoChange := ctx.effectiveTarget()
+	targetChange := targetNoChange + int64(ctx.changeOutputFee()) + int64(req.MinChangeAmount)
+
+	var best *Selection
+	for _, target := range []int64{targetChange, targetNoChange} {
+		// MustInclude might already cover the target
+		var utxos []*OwnedUTXO
+		if target > 0 {
+			included := s.approximateBestSubset(values, target)
+			if included == nil {
+				continue
+			}
+			for i, ok := range included {
+				if ok {
+					utxos = append(utxos, pool[i])
+				}
+			}
+		}
+
+		// the effective values are rounded per input, the selection is evaluated with the exact fee
+		selection, ok := ctx.newSelection(utxos, true)
+		if !ok {
+			continue
+		}
+		if best == nil || selection.Waste < best.Waste {
+			best = selection
+		}
+	}
+
+	if best == nil {
+		return nil, ErrInsufficientFunds
+	}
+	return best, nil
+}
+
+// approximateBestSubset returns the subset with the smallest sum >= target, nil if none was found
+func (s *KnapsackSelector) approximateBestSubset(values []int64, target int64) []bool {
+	iterations := s.Iterations
+	if iterations <= 0 {
+		iterations = DefaultKnapsackIterations
+	}
+	intN := rand.IntN
+	if s.Rand != nil {
+		intN = s.Rand.IntN
+	}
+
+	var best []bool
+	var bestSum int64
+
+	included := make([]bool, len(values))
+	for i := 0; i < iterations && bestSum != target; i++ {
+		clear(included)
+		var sum int64
+		reached := false
+		// the first pass includes utxos randomly, the second pass fills up with the remaining ones
+		for pass := 0; pass < 2 && !reached; pass++ {
+			for j, value := range values {
+				if (pass == 0 && intN(2) == 0) || (pass == 1 && included[j]) {
+					continue
+				}
+				sum += value
+				included[j] = true
+				if sum < target {
+					continue
+				}
+				reached = true
+				if best == nil || sum < bestSum {
+					bestSum = sum
+					best = append(best[:0], included...)
+				}
+				// try to find a smaller overshoot without this utxo
+				sum -= value
+				included[j] = false
+			}
+		}
+	}
+
+	return best
+}
+
+// PrivacySelector never spends utxos received on different labels in the same transaction,
+// as that would link the labels (e.g. two invoices) to the same wallet.
+// UTXOs without label form their own group. MustInclude is added to every group as requested.
+// Each group is handed to the Inner selector on its own, the selection with the lowest waste is returned.
+type PrivacySelector struct {
+	// Inner selects within a group, defaults to BranchAndBoundSelector
+	Inner CoinSelector
+}
+
+func (s *PrivacySelector) SelectCoins(req *SelectionRequest) (*Selection, error) {
+	inner := s.Inner
+	if inner == nil {
+		inner = NewBranchAndBoundSelector()
+	}
+
+	groups := make(map[int64][]*OwnedUTXO)
+	var keys []int64
+	for _, utxo := range req.UTXOs {
+		if !req.isCandidate(utxo) {
+			continue
+		}
+		// -1 for utxos without label
+		key := int64(-1)
+		if utxo.Label != nil {
+			key = int64(utxo.Label.M)
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], utxo)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	var best *Selection
+	for _, key := range keys {
+		groupReq := *req
+		groupReq.UTXOs = groups[key]
+
+		selection, err := inner.SelectCoins(&groupReq)
+		if errors.Is(err, ErrInsufficientFunds) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if best == nil || selection.Waste < best.Waste {
+			best = selection
+		}
+	}
+
+	if best == nil {
+		logging.L.Debug().Int("groups", len(keys)).Msg("no single label group can fund the transaction")
+		return nil, fmt.Errorf("%w: %w", ErrInsufficientFunds, ErrNoSingleLabelSelection)
+	}
+	return best, nil
+}
@@ -0,0 +1,146 @@
+package wallet
+
+import (
+	"errors"
+	"math/rand/v2"
+	"reflect"
+	"testing"
+)
+
+func TestCoinSelectionStrategyNames(t *testing.T) {
+	for strategy := StrategyInOrder; strategy <= StrategyPrivacy; strategy++ {
+		parsed, err := ParseCoinSelectionStrategy(strategy.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed != strategy {
+			t.Fatalf("%s parsed as %s", strategy, parsed)
+		}
+		if _, err := strategy.Selector(); err != nil {
+			t.Fatalf("%s: %v", strategy, err)
+		}
+	}
+
+	if _, err := ParseCoinSelectionStrategy("random"); err == nil {
+		t.Fatal("parsed an unknown strategy")
+	}
+	if name := CoinSelectionStrategy(0).String(); name != "unknown(0)" {
+		t.Fatalf("name %s", name)
+	}
+	if _, err := CoinSelectionStrategy(0).Selector(); err == nil {
+		t.Fatal("selector for an unknown strategy")
+	}
+}
+
+func TestLargestFirstSelector(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 10_000)
+	large := fundTestWallet(t, w, 2, 50_000)
+	medium := fundTestWallet(t, w, 3, 30_000)
+
+	selection, err := (&LargestFirstSelector{}).SelectCoins(bnbRequest(w, recipient, w.UTXOs, 40_000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selection.UTXOs) != 1 || selection.UTXOs[0] != large {
+		t.Fatalf("selected %v", selection.UTXOs)
+	}
+
+	selection, err = (&LargestFirstSelector{}).SelectCoins(bnbRequest(w, recipient, w.UTXOs, 60_000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(selection.UTXOs, []*OwnedUTXO{large, medium}) {
+		t.Fatalf("selected %v", selection.UTXOs)
+	}
+}
+
+func TestOldestFirstSelector(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	// the timestamps increase with the seed
+	newest := fundTestWallet(t, w, 3, 10_000)
+	oldest := fundTestWallet(t, w, 1, 30_000)
+	middle := fundTestWallet(t, w, 2, 50_000)
+
+	selection, err := (&OldestFirstSelector{}).SelectCoins(bnbRequest(w, recipient, w.UTXOs, 35_000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(selection.UTXOs, []*OwnedUTXO{oldest, middle}) {
+		t.Fatalf("selected %v", selection.UTXOs)
+	}
+
+	// without timestamps the height decides
+	for i, utxo := range []*OwnedUTXO{newest, oldest, middle} {
+		utxo.Timestamp = 0
+		utxo.Height = uint64(100 + i)
+	}
+	selection, err = (&OldestFirstSelector{}).SelectCoins(bnbRequest(w, recipient, w.UTXOs, 35_000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(selection.UTXOs, []*OwnedUTXO{newest, oldest}) {
+		t.Fatalf("selected %v", selection.UTXOs)
+	}
+}
+
+func TestKnapsackSelector(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	utxos := fundTestUTXOs(t, w, 1, 4, 10_000)
+	utxos = append(utxos, fundTestWallet(t, w, 10, 25_000), fundTestWallet(t, w, 11, 40_000))
+
+	// 25_000 + 10_000 can be spent without change
+	amount := changelessAmount(t, w, recipient, utxos[4], utxos[0])
+	selector := &KnapsackSelector{Rand: rand.New(rand.NewPCG(1, 2))}
+	selection, err := selector.SelectCoins(bnbRequest(w, recipient, utxos, amount))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum uint64
+	for _, utxo := range selection.UTXOs {
+		sum += utxo.Amount
+	}
+	if sum != 35_000 || selection.Change != 0 {
+		t.Fatalf("selected %d sats in %d utxos, change %d", sum, len(selection.UTXOs), selection.Change)
+	}
+	if sum != amount+selection.Fee {
+		t.Fatalf("sum %d amount %d fee %d", sum, amount, selection.Fee)
+	}
+
+	_, err = selector.SelectCoins(bnbRequest(w, recipient, utxos, 110_000))
+	if !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("insufficient funds: %v", err)
+	}
+}
+
+// UTXOs of different labels are never combined
+func TestPrivacySelector(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	if _, err := w.ScanLabels(); err != nil {
+		t.Fatal(err)
+	}
+	labels := w.LabelSlice()
+	fundTestWalletAddress(t, w, labels[1].Address, 1, 20_000)
+	fundTestWalletAddress(t, w, labels[1].Address, 2, 20_000)
+	fundTestWalletAddress(t, w, labels[2].Address, 3, 30_000)
+	fundTestWallet(t, w, 4, 10_000)
+
+	selection, err := (&PrivacySelector{}).SelectCoins(bnbRequest(w, recipient, w.UTXOs, 35_000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selection.UTXOs) != 2 {
+		t.Fatalf("selected %d utxos", len(selection.UTXOs))
+	}
+	for _, utxo := range selection.UTXOs {
+		if utxo.Label == nil || utxo.Label.M != 1 {
+			t.Fatalf("selected utxo of label %v", utxo.Label)
+		}
+	}
+
+	// the wallet holds enough, but no single label does
+	_, err = (&PrivacySelector{}).SelectCoins(bnbRequest(w, recipient, w.UTXOs, 45_000))
+	if !errors.Is(err, ErrNoSingleLabelSelection) || !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("error %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/setavenger/blindbit-lib/logging"
+	"github.com/setavenger/blindbit-lib/types"
+)
+
+var (
+	ErrNoUTXOsToSweep = errors.New("no utxos to sweep")
+	ErrSweepDust      = errors.New("swept amount after fees is dust")
+	// ErrUTXONotSpendable is returned for utxos which are spent or about to be spent by a pending transaction
+	ErrUTXONotSpendable = errors.New("utxo is not spendable")
+)
+
+// UnspentUTXOs returns all utxos in StateUnspent which are not frozen, e.g. to sweep the whole wallet
+func (w *Wallet) UnspentUTXOs() UtxoCollection {
+	var utxos UtxoCollection
+	for _, utxo := range w.UTXOs {
+		if utxo.State != StateUnspent || utxo.Frozen {
+			continue
+		}
+		utxos = append(utxos, utxo)
+	}
+	return utxos
+}
+
+// LabelUTXOs returns the utxos in StateUnspent which were received on the label m and are not frozen
+func (w *Wallet) LabelUTXOs(m uint32) UtxoCollection {
+	var utxos UtxoCollection
+	for _, utxo := range w.UTXOs {
+		if utxo.State != StateUnspent || utxo.Frozen || utxo.Label == nil || utxo.Label.M != m {
+			continue
+		}
+		utxos = append(utxos, utxo)
+	}
+	return utxos
+}
+
+// Sweep spends all utxos to address without change (send max).
+// The recipient receives the sum of the utxos minus the exact fee at feeRate.
+// Every given utxo is spent, there is no coin selection. See UnspentUTXOs and LabelUTXOs to sweep the wallet or a label.
+// Only utxos in StateUnspent and StateUnconfirmed can be swept, fails with ErrUTXONotSpendable for any other state
+// and with ErrUTXOFrozen if one of them is frozen.
+// Fails with ErrSweepDust, wrapping a DustError, if the remaining amount would be dust (see Wallet.DustPolicy).
+func (w *Wallet) Sweep(
+	address string,
+	utxos UtxoCollection,
+	feeRate types.FeeRate,
+	markSpent bool,
+) (
+	[]byte, error,
+) {
+	if w.IsWatchOnly() {
+		return nil, ErrWatchOnly
+	}
+	if feeRate.IsZero() {
+		return nil, ErrInvalidFeeRate
+	}
+	if len(utxos) == 0 {
+		return nil, ErrNoUTXOsToSweep
+	}
+
+	chainParams, ok := types.NetworkParams[w.Network]
+	if !ok {
+		return nil, fmt.Errorf("unsupported network: %s", w.Network)
+	}
+
+	pkScripts, err := extractPkScriptsFromRecipients([]Recipient{&RecipientImpl{Address: address}}, chainParams)
+	if err != nil {
+		logging.L.Err(err).Str("address", address).Msg("Error extracting pkScripts")
+		return nil, err
+	}
+
+	estimator := NewWeightEstimator()
+	estimator.AddOutput(pkScripts[0])
+
+	var sum uint64
+	for _, utxo := range utxos {
+		if utxo.State != StateUnspent && utxo.State != StateUnconfirmed {
+			return nil, fmt.Errorf("%w: %x:%d is %s", ErrUTXONotSpendable, utxo.Txid, utxo.Vout, utxo.State)
+		}
+		if utxo.Frozen {
+			return nil, fmt.Errorf("%w: %x:%d", ErrUTXOFrozen, utxo.Txid, utxo.Vout)
+		}
+		sum += utxo.Amount
+		estimator.AddTaprootInput()
+	}
+
+	fee := estimator.Fee(feeRate)
+	threshold := w.DustPolicy().Threshold(pkScripts[0])
+	if sum < fee+threshold {
+		var amount uint64
+		if sum > fee {
+			amount = sum - fee
+		}
+		return nil, fmt.Errorf("%w: inputs %d fee %d: %w", ErrSweepDust, sum, fee, &DustError{
+			Address:   address,
+			Amount:    amount,
+			Threshold: threshold,
+		})
+	}
+
+	recipients := []Recipient{&RecipientImpl{
+		Address: address,
+		Amount:  sum - fee,
+	}}
+	selection := &Selection{
+		UTXOs: utxos,
+		Fee:   fee,
+	}
+
+	finalTx, err := w.signSelection(recipients, selection, feeRate, chainParams)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = finalTx.Serialize(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	sent := w.recordSentTransaction(finalTx, recipients, selection, feeRate)
+
+	if markSpent {
+		err = w.markPending(sent, utxos)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
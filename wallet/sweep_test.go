@@ -0,0 +1,99 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/setavenger/blindbit-lib/types"
+)
+
+func TestSweep(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, w, 1, 50_000)
+	fundTestWallet(t, w, 2, 70_000)
+
+	raw, err := w.Sweep(recipient.Address(), w.UnspentUTXOs(), types.FeeRateFromSatPerVByte(2), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := decodeTestTx(t, raw)
+	if len(tx.TxIn) != 2 || len(tx.TxOut) != 1 {
+		t.Fatalf("%d inputs %d outputs", len(tx.TxIn), len(tx.TxOut))
+	}
+	_, prevOuts, sum := testPrevOuts(t, w, tx)
+	verifyTestTx(t, tx, prevOuts)
+
+	fee := sum - sumTestOutputs(tx)
+	estimator := NewWeightEstimator()
+	estimator.AddOutput(tx.TxOut[0].PkScript)
+	estimator.AddTaprootInput()
+	estimator.AddTaprootInput()
+	if want := estimator.Fee(types.FeeRateFromSatPerVByte(2)); fee != want {
+		t.Fatalf("fee %d, want %d", fee, want)
+	}
+
+	if found := scanTestTx(t, recipient, w, tx); len(found) != 1 {
+		t.Fatalf("recipient found %d outputs", len(found))
+	}
+}
+
+func TestSweepRejectsUnspendableUTXOs(t *testing.T) {
+	for _, state := range []UTXOState{StateUnconfirmedSpent, StateSpent} {
+		w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+		fundTestWallet(t, w, 1, 50_000)
+		utxo := fundTestWallet(t, w, 2, 70_000)
+		utxo.State = state
+
+		_, err := w.Sweep(recipient.Address(), w.UTXOs, types.FeeRateFromSatPerVByte(2), true)
+		if !errors.Is(err, ErrUTXONotSpendable) {
+			t.Fatalf("%s: error %v, want ErrUTXONotSpendable", state, err)
+		}
+		if len(w.SentTransactions) != 0 {
+			t.Fatalf("%s: transaction recorded", state)
+		}
+	}
+}
+
+func TestSweepUnconfirmed(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	utxo := fundTestWallet(t, w, 1, 50_000)
+	utxo.State = StateUnconfirmed
+
+	_, err := w.Sweep(recipient.Address(), UtxoCollection{utxo}, types.FeeRateFromSatPerVByte(2), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if utxo.State != StateUnconfirmedSpent {
+		t.Fatalf("state %s", utxo.State)
+	}
+
+	// the pending sweep spends it already
+	_, err = w.Sweep(recipient.Address(), UtxoCollection{utxo}, types.FeeRateFromSatPerVByte(3), true)
+	if !errors.Is(err, ErrUTXONotSpendable) {
+		t.Fatalf("error %v, want ErrUTXONotSpendable", err)
+	}
+}
+
+func TestSweepErrors(t *testing.T) {
+	w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+	utxo := fundTestWallet(t, w, 1, 50_000)
+
+	if _, err := w.Sweep(recipient.Address(), nil, types.FeeRateFromSatPerVByte(2), false); !errors.Is(err, ErrNoUTXOsToSweep) {
+		t.Errorf("no utxos: %v", err)
+	}
+	if _, err := w.Sweep(recipient.Address(), w.UTXOs, types.FeeRate{}, false); !errors.Is(err, ErrInvalidFeeRate) {
+		t.Errorf("zero fee rate: %v", err)
+	}
+
+	utxo.Frozen = true
+	if _, err := w.Sweep(recipient.Address(), w.UTXOs, types.FeeRateFromSatPerVByte(2), false); !errors.Is(err, ErrUTXOFrozen) {
+		t.Errorf("frozen: %v", err)
+	}
+	utxo.Frozen = false
+
+	var dustErr *DustError
+	_, err := w.Sweep(recipient.Address(), w.UTXOs, types.FeeRateFromSatPerVByte(500), false)
+	if !errors.Is(err, ErrSweepDust) || !errors.As(err, &dustErr) {
+		t.Errorf("dust: %v", err)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/setavenger/blindbit-lib/utils"
 	"github.com/setavenger/go-bip352"
 )
@@ -20,7 +22,10 @@ type OwnedUTXO struct {
 	PubKey       [32]byte      `json:"pub_key"` // are always even hence we omit the parity byte
 	Timestamp    uint64        `json:"timestamp"`
 	State        UTXOState     `json:"utxo_state"`
-	Label        *bip352.Label `json:"label"` // the pubKey associated with the label
+	Label        *bip352.Label `json:"label"`                  // the pubKey associated with the label
+	Height       uint64        `json:"height,omitempty"`       // height of the block the utxo was found in, 0 if unknown
+	SpentHeight  uint64        `json:"spent_height,omitempty"` // height of the block the utxo was spent in, 0 if not known to be spent
+	Frozen       bool          `json:"frozen,omitempty"`       // frozen utxos are never selected to fund a transaction
 }
 
 // OwnedUtxoJSON is an alias/helper. Better for conversion in json to hex etc.
@@ -33,6 +38,9 @@ type OwnedUtxoJSON struct {
 	Timestamp    uint64           `json:"timestamp"`
 	State        UTXOState        `json:"utxo_state"`
 	Label        *Bip352LabelJSON `json:"label"` // the pubKey associated with the label
+	Height       uint64           `json:"height,omitempty"`
+	SpentHeight  uint64           `json:"spent_height,omitempty"`
+	Frozen       bool             `json:"frozen,omitempty"`
 }
 
 func (u OwnedUTXO) MarshalJSON() ([]byte, error) {
@@ -54,6 +62,9 @@ func (u OwnedUTXO) MarshalJSON() ([]byte, error) {
 		Timestamp:    u.Timestamp,
 		State:        u.State,
 		Label:        label,
+		Height:       u.Height,
+		SpentHeight:  u.SpentHeight,
+		Frozen:       u.Frozen,
 	}
 
 	return json.Marshal(newUtxo)
@@ -95,6 +106,9 @@ func (u *OwnedUTXO) UnmarshalJSON(data []byte) error {
 		Timestamp:    aux.Timestamp,
 		State:        aux.State,
 		Label:        label,
+		Height:       aux.Height,
+		SpentHeight:  aux.SpentHeight,
+		Frozen:       aux.Frozen,
 	}
 	return err
 }
@@ -113,6 +127,14 @@ func (u OwnedUTXO) SerialiseToOutpoint() ([36]byte, error) {
 	return outpoint, nil
 }
 
+// OutPoint returns the outpoint of the utxo as used in transactions
+func (u *OwnedUTXO) OutPoint() wire.OutPoint {
+	return wire.OutPoint{
+		Hash:  chainhash.Hash(bip352.ReverseBytesCopy(u.Txid[:])),
+		Index: u.Vout,
+	}
+}
+
 func (u *OwnedUTXO) LabelPubKey() []byte {
 	if u.Label != nil {
 		return u.Label.PubKey[:]
@@ -138,6 +160,15 @@ func (u *OwnedUTXO) GetKey() ([36]byte, error) {
 
 type UtxoCollection []*OwnedUTXO
 
+func containsUTXO(utxos []*OwnedUTXO, utxo *OwnedUTXO) bool {
+	for _, u := range utxos {
+		if u.Txid == utxo.Txid && u.Vout == utxo.Vout {
+			return true
+		}
+	}
+	return false
+}
+
 // UTXOMapping
 // the key is the utxos (txid||vout)
 // todo marshalling or unmarshalling seems to have some issues. Investigate root cause.
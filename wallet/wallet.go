@@ -4,6 +4,10 @@
 package wallet
 
 import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/setavenger/blindbit-lib/logging"
 	"github.com/setavenger/blindbit-lib/types"
 	"github.com/setavenger/go-bip352"
 )
@@ -20,7 +24,56 @@ type Wallet struct {
 	UTXOs          UtxoCollection  `json:"utxos,omitempty"`
 	Labels         LabelMap        `json:"labels"` // Labels contains all labels except for the change label
 	labelSlice     []*bip352.Label `json:"-"`
-	UTXOMapping    UTXOMapping     `json:"utxo_mapping"` // used to keep track of utxos and not add the same twice
+	UTXOMapping    UTXOMapping     `json:"utxo_mapping"`          // used to keep track of utxos and not add the same twice
+	Checkpoints    Checkpoints     `json:"checkpoints,omitempty"` // recently scanned block hashes, used to detect reorgs
+	// SentTransactions are the transactions created by the wallet, needed to replace them later (see BumpFee)
+	SentTransactions []*SentTransaction `json:"sent_transactions,omitempty"`
+	// LabelLookahead is the number of labels above the highest used label which are scanned for.
+	// Similar to the gap limit of address based wallets. Wallets stored without it get DefaultLabelLookahead.
+	LabelLookahead uint32 `json:"label_lookahead"`
+	// DustRelayFeeRate decides which outputs are dust, the zero value uses DefaultDustRelayFeeRate (see DustPolicy)
+	DustRelayFeeRate types.FeeRate `json:"dust_relay_fee_rate,omitzero"`
+	signer           Signer        // signs instead of SecretKeySpend if set, see SetSigner
+}
+
+// DefaultLabelLookahead is a sensible LabelLookahead for wallets that hand out labels.
+// Every label adds work per tweak while scanning, so the window should be kept small.
+const DefaultLabelLookahead = 5
+
+// NewWallet creates a wallet from the scan and spend secret keys
+func NewWallet(
+	scanSecret, spendSecret [32]byte,
+	network types.Network,
+	birthHeight uint64,
+) (*Wallet, error) {
+	if _, ok := types.NetworkParams[network]; !ok {
+		return nil, fmt.Errorf("unsupported network: %s", network)
+	}
+
+	return &Wallet{
+		Network:        network,
+		SecretKeyScan:  scanSecret,
+		PubKeyScan:     *bip352.PubKeyFromSecKey(&scanSecret),
+		SecretKeySpend: spendSecret,
+		PubKeySpend:    *bip352.PubKeyFromSecKey(&spendSecret),
+		BirthHeight:    birthHeight,
+		Labels:         make(LabelMap),
+		UTXOMapping:    make(UTXOMapping),
+		LabelLookahead: DefaultLabelLookahead,
+	}, nil
+}
+
+// UnmarshalJSON sets LabelLookahead to DefaultLabelLookahead for wallets which were stored without it.
+// An explicitly stored 0 is kept.
+func (w *Wallet) UnmarshalJSON(data []byte) error {
+	type walletJSON Wallet
+	aux := walletJSON(*w)
+	aux.LabelLookahead = DefaultLabelLookahead
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*w = Wallet(aux)
+	return nil
 }
 
 // Address of wallet
@@ -84,3 +137,96 @@ func (w *Wallet) ComputeLabelForM(m uint32) (err error) {
 	w.labelSlice[m] = &l
 	return
 }
+
+// HighestUsedLabel returns the highest m of all labels which received funds or were explicitly added to Wallet.Labels
+func (w *Wallet) HighestUsedLabel() uint32 {
+	var highest uint32
+	for _, utxo := range w.UTXOs {
+		if utxo.Label != nil && utxo.Label.M > highest {
+			highest = utxo.Label.M
+		}
+	}
+	for _, label := range w.Labels {
+		if label != nil && label.M > highest {
+			highest = label.M
+		}
+	}
+	return highest
+}
+
+// ScanLabels returns all labels the wallet has to scan for.
+// These are the change label (m = 0), all labels up to HighestUsedLabel + LabelLookahead and the labels in Wallet.Labels.
+// Missing labels are computed, so the window grows automatically once a high label receives funds.
+func (w *Wallet) ScanLabels() ([]*bip352.Label, error) {
+	maxM := w.HighestUsedLabel() + w.LabelLookahead
+
+	// start with the largest m so labelSlice is only extended once
+	for m := int64(maxM); m >= 0; m-- {
+		if int(m) < len(w.labelSlice) && w.labelSlice[m] != nil {
+			continue
+		}
+		err := w.ComputeLabelForM(uint32(m))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	labels := make([]*bip352.Label, 0, len(w.labelSlice)+len(w.Labels))
+	seen := make(map[uint32]struct{}, len(w.labelSlice))
+	for _, label := range w.labelSlice {
+		if label == nil {
+			continue
+		}
+		seen[label.M] = struct{}{}
+		labels = append(labels, label)
+	}
+	for _, label := range w.Labels {
+		if label == nil {
+			continue
+		}
+		if _, ok := seen[label.M]; ok {
+			continue
+		}
+		seen[label.M] = struct{}{}
+		labels = append(labels, label)
+	}
+	return labels, nil
+}
+
+// AddUTXOs adds the utxos to the wallet.
+// UTXOs which are already known to the wallet (see UTXOMapping) are skipped,
+// unconfirmed ones (e.g. pending change) take the height and state of the given utxo.
+// Returns the number of utxos that were actually added.
+func (w *Wallet) AddUTXOs(utxos ...*OwnedUTXO) (int, error) {
+	if w.UTXOMapping == nil {
+		w.UTXOMapping = make(UTXOMapping)
+	}
+
+	var added int
+	for _, utxo := range utxos {
+		key, err := utxo.GetKey()
+		if err != nil {
+			logging.L.Err(err).Msg("failed to compute utxo key")
+			return added, err
+		}
+		if _, ok := w.UTXOMapping[key]; ok {
+			w.confirmUTXO(utxo)
+			continue
+		}
+		w.UTXOMapping[key] = struct{}{}
+		w.UTXOs = append(w.UTXOs, utxo)
+		added++
+	}
+	return added, nil
+}
+
+// FindUTXO returns the utxo of the wallet with the given outpoint, nil if it is not known.
+// txid has the same byte order as OwnedUTXO.Txid.
+func (w *Wallet) FindUTXO(txid [32]byte, vout uint32) *OwnedUTXO {
+	for _, utxo := range w.UTXOs {
+		if utxo.Txid == txid && utxo.Vout == vout {
+			return utxo
+		}
+	}
+	return nil
+}
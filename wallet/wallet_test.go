@@ -0,0 +1,108 @@
+package wallet
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/setavenger/blindbit-lib/types"
+)
+
+func TestNewWalletLabelLookahead(t *testing.T) {
+	w := newTestWallet(t, 1)
+	if w.LabelLookahead != DefaultLabelLookahead {
+		t.Fatalf("label lookahead %d", w.LabelLookahead)
+	}
+
+	watchOnly, err := NewWatchOnlyWallet(w.SecretKeyScan, w.PubKeySpend, types.NetworkSignet, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if watchOnly.LabelLookahead != DefaultLabelLookahead {
+		t.Fatalf("watch-only label lookahead %d", watchOnly.LabelLookahead)
+	}
+
+	labels, err := w.ScanLabels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the change label and the lookahead window
+	if len(labels) != DefaultLabelLookahead+1 {
+		t.Fatalf("%d scan labels", len(labels))
+	}
+}
+
+func TestWalletJSONLabelLookahead(t *testing.T) {
+	for _, lookahead := range []uint32{0, 1, DefaultLabelLookahead, 20} {
+		w := newTestWallet(t, 1)
+		w.LabelLookahead = lookahead
+
+		data, err := json.Marshal(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Wallet
+		if err = json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.LabelLookahead != lookahead {
+			t.Fatalf("label lookahead %d decoded as %d", lookahead, decoded.LabelLookahead)
+		}
+		if decoded.PubKeySpend != w.PubKeySpend || decoded.Network != w.Network {
+			t.Fatal("wallet fields were not decoded")
+		}
+	}
+
+	// wallets stored before the lookahead existed
+	w := newTestWallet(t, 1)
+	data, err := json.Marshal(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	delete(fields, "label_lookahead")
+	if data, err = json.Marshal(fields); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Wallet
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.LabelLookahead != DefaultLabelLookahead {
+		t.Fatalf("label lookahead of an old wallet %d", decoded.LabelLookahead)
+	}
+}
+
+func TestScanLabelsWindowGrows(t *testing.T) {
+	w := newTestWallet(t, 1)
+	if err := w.ComputeLabelForM(4); err != nil {
+		t.Fatal(err)
+	}
+	fundTestWalletAddress(t, w, w.LabelSlice()[4].Address, 1, 10_000)
+	if highest := w.HighestUsedLabel(); highest != 4 {
+		t.Fatalf("highest used label %d", highest)
+	}
+
+	labels, err := w.ScanLabels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 4+DefaultLabelLookahead+1 {
+		t.Fatalf("%d scan labels", len(labels))
+	}
+
+	// labels added explicitly count as used and move the window as well
+	if err = w.ComputeLabelForM(100); err != nil {
+		t.Fatal(err)
+	}
+	label := w.LabelSlice()[100]
+	w.Labels[label.PubKey] = label
+	if labels, err = w.ScanLabels(); err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 100+DefaultLabelLookahead+1 {
+		t.Fatalf("%d scan labels", len(labels))
+	}
+}
@@ -0,0 +1,41 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/setavenger/blindbit-lib/types"
+	"github.com/setavenger/go-bip352"
+)
+
+var ErrWatchOnly = errors.New("wallet is watch-only and can not sign")
+
+// NewWatchOnlyWallet creates a wallet which only holds the scan secret key and the spend public key.
+// It can scan, track balances and build unsigned BIP375 PSBTs (see CreatePsbt) but not sign them.
+// The ECDH shares, silent payment outputs and signatures are added by the holder of the spend key.
+func NewWatchOnlyWallet(
+	scanSecret [32]byte,
+	spendPubKey [33]byte,
+	network types.Network,
+	birthHeight uint64,
+) (*Wallet, error) {
+	if _, ok := types.NetworkParams[network]; !ok {
+		return nil, fmt.Errorf("unsupported network: %s", network)
+	}
+
+	return &Wallet{
+		Network:        network,
+		SecretKeyScan:  scanSecret,
+		PubKeyScan:     *bip352.PubKeyFromSecKey(&scanSecret),
+		PubKeySpend:    spendPubKey,
+		BirthHeight:    birthHeight,
+		Labels:         make(LabelMap),
+		UTXOMapping:    make(UTXOMapping),
+		LabelLookahead: DefaultLabelLookahead,
+	}, nil
+}
+
+// IsWatchOnly returns true if the wallet has neither a spend secret key nor a Signer (see SetSigner)
+func (w *Wallet) IsWatchOnly() bool {
+	return w.Signer() == nil
+}
@@ -0,0 +1,113 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/setavenger/blindbit-lib/types"
+)
+
+// newTestWatchOnlyWallet returns a watch-only wallet for the keys of newTestWallet(t, seed)
+func newTestWatchOnlyWallet(t *testing.T, seed byte) *Wallet {
+	t.Helper()
+	full := newTestWallet(t, seed)
+	w, err := NewWatchOnlyWallet(full.SecretKeyScan, full.PubKeySpend, full.Network, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.IsWatchOnly() {
+		t.Fatal("wallet is not watch-only")
+	}
+	return w
+}
+
+// transferPsbt serialises the packet and parses it again, as if it was handed to another process
+func transferPsbt(t *testing.T, packet *psbt.Packet) *psbt.Packet {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := packet.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := psbt.NewFromRawBytes(&buf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+// The watch-only wallet builds the PSBT, the holder of the spend key completes and signs it
+func TestWatchOnlyCreatePsbt(t *testing.T) {
+	watchOnly, recipient := newTestWatchOnlyWallet(t, 1), newTestWallet(t, 2)
+	fundTestWallet(t, watchOnly, 1, 50_000)
+	fundTestWallet(t, watchOnly, 2, 70_000)
+
+	packet, err := watchOnly.CreatePsbt(
+		[]Recipient{&RecipientImpl{Address: recipient.Address(), Amount: 60_000}},
+		watchOnly.UnspentUTXOs(), types.FeeRateFromSatPerVByte(2), watchOnly.DustPolicy().ChangeThreshold(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ComputeSilentPaymentOutputs(packet); !errors.Is(err, ErrMissingEcdhShare) {
+		t.Fatalf("outputs computed without ecdh shares: %v", err)
+	}
+	if err = watchOnly.AddEcdhShares(packet); !errors.Is(err, ErrWatchOnly) {
+		t.Fatalf("watch-only wallet added ecdh shares: %v", err)
+	}
+
+	signer := newTestWallet(t, 1)
+	packet = transferPsbt(t, packet)
+	if err = signer.AddEcdhShares(packet); err != nil {
+		t.Fatal(err)
+	}
+	if err = ComputeSilentPaymentOutputs(packet); err != nil {
+		t.Fatal(err)
+	}
+	if err = signer.SignPsbt(packet); err != nil {
+		t.Fatal(err)
+	}
+	if err = psbt.MaybeFinalizeAll(packet); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := psbt.Extract(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, prevOuts, _ := testPrevOuts(t, watchOnly, tx)
+	verifyTestTx(t, tx, prevOuts)
+	if found := scanTestTx(t, recipient, watchOnly, tx); len(found) != 1 || found[0].Output == [32]byte{} {
+		t.Fatalf("recipient found %d outputs", len(found))
+	}
+	// the change goes to the change label of the wallet
+	found := scanTestTx(t, watchOnly, watchOnly, tx)
+	if len(found) != 1 || found[0].Label == nil || found[0].Label.M != 0 {
+		t.Fatalf("change found %v", found)
+	}
+}
+
+func TestWatchOnlyCanNotSign(t *testing.T) {
+	w, recipient := newTestWatchOnlyWallet(t, 1), newTestWallet(t, 2)
+	utxo := fundTestWallet(t, w, 1, 50_000)
+	recipients := []Recipient{&RecipientImpl{Address: recipient.Address(), Amount: 10_000}}
+
+	if _, err := w.SendToRecipients(
+		recipients, w.UnspentUTXOs(), types.FeeRateFromSatPerVByte(2), w.DustPolicy().ChangeThreshold(), false, false,
+	); !errors.Is(err, ErrWatchOnly) {
+		t.Errorf("send: %v", err)
+	}
+	if _, err := w.Sweep(recipient.Address(), w.UnspentUTXOs(), types.FeeRateFromSatPerVByte(2), false); !errors.Is(err, ErrWatchOnly) {
+		t.Errorf("sweep: %v", err)
+	}
+	utxo.State = StateUnconfirmed
+	if _, err := w.CreateCPFP(utxo, 150, 0, types.FeeRateFromSatPerVByte(2)); !errors.Is(err, ErrWatchOnly) {
+		t.Errorf("cpfp: %v", err)
+	}
+
+	packet, err := w.CreatePsbt(recipients, UtxoCollection{}, types.FeeRateFromSatPerVByte(2), 0)
+	if err == nil || packet != nil {
+		t.Errorf("psbt without utxos: %v", err)
+	}
+}
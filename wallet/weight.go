@@ -0,0 +1,165 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/setavenger/blindbit-lib/types"
+)
+
+// Witness element sizes of the supported input types
+const (
+	// TaprootKeySpendSigLen is a schnorr signature with SigHashDefault (no sighash byte)
+	TaprootKeySpendSigLen = 64
+	// P2WPKHSigLen is the maximum length of a DER encoded ecdsa signature including the sighash byte
+	P2WPKHSigLen    = 73
+	P2WPKHPubKeyLen = 33
+)
+
+// TaprootOutputVSize is the size of a taproot output (value, script length, script)
+const TaprootOutputVSize = 8 + 1 + ScriptPubKeyTaprootLen
+
+var (
+	ErrUnsupportedInputType = errors.New("unsupported input type for weight estimation")
+	ErrFeeTooLow            = errors.New("transaction pays less fees than requested")
+	ErrFeeTooHigh           = errors.New("transaction pays more fees than requested")
+)
+
+// WeightEstimator computes the exact weight of a transaction before it is signed.
+// It builds a template transaction with dummy witnesses of the final size,
+// so all varints and the segwit marker are accounted for correctly.
+type WeightEstimator struct {
+	tx *wire.MsgTx
+}
+
+func NewWeightEstimator() *WeightEstimator {
+	return &WeightEstimator{
+		tx: wire.NewMsgTx(2),
+	}
+}
+
+// AddTaprootInput adds a taproot key path spend
+func (e *WeightEstimator) AddTaprootInput() {
+	e.addInput(wire.TxWitness{make([]byte, TaprootKeySpendSigLen)})
+}
+
+// AddInput adds an input spending pkScript.
+// Supported are taproot (key path) and P2WPKH outputs.
+func (e *WeightEstimator) AddInput(pkScript []byte) error {
+	switch txscript.GetScriptClass(pkScript) {
+	case txscript.WitnessV1TaprootTy:
+		e.AddTaprootInput()
+	case txscript.WitnessV0PubKeyHashTy:
+		e.addInput(wire.TxWitness{make([]byte, P2WPKHSigLen), make([]byte, P2WPKHPubKeyLen)})
+	default:
+		return fmt.Errorf("%w: %x", ErrUnsupportedInputType, pkScript)
+	}
+	return nil
+}
+
+func (e *WeightEstimator) addInput(witness wire.TxWitness) {
+	txIn := wire.NewTxIn(&wire.OutPoint{}, nil, witness)
+	e.tx.AddTxIn(txIn)
+}
+
+// AddOutput adds an output with pkScript.
+// For silent payment outputs any 34 byte taproot script can be used as placeholder.
+func (e *WeightEstimator) AddOutput(pkScript []byte) {
+	e.tx.AddTxOut(wire.NewTxOut(0, pkScript))
+}
+
+// Weight returns the weight of the transaction in weight units
+func (e *WeightEstimator) Weight() int64 {
+	return blockchain.GetTransactionWeight(btcutil.NewTx(e.tx))
+}
+
+// VSize returns the virtual size in vBytes, rounded up
+func (e *WeightEstimator) VSize() int64 {
+	return weightToVSize(e.Weight())
+}
+
+// Fee returns the absolute fee in sats needed to reach feeRate
+func (e *WeightEstimator) Fee(feeRate types.FeeRate) uint64 {
+	return NeededFeeAbsolutSats(e.VSize(), feeRate)
+}
+
+// Clone returns an independent copy of the estimator
+func (e *WeightEstimator) Clone() *WeightEstimator {
+	return &WeightEstimator{
+		tx: e.tx.Copy(),
+	}
+}
+
+func weightToVSize(weight int64) int64 {
+	return (weight + blockchain.WitnessScaleFactor - 1) / blockchain.WitnessScaleFactor
+}
+
+// TxVSize returns the virtual size of a (signed) transaction
+func TxVSize(tx *wire.MsgTx) int64 {
+	return weightToVSize(blockchain.GetTransactionWeight(btcutil.NewTx(tx)))
+}
+
+// VerifyFeeRate checks the fee of a signed transaction against the requested feeRate.
+// inputSum is the sum of all spent amounts.
+// Fails with ErrFeeTooLow if the fee rate is below feeRate
+// and with ErrFeeTooHigh if more than maxOverpay sats above the needed fee are paid.
+func VerifyFeeRate(tx *wire.MsgTx, inputSum uint64, feeRate types.FeeRate, maxOverpay uint64) error {
+	var outputSum uint64
+	for _, txOut := range tx.TxOut {
+		outputSum += uint64(txOut.Value)
+	}
+	if outputSum > inputSum {
+		return fmt.Errorf("outputs (%d) exceed inputs (%d)", outputSum, inputSum)
+	}
+
+	vSize := TxVSize(tx)
+	actualFee := inputSum - outputSum
+	neededFee := NeededFeeAbsolutSats(vSize, feeRate)
+
+	if actualFee < neededFee {
+		return fmt.Errorf("%w: paid %d needed %d for %d vB", ErrFeeTooLow, actualFee, neededFee, vSize)
+	}
+	if actualFee > neededFee+maxOverpay {
+		return fmt.Errorf("%w: paid %d needed %d for %d vB", ErrFeeTooHigh, actualFee, neededFee, vSize)
+	}
+	return nil
+}
+
+// VerifyPackageFeeRate checks the fee of a signed child transaction which pays for its unconfirmed parent (CPFP).
+// parentVSize and parentFee describe the parent. The child has to pay CPFPChildFee,
+// so parent and child together reach feeRate and the child alone at least MinRelayFeeRate.
+// Fails with ErrFeeTooLow if less is paid and with ErrFeeTooHigh if more than maxOverpay sats above it are paid.
+func VerifyPackageFeeRate(
+	tx *wire.MsgTx,
+	inputSum uint64,
+	parentVSize int64,
+	parentFee uint64,
+	feeRate types.FeeRate,
+	maxOverpay uint64,
+) error {
+	var outputSum uint64
+	for _, txOut := range tx.TxOut {
+		outputSum += uint64(txOut.Value)
+	}
+	if outputSum > inputSum {
+		return fmt.Errorf("outputs (%d) exceed inputs (%d)", outputSum, inputSum)
+	}
+
+	vSize := TxVSize(tx)
+	actualFee := inputSum - outputSum
+	neededFee := CPFPChildFee(parentVSize, parentFee, vSize, feeRate)
+
+	if actualFee < neededFee {
+		return fmt.Errorf("%w: package of parent and child pays %d needed %d for %d vB",
+			ErrFeeTooLow, parentFee+actualFee, parentFee+neededFee, parentVSize+vSize)
+	}
+	if actualFee > neededFee+maxOverpay {
+		return fmt.Errorf("%w: package of parent and child pays %d needed %d for %d vB",
+			ErrFeeTooHigh, parentFee+actualFee, parentFee+neededFee, parentVSize+vSize)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/setavenger/blindbit-lib/types"
+)
+
+func TestWeightEstimatorKnownSizes(t *testing.T) {
+	taproot := make([]byte, ScriptPubKeyTaprootLen)
+	taproot[0], taproot[1] = 0x51, 0x20
+	p2wpkh := append([]byte{0x00, 0x14}, make([]byte, 20)...)
+
+	tests := []struct {
+		name    string
+		inputs  [][]byte
+		outputs [][]byte
+		vSize   int64
+	}{
+		{"1 taproot in 1 out", [][]byte{taproot}, [][]byte{taproot}, 111},
+		{"2 taproot in 2 out", [][]byte{taproot, taproot}, [][]byte{taproot, taproot}, 212},
+		{"1 p2wpkh in 1 p2wpkh out", [][]byte{p2wpkh}, [][]byte{p2wpkh}, 110},
+	}
+	for _, test := range tests {
+		estimator := NewWeightEstimator()
+		for _, input := range test.inputs {
+			if err := estimator.AddInput(input); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, output := range test.outputs {
+			estimator.AddOutput(output)
+		}
+		if vSize := estimator.VSize(); vSize != test.vSize {
+			t.Errorf("%s: %d vB, want %d", test.name, vSize, test.vSize)
+		}
+		if fee := estimator.Fee(types.FeeRateFromSatPerVByte(3)); fee != uint64(3*test.vSize) {
+			t.Errorf("%s: fee %d", test.name, fee)
+		}
+	}
+
+	if err := NewWeightEstimator().AddInput([]byte{0x76, 0xa9}); !errors.Is(err, ErrUnsupportedInputType) {
+		t.Fatalf("p2pkh input: %v", err)
+	}
+}
+
+func TestWeightEstimatorClone(t *testing.T) {
+	estimator := NewWeightEstimator()
+	estimator.AddTaprootInput()
+	clone := estimator.Clone()
+	clone.AddTaprootInput()
+	if estimator.Weight() == clone.Weight() {
+		t.Fatal("clone shares the transaction")
+	}
+}
+
+// The estimate of a transaction has to match the signed transaction exactly
+func TestWeightEstimatorMatchesSignedTx(t *testing.T) {
+	for inputs := 1; inputs <= 3; inputs++ {
+		w, recipient := newTestWallet(t, 1), newTestWallet(t, 2)
+		for i := 0; i < inputs; i++ {
+			fundTestWallet(t, w, byte(i+1), 20_000)
+		}
+		// leaves change
+		raw, err := w.SendToRecipients(
+			[]Recipient{&RecipientImpl{Address: recipient.Address(), Amount: uint64(inputs)*20_000 - 10_000}},
+			w.UnspentUTXOs(), types.FeeRateFromSatPerVByte(2), w.DustPolicy().ChangeThreshold(), false, false,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx := decodeTestTx(t, raw)
+		if len(tx.TxIn) != inputs || len(tx.TxOut) != 2 {
+			t.Fatalf("%d inputs %d outputs", len(tx.TxIn), len(tx.TxOut))
+		}
+
+		estimator := NewWeightEstimator()
+		for range tx.TxIn {
+			estimator.AddTaprootInput()
+		}
+		for _, txOut := range tx.TxOut {
+			estimator.AddOutput(txOut.PkScript)
+		}
+		if estimated, actual := estimator.VSize(), TxVSize(tx); estimated != actual {
+			t.Fatalf("%d inputs: estimated %d vB, signed %d vB", inputs, estimated, actual)
+		}
+
+		_, _, sum := testPrevOuts(t, w, tx)
+		if err := VerifyFeeRate(tx, sum, types.FeeRateFromSatPerVByte(2), 0); err != nil {
+			t.Fatalf("%d inputs: %v", inputs, err)
+		}
+	}
+}
+
+func TestVerifyFeeRate(t *testing.T) {
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, wire.TxWitness{make([]byte, TaprootKeySpendSigLen)}))
+	tx.AddTxOut(wire.NewTxOut(10_000, make([]byte, ScriptPubKeyTaprootLen)))
+	vSize := TxVSize(tx)
+
+	tests := []struct {
+		inputSum uint64
+		err      error
+	}{
+		{10_000 + uint64(2*vSize), nil},
+		{10_000 + uint64(2*vSize) + 5, nil},
+		{10_000 + uint64(2*vSize) - 1, ErrFeeTooLow},
+		{10_000 + uint64(2*vSize) + 6, ErrFeeTooHigh},
+	}
+	for _, test := range tests {
+		err := VerifyFeeRate(tx, test.inputSum, types.FeeRateFromSatPerVByte(2), 5)
+		if !errors.Is(err, test.err) {
+			t.Errorf("input sum %d: error %v, want %v", test.inputSum, err, test.err)
+		}
+	}
+
+	if err := VerifyFeeRate(tx, 9_999, types.FeeRateFromSatPerVByte(2), 5); err == nil {
+		t.Fatal("outputs exceed inputs")
+	}
+}